@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// deviceReport is the result of checking a single device
+type deviceReport struct {
+	url    string
+	device *discovery.Device
+	err    error
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the exporter configuration",
+	}
+
+	cmd.AddCommand(newConfigCheckCmd())
+
+	return cmd
+}
+
+func newConfigCheckCmd() *cobra.Command {
+	var (
+		cfgFile string
+		probe   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Validate the configuration and optionally probe the configured devices",
+		Long: `Loads and validates the configuration. With --probe every configured device is
+queried for reachability, authentication and generation, and a report is printed.
+The command exits with a non-zero code if the configuration is invalid or a device fails.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return checkConfig(cmd.Context(), cmd.OutOrStdout(), cfgFile, probe)
+		},
+	}
+
+	cmd.Flags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.shelly-exporter.yaml)")
+	cmd.Flags().BoolVar(&probe, "probe", false, "Probe each configured device")
+
+	return cmd
+}
+
+// checkConfig loads the configuration, probes the devices if requested and prints a report
+func checkConfig(ctx context.Context, out io.Writer, cfgFile string, probe bool) error {
+	// Load validates the configuration
+	cfg, err := config.Load(cfgFile)
+	if err == nil {
+		if _, levelErr := logrus.ParseLevel(cfg.LogLevel); levelErr != nil {
+			err = fmt.Errorf("invalid log level: %w", levelErr)
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "Configuration is invalid: %v\n", err)
+		return fmt.Errorf("configuration check failed")
+	}
+
+	file := cfg.ConfigFile
+	if file == "" {
+		file = "(none, defaults and environment)"
+	}
+	_, _ = fmt.Fprintf(out, "Configuration file: %s\n", file)
+	_, _ = fmt.Fprintf(out, "Configuration is valid, %d devices configured\n", len(cfg.ShellyDevices))
+
+	if !probe || len(cfg.ShellyDevices) == 0 {
+		return nil
+	}
+
+	reports := make([]deviceReport, 0, len(cfg.ShellyDevices))
+	for _, device := range cfg.ShellyDevices {
+		reports = append(reports, checkDevice(ctx, cfg, device.URL))
+	}
+
+	_, _ = fmt.Fprintln(out)
+	if err := writeReport(out, reports); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	failed := 0
+	for _, report := range reports {
+		if report.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d devices failed the check", failed, len(reports))
+	}
+	return nil
+}
+
+// checkDevice identifies the device through /shelly and fetches its status with the configured credentials
+func checkDevice(ctx context.Context, cfg *config.Config, url string) deviceReport {
+	report := deviceReport{url: url}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ScrapeTimeout)
+	defer cancel()
+
+	httpClient, err := client.NewHTTPClient(cfg)
+	if err != nil {
+		report.err = err
+		return report
+	}
+
+	device, err := discovery.Probe(ctx, httpClient, discovery.Candidate{URL: url})
+	if err != nil {
+		report.err = fmt.Errorf("unreachable: %w", err)
+		return report
+	}
+	report.device = device
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	if _, err := client.New(url, cfg, logger).GetStatus(ctx); err != nil {
+		if errors.Is(err, client.ErrAuthRequired) {
+			err = fmt.Errorf("authentication required, set auth.password")
+		}
+		report.err = err
+	}
+	return report
+}
+
+// writeReport prints one line per device
+func writeReport(out io.Writer, reports []deviceReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DEVICE\tGEN\tMODEL\tAUTH\tRESULT")
+
+	for _, report := range reports {
+		gen, model, auth := "-", "-", "-"
+		if report.device != nil {
+			gen = strconv.Itoa(report.device.Gen)
+			model = report.device.Model
+			auth = "no"
+			if report.device.AuthEnabled {
+				auth = "yes"
+			}
+		}
+
+		result := "ok"
+		if report.err != nil {
+			result = report.err.Error()
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", config.RedactURL(report.url), gen, model, auth, result)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCheckTestDevice starts a Gen2 device answering /shelly and Shelly.GetStatus over GET and POST /rpc
+func newCheckTestDevice(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	device := httptest.NewServer(checkTestHandler())
+	t.Cleanup(device.Close)
+
+	return device
+}
+
+// checkTestHandler answers like a Gen2 device
+func checkTestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/shelly":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"mac":     "AABBCCDDEEFF",
+				"model":   "SPEM-003CEBEU",
+				"gen":     2,
+				"app":     "Pro3EM",
+				"auth_en": false,
+			})
+		case "/rpc/Shelly.GetStatus":
+			_ = json.NewEncoder(w).Encode(client.StatusResponse{})
+		case "/rpc":
+			var req struct {
+				ID int `json:"id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":     req.ID,
+				"result": client.StatusResponse{},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func writeCheckConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	configFile := t.TempDir() + "/config.yaml"
+	require.NoError(t, os.WriteFile(configFile, []byte(content), 0644))
+	return configFile
+}
+
+func TestNewConfigCheckCmd(t *testing.T) {
+	cmd := newRootCmd()
+
+	check, _, err := cmd.Find([]string{"config", "check"})
+	require.NoError(t, err)
+	assert.Equal(t, "check", check.Use)
+	assert.NotNil(t, check.Flags().Lookup("config"))
+	assert.NotNil(t, check.Flags().Lookup("probe"))
+}
+
+func TestCheckConfig_Valid(t *testing.T) {
+	device := newCheckTestDevice(t)
+	configFile := writeCheckConfig(t, `
+shelly_devices:
+  - "`+device.URL+`"
+`)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"config", "check", "--config", configFile, "--probe"})
+
+	err := cmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Configuration is valid, 1 devices configured")
+	assert.Contains(t, out.String(), "SPEM-003CEBEU")
+	assert.Contains(t, out.String(), "ok")
+}
+
+func TestCheckConfig_TLS(t *testing.T) {
+	device := httptest.NewTLSServer(checkTestHandler())
+	t.Cleanup(device.Close)
+	configFile := writeCheckConfig(t, `
+shelly_devices:
+  - "`+device.URL+`"
+tls:
+  enabled: true
+  insecure_skip_verify: true
+`)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"config", "check", "--config", configFile, "--probe"})
+
+	err := cmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "SPEM-003CEBEU")
+}
+
+func TestCheckConfig_Invalid(t *testing.T) {
+	configFile := writeCheckConfig(t, `
+shelly_devices: []
+`)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"config", "check", "--config", configFile})
+
+	err := cmd.Execute()
+
+	assert.Error(t, err)
+	assert.Contains(t, out.String(), "Configuration is invalid")
+	assert.Contains(t, out.String(), "at least one shelly device must be configured")
+}
+
+func TestCheckConfig_ProbeFailure(t *testing.T) {
+	device := newCheckTestDevice(t)
+
+	// A closed server is unreachable
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	configFile := writeCheckConfig(t, `
+scrape_timeout: 2s
+shelly_devices:
+  - "`+device.URL+`"
+  - "`+unreachable.URL+`"
+`)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"config", "check", "--config", configFile, "--probe"})
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 devices failed the check")
+	assert.Contains(t, out.String(), "unreachable")
+}
+
+func TestCheckConfig_WithoutProbe(t *testing.T) {
+	configFile := writeCheckConfig(t, `
+shelly_devices:
+  - "http://192.0.2.1"
+`)
+
+	var out bytes.Buffer
+	err := checkConfig(t.Context(), &out, configFile, false)
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Configuration file: "+configFile)
+	assert.NotContains(t, out.String(), "DEVICE")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// maxDiscoverSubnetBits is the prefix length of the largest subnet the discover command scans
+const maxDiscoverSubnetBits = 16
+
+// discoverOptions holds the flags of the discover command
+type discoverOptions struct {
+	mdns          bool
+	mdnsInterface string
+	mdnsTimeout   time.Duration
+	subnets       []string
+	probeTimeout  time.Duration
+	concurrency   int
+	yaml          bool
+}
+
+// discardHandler ignores discovery notifications, the devices are read from the manager afterwards
+type discardHandler struct{}
+
+func (discardHandler) DeviceAdded(*discovery.Device)   {}
+func (discardHandler) DeviceRemoved(*discovery.Device) {}
+
+// yamlDevice is a shelly_devices entry written by the discover command
+type yamlDevice struct {
+	URL    string            `yaml:"url"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+func newDiscoverCmd() *cobra.Command {
+	var opts discoverOptions
+
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Find Shelly devices on the network",
+		Long: `Scans the network for Shelly devices through mDNS and/or subnet scans and prints
+a table of the devices found. With --yaml a shelly_devices block with the device
+names and models as labels is printed instead. Write it to a separate file and merge
+its entries into the shelly_devices list of the configuration by hand, appending it
+to a configuration that already has a shelly_devices key makes the file invalid.`,
+		Example: `  shelly-exporter discover --mdns
+  shelly-exporter discover --subnet 192.168.1.0/24 --yaml > discovered.yaml`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sources, err := discoverSources(opts, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			return discoverDevices(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), sources, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.mdns, "mdns", false, "Browse for devices advertised over mDNS")
+	cmd.Flags().StringVar(&opts.mdnsInterface, "mdns-interface", "", "Network interface used for mDNS queries (default all)")
+	cmd.Flags().DurationVar(&opts.mdnsTimeout, "mdns-timeout", 5*time.Second, "Time to wait for mDNS responses")
+	cmd.Flags().StringSliceVar(&opts.subnets, "subnet", nil, "IPv4 subnet to scan, e.g. 192.168.1.0/24 (repeatable)")
+	cmd.Flags().DurationVar(&opts.probeTimeout, "timeout", 2*time.Second, "Timeout for probing each candidate address")
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", 32, "Number of candidate addresses probed at the same time")
+	cmd.Flags().BoolVar(&opts.yaml, "yaml", false, "Print a shelly_devices configuration block instead of a table")
+
+	return cmd
+}
+
+// discoverSources creates the discovery sources selected by the flags
+func discoverSources(opts discoverOptions, errOut io.Writer) ([]discovery.Source, error) {
+	if !opts.mdns && len(opts.subnets) == 0 {
+		return nil, fmt.Errorf("at least one of --mdns or --subnet is required")
+	}
+
+	var sources []discovery.Source
+	if opts.mdns {
+		mdnsConfig := config.MDNSConfig{
+			Enabled:   true,
+			Interface: opts.mdnsInterface,
+			Timeout:   opts.mdnsTimeout,
+		}
+		sources = append(sources, discovery.NewMDNSSource(mdnsConfig, discoverLogger(errOut)))
+	}
+
+	if len(opts.subnets) > 0 {
+		for _, subnet := range opts.subnets {
+			if prefix, err := netip.ParsePrefix(subnet); err == nil && prefix.Bits() < maxDiscoverSubnetBits {
+				return nil, fmt.Errorf("subnet %q is larger than /%d", subnet, maxDiscoverSubnetBits)
+			}
+		}
+		source, err := discovery.NewSubnetSource(opts.subnets)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// discoverDevices runs the sources once and prints the devices found
+func discoverDevices(ctx context.Context, out, errOut io.Writer, sources []discovery.Source, opts discoverOptions) error {
+	cfg := &config.Config{
+		Discovery: config.DiscoveryConfig{
+			ExpireAfter:  time.Hour,
+			ProbeTimeout: opts.probeTimeout,
+			Concurrency:  opts.concurrency,
+		},
+	}
+
+	manager := discovery.NewManager(cfg, discoverLogger(errOut), discardHandler{}, sources...)
+	manager.Refresh(ctx)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("discovery interrupted: %w", err)
+	}
+
+	devices := manager.Devices()
+	if opts.yaml {
+		return writeDevicesYAML(out, devices)
+	}
+
+	if len(devices) == 0 {
+		_, _ = fmt.Fprintln(out, "No Shelly devices found")
+		return nil
+	}
+	return writeDevicesTable(out, devices)
+}
+
+// discoverLogger only reports problems, progress is shown through the command output
+func discoverLogger(errOut io.Writer) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(errOut)
+	logger.SetLevel(logrus.WarnLevel)
+	return logger
+}
+
+// writeDevicesTable prints one line per discovered device
+func writeDevicesTable(out io.Writer, devices []*discovery.Device) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "IP\tMAC\tMODEL\tGEN\tNAME\tAUTH")
+
+	for _, device := range devices {
+		name := device.Name
+		if name == "" {
+			name = "-"
+		}
+		auth := "no"
+		if device.AuthEnabled {
+			auth = "yes"
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			deviceHost(device.URL), device.MAC, device.Model, strconv.Itoa(device.Gen), name, auth)
+	}
+
+	return w.Flush()
+}
+
+// writeDevicesYAML prints a shelly_devices block with the device name and model as labels
+func writeDevicesYAML(out io.Writer, devices []*discovery.Device) error {
+	entries := make([]yamlDevice, 0, len(devices))
+	for _, device := range devices {
+		labels := map[string]string{"model": device.Model}
+		if device.Name != "" {
+			labels["name"] = device.Name
+		}
+		entries = append(entries, yamlDevice{URL: device.URL, Labels: labels})
+	}
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(struct {
+		ShellyDevices []yamlDevice `yaml:"shelly_devices"`
+	}{ShellyDevices: entries}); err != nil {
+		return fmt.Errorf("failed to encode devices: %w", err)
+	}
+	return encoder.Close()
+}
+
+// deviceHost returns the host part of a device URL
+func deviceHost(deviceURL string) string {
+	u, err := url.Parse(deviceURL)
+	if err != nil || u.Host == "" {
+		return deviceURL
+	}
+	return u.Hostname()
+}
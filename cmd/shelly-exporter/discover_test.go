@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// candidateSource returns a fixed candidate list
+type candidateSource struct {
+	candidates []discovery.Candidate
+}
+
+func (s *candidateSource) Name() string { return "test" }
+
+func (s *candidateSource) Discover(ctx context.Context) ([]discovery.Candidate, error) {
+	return s.candidates, nil
+}
+
+func newDiscoverTestDevice(t *testing.T, shelly map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shelly" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(shelly)
+	}))
+	t.Cleanup(device.Close)
+
+	return device
+}
+
+func newDiscoverTestSource(t *testing.T) *candidateSource {
+	t.Helper()
+
+	gen2 := newDiscoverTestDevice(t, map[string]interface{}{
+		"mac":     "AABBCCDDEEFF",
+		"model":   "SPEM-003CEBEU",
+		"gen":     2,
+		"name":    "Main Meter",
+		"auth_en": true,
+	})
+	gen1 := newDiscoverTestDevice(t, map[string]interface{}{
+		"mac":  "112233445566",
+		"type": "SHPLG-S",
+		"auth": false,
+	})
+	notShelly := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notShelly.Close)
+
+	return &candidateSource{candidates: []discovery.Candidate{
+		{URL: gen2.URL},
+		{URL: gen1.URL, Name: "shellyplug-s-112233"},
+		{URL: notShelly.URL},
+	}}
+}
+
+func TestNewDiscoverCmd(t *testing.T) {
+	cmd := newRootCmd()
+
+	discover, _, err := cmd.Find([]string{"discover"})
+	require.NoError(t, err)
+	assert.Equal(t, "discover", discover.Use)
+	for _, flag := range []string{"mdns", "mdns-interface", "subnet", "timeout", "concurrency", "yaml"} {
+		assert.NotNil(t, discover.Flags().Lookup(flag), flag)
+	}
+}
+
+func TestDiscoverSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    discoverOptions
+		want    int
+		wantErr string
+	}{
+		{
+			name:    "no source",
+			wantErr: "at least one of --mdns or --subnet is required",
+		},
+		{
+			name: "mdns and subnet",
+			opts: discoverOptions{mdns: true, subnets: []string{"192.168.1.0/24"}},
+			want: 2,
+		},
+		{
+			name:    "invalid subnet",
+			opts:    discoverOptions{subnets: []string{"192.168.1.0"}},
+			wantErr: "invalid subnet",
+		},
+		{
+			name:    "subnet too large",
+			opts:    discoverOptions{subnets: []string{"10.0.0.0/8"}},
+			wantErr: "larger than /16",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := discoverSources(tt.opts, &bytes.Buffer{})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, sources, tt.want)
+		})
+	}
+}
+
+func TestDiscoverDevices_Table(t *testing.T) {
+	source := newDiscoverTestSource(t)
+
+	var out bytes.Buffer
+	err := discoverDevices(t.Context(), &out, &bytes.Buffer{}, []discovery.Source{source}, discoverOptions{concurrency: 2})
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "IP")
+	assert.Contains(t, out.String(), "AA:BB:CC:DD:EE:FF")
+	assert.Contains(t, out.String(), "SPEM-003CEBEU")
+	assert.Contains(t, out.String(), "Main Meter")
+	assert.Contains(t, out.String(), "shellyplug-s-112233")
+	assert.Contains(t, out.String(), "127.0.0.1")
+	assert.Contains(t, out.String(), "yes")
+}
+
+func TestDiscoverDevices_YAML(t *testing.T) {
+	source := newDiscoverTestSource(t)
+
+	var out bytes.Buffer
+	err := discoverDevices(t.Context(), &out, &bytes.Buffer{}, []discovery.Source{source}, discoverOptions{concurrency: 2, yaml: true})
+	require.NoError(t, err)
+
+	// The block decodes into the exporter configuration
+	var cfg struct {
+		ShellyDevices []struct {
+			URL    string            `yaml:"url"`
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"shelly_devices"`
+	}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &cfg))
+	require.Len(t, cfg.ShellyDevices, 2)
+
+	byModel := map[string]map[string]string{}
+	for _, device := range cfg.ShellyDevices {
+		assert.NotEmpty(t, device.URL)
+		assert.NoError(t, config.ValidateLabels(device.Labels))
+		byModel[device.Labels["model"]] = device.Labels
+	}
+	assert.Equal(t, "Main Meter", byModel["SPEM-003CEBEU"]["name"])
+	assert.Equal(t, "shellyplug-s-112233", byModel["SHPLG-S"]["name"])
+}
+
+func TestDiscoverDevices_NoDevices(t *testing.T) {
+	var out bytes.Buffer
+	err := discoverDevices(t.Context(), &out, &bytes.Buffer{}, []discovery.Source{&candidateSource{}}, discoverOptions{})
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "No Shelly devices found")
+}
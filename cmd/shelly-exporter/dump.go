@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/anonymize"
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// dumpOptions holds the flags of the dump command
+type dumpOptions struct {
+	probeOptions
+	output string
+}
+
+// dumpEndpoint is a device response included in a dump
+type dumpEndpoint struct {
+	// file is the name of the file in the archive
+	file string
+	// fetch retrieves the raw response
+	fetch func(ctx context.Context, c *client.Client) (json.RawMessage, error)
+}
+
+// dumpManifest describes the contents of a dump
+type dumpManifest struct {
+	Model           string            `json:"model"`
+	App             string            `json:"app,omitempty"`
+	Gen             int               `json:"gen"`
+	Firmware        string            `json:"firmware"`
+	ExporterVersion string            `json:"exporter_version"`
+	Files           []string          `json:"files"`
+	Errors          map[string]string `json:"errors,omitempty"`
+}
+
+// getEndpoint fetches a Gen1 HTTP endpoint
+func getEndpoint(endpoint string) dumpEndpoint {
+	return dumpEndpoint{
+		file: strings.TrimPrefix(endpoint, "/") + ".json",
+		fetch: func(ctx context.Context, c *client.Client) (json.RawMessage, error) {
+			return c.GetRaw(ctx, endpoint)
+		},
+	}
+}
+
+// rpcEndpoint calls a Gen2 RPC method
+func rpcEndpoint(method string) dumpEndpoint {
+	return dumpEndpoint{
+		file: method + ".json",
+		fetch: func(ctx context.Context, c *client.Client) (json.RawMessage, error) {
+			var raw json.RawMessage
+			if err := c.Call(ctx, method, nil, &raw); err != nil {
+				return nil, err
+			}
+			return raw, nil
+		},
+	}
+}
+
+// dumpEndpoints returns the endpoints captured for a device generation
+func dumpEndpoints(gen int) []dumpEndpoint {
+	if gen >= 2 {
+		return []dumpEndpoint{
+			getEndpoint("/shelly"),
+			rpcEndpoint("Shelly.GetStatus"),
+			rpcEndpoint("Shelly.GetConfig"),
+			rpcEndpoint("Shelly.GetDeviceInfo"),
+		}
+	}
+	return []dumpEndpoint{
+		getEndpoint("/shelly"),
+		getEndpoint("/status"),
+		getEndpoint("/settings"),
+	}
+}
+
+func newDumpCmd() *cobra.Command {
+	var opts dumpOptions
+
+	cmd := &cobra.Command{
+		Use:   "dump <url>",
+		Short: "Capture anonymized device responses for support requests",
+		Long: `Fetches the API responses of a single device (/shelly, /status and /settings for
+Gen1 devices, Shelly.GetStatus, Shelly.GetConfig and Shelly.GetDeviceInfo for Gen2+
+devices), replaces MAC addresses, IP addresses, SSIDs, names, user names, passwords
+and coordinates consistently across all files, and writes them to a tarball.
+
+The archive contains a single directory named after the model and firmware that can
+be attached to a device support issue or added to the test fixtures.
+
+The password can also be given in the SHELLY_EXPORTER_AUTH_PASSWORD environment variable.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.password == "" {
+				opts.password = os.Getenv("SHELLY_EXPORTER_AUTH_PASSWORD")
+			}
+			return dumpDevice(cmd.Context(), cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+
+	opts.addFlags(cmd)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Archive to write (default <model>_<firmware>.tar.gz)")
+
+	return cmd
+}
+
+// dumpDevice captures the responses of a device into an anonymized tarball
+func dumpDevice(ctx context.Context, out io.Writer, target string, opts dumpOptions) error {
+	target = normalizeTarget(target)
+
+	cfg := opts.clientConfig()
+	httpClient, err := client.NewHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := client.New(target, cfg, logger)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	device, err := discovery.Probe(ctx, httpClient, discovery.Candidate{URL: target})
+	if err != nil {
+		return fmt.Errorf("failed to identify device: %w", err)
+	}
+
+	anonymizer := anonymize.New()
+	manifest := dumpManifest{
+		Model:           device.Model,
+		App:             device.App,
+		Gen:             device.Gen,
+		Firmware:        device.Firmware,
+		ExporterVersion: version,
+		Errors:          make(map[string]string),
+	}
+
+	files := make(map[string][]byte)
+	for _, endpoint := range dumpEndpoints(device.Gen) {
+		raw, err := endpoint.fetch(ctx, c)
+		if err == nil {
+			raw, err = anonymizer.JSON(raw)
+		}
+		if err != nil {
+			if errors.Is(err, client.ErrAuthRequired) {
+				err = fmt.Errorf("authentication required, set --password")
+			}
+			manifest.Errors[endpoint.file] = anonymizer.String(err.Error())
+			_, _ = fmt.Fprintf(out, "%-28s %s\n", endpoint.file, manifest.Errors[endpoint.file])
+			continue
+		}
+
+		files[endpoint.file] = raw
+		manifest.Files = append(manifest.Files, endpoint.file)
+		_, _ = fmt.Fprintf(out, "%-28s ok\n", endpoint.file)
+	}
+
+	if len(manifest.Files) == 0 {
+		return fmt.Errorf("failed to capture any endpoint of %s", anonymizer.String(target))
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	files["manifest.json"] = append(manifestJSON, '\n')
+
+	dir := fixtureName(device.Model, device.Firmware)
+	output := opts.output
+	if output == "" {
+		output = dir + ".tar.gz"
+	}
+
+	if err := writeDump(output, dir, files); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "\nWrote %s with %d responses", output, len(manifest.Files))
+	if len(manifest.Errors) > 0 {
+		_, _ = fmt.Fprintf(out, ", %d endpoints failed", len(manifest.Errors))
+	}
+	_, _ = fmt.Fprintln(out)
+	return nil
+}
+
+// fixtureName returns the directory name of a dump, e.g. SHPLG-S_v1.14.0
+func fixtureName(model, firmware string) string {
+	// Gen1 firmware ids look like 20230913-112316/v1.14.0-gcb84623
+	if _, after, ok := strings.Cut(firmware, "/"); ok {
+		firmware = after
+	}
+	if before, _, ok := strings.Cut(firmware, "-g"); ok {
+		firmware = before
+	}
+
+	sanitize := func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}
+
+	name := strings.Map(sanitize, model)
+	if firmware != "" {
+		name += "_" + strings.Map(sanitize, firmware)
+	}
+	return name
+}
+
+// writeDump writes the files into a gzip compressed tarball below dir
+func writeDump(output, dir string, files map[string][]byte) (err error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close archive: %w", closeErr)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	modTime := time.Now()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		header := &tar.Header{
+			Name:    path.Join(dir, name),
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
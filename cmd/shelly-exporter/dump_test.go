@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readDump returns the files of a dump archive by path
+func readDump(t *testing.T, archive string) map[string]string {
+	t.Helper()
+
+	f, err := os.Open(archive)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+func newDumpTestDevice(t *testing.T, cfg simulator.DeviceConfig) *httptest.Server {
+	t.Helper()
+
+	device, err := simulator.NewDevice(cfg)
+	require.NoError(t, err)
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewDumpCmd(t *testing.T) {
+	cmd := newRootCmd()
+
+	dump, _, err := cmd.Find([]string{"dump"})
+	require.NoError(t, err)
+	assert.Equal(t, "dump <url>", dump.Use)
+	for _, flag := range []string{"user", "password", "timeout", "output"} {
+		assert.NotNil(t, dump.Flags().Lookup(flag), flag)
+	}
+}
+
+func TestDumpDevice_Gen2(t *testing.T) {
+	server := newDumpTestDevice(t, simulator.DeviceConfig{
+		Model:    "plus1pm",
+		Name:     "Boiler Room",
+		MAC:      "C8F09E1A2B3C",
+		Password: "secret",
+	})
+
+	output := filepath.Join(t.TempDir(), "dump.tar.gz")
+	opts := dumpOptions{
+		probeOptions: probeOptions{username: "admin", password: "secret", timeout: 5 * time.Second},
+		output:       output,
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, dumpDevice(t.Context(), &out, server.URL, opts))
+	assert.Contains(t, out.String(), "Wrote "+output+" with 4 responses")
+
+	files := readDump(t, output)
+	dir := "SNSW-001P16EU_1.0.8/"
+	for _, name := range []string{"shelly.json", "Shelly.GetStatus.json", "Shelly.GetConfig.json", "Shelly.GetDeviceInfo.json", "manifest.json"} {
+		assert.Contains(t, files, dir+name)
+	}
+
+	for name, content := range files {
+		for _, identifier := range []string{"c8f09e1a2b3c", "boiler room"} {
+			assert.NotContains(t, strings.ToLower(content), identifier, name)
+		}
+	}
+
+	// Identifiers are replaced consistently across files
+	assert.Contains(t, files[dir+"shelly.json"], `"mac": "AABBCC000001"`)
+	assert.Contains(t, files[dir+"Shelly.GetStatus.json"], `"mac": "AABBCC000001"`)
+	assert.Contains(t, files[dir+"shelly.json"], `"name": "name-1"`)
+	assert.Contains(t, files[dir+"Shelly.GetConfig.json"], `"name": "name-1"`)
+
+	var manifest dumpManifest
+	require.NoError(t, json.Unmarshal([]byte(files[dir+"manifest.json"]), &manifest))
+	assert.Equal(t, "SNSW-001P16EU", manifest.Model)
+	assert.Equal(t, 2, manifest.Gen)
+	assert.Len(t, manifest.Files, 4)
+	assert.Empty(t, manifest.Errors)
+}
+
+func TestDumpDevice_Gen1(t *testing.T) {
+	server := newDumpTestDevice(t, simulator.DeviceConfig{Model: "plugs"})
+
+	// The archive is named after the model and firmware by default
+	t.Chdir(t.TempDir())
+
+	var out bytes.Buffer
+	require.NoError(t, dumpDevice(t.Context(), &out, server.URL, dumpOptions{probeOptions: probeOptions{timeout: 5 * time.Second}}))
+
+	files := readDump(t, "SHPLG-S_v1.14.0.tar.gz")
+	for _, name := range []string{"shelly.json", "status.json", "settings.json", "manifest.json"} {
+		assert.Contains(t, files, "SHPLG-S_v1.14.0/"+name)
+	}
+	assert.Contains(t, files["SHPLG-S_v1.14.0/status.json"], `"ssid": "ssid-1"`)
+}
+
+func TestDumpDevice_InsecureSkipVerify(t *testing.T) {
+	device, err := simulator.NewDevice(simulator.DeviceConfig{Model: "plugs"})
+	require.NoError(t, err)
+	server := httptest.NewTLSServer(device)
+	t.Cleanup(server.Close)
+
+	output := filepath.Join(t.TempDir(), "dump.tar.gz")
+	opts := dumpOptions{
+		probeOptions: probeOptions{timeout: 5 * time.Second, insecureSkipVerify: true},
+		output:       output,
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, dumpDevice(t.Context(), &out, server.URL, opts))
+	assert.Contains(t, readDump(t, output), "SHPLG-S_v1.14.0/status.json")
+}
+
+func TestDumpDevice_AuthRequired(t *testing.T) {
+	server := newDumpTestDevice(t, simulator.DeviceConfig{Model: "1pm", Password: "secret"})
+
+	output := filepath.Join(t.TempDir(), "dump.tar.gz")
+	opts := dumpOptions{probeOptions: probeOptions{timeout: 5 * time.Second}, output: output}
+
+	var out bytes.Buffer
+	require.NoError(t, dumpDevice(t.Context(), &out, server.URL, opts))
+	assert.Contains(t, out.String(), "authentication required, set --password")
+	assert.Contains(t, out.String(), "2 endpoints failed")
+
+	files := readDump(t, output)
+	var manifest dumpManifest
+	require.NoError(t, json.Unmarshal([]byte(files["SHSW-PM_v1.14.0/manifest.json"]), &manifest))
+	assert.Equal(t, []string{"shelly.json"}, manifest.Files)
+	assert.Contains(t, manifest.Errors, "status.json")
+}
+
+func TestFixtureName(t *testing.T) {
+	tests := []struct {
+		model    string
+		firmware string
+		want     string
+	}{
+		{model: "SHPLG-S", firmware: "20230913-112316/v1.14.0-gcb84623", want: "SHPLG-S_v1.14.0"},
+		{model: "SPEM-003CEBEU", firmware: "1.1.0", want: "SPEM-003CEBEU_1.1.0"},
+		{model: "SNSW-001P16EU", firmware: "1.4.0-beta1", want: "SNSW-001P16EU_1.4.0-beta1"},
+		{model: "SHHT-1", firmware: "", want: "SHHT-1"},
+		{model: "odd/model", firmware: "1 2", want: "odd_model_1_2"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, fixtureName(tt.model, tt.firmware))
+	}
+}
@@ -58,6 +58,12 @@ and exposes them in Prometheus format for monitoring and alerting.`,
 	cmd.Flags().String("tls-key-file", "", "Client private key file for TLS")
 	cmd.Flags().Bool("tls-insecure-skip-verify", false, "Skip TLS certificate verification")
 
+	cmd.AddCommand(newConfigCmd())
+	cmd.AddCommand(newProbeCmd())
+	cmd.AddCommand(newDumpCmd())
+	cmd.AddCommand(newDiscoverCmd())
+	cmd.AddCommand(newSimulateCmd())
+
 	return cmd
 }
 
@@ -87,6 +93,7 @@ func run(cfgFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
+	srv.SetBuildInfo(version, commit, buildTime)
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -102,6 +109,23 @@ func run(cfgFile string) error {
 		cancel()
 	}()
 
+	// Reload configuration on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupChan:
+				logger.Info("Received SIGHUP, reloading configuration")
+				_ = srv.Reload()
+			}
+		}
+	}()
+
 	// Start server
 	if err := srv.Start(ctx); err != nil {
 		return fmt.Errorf("server error: %w", err)
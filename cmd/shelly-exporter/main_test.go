@@ -6,18 +6,10 @@ import (
 	"testing"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// resetPrometheusRegistry resets the default Prometheus registry for testing
-func resetPrometheusRegistry() {
-	registry := prometheus.NewRegistry()
-	prometheus.DefaultRegisterer = registry
-	prometheus.DefaultGatherer = registry
-}
-
 func TestNewRootCmd(t *testing.T) {
 	cmd := newRootCmd()
 
@@ -195,8 +187,6 @@ shelly_devices: []
 }
 
 func TestNewRootCmd_Execute_ValidConfig(t *testing.T) {
-	resetPrometheusRegistry()
-
 	// Create a temporary config file with valid configuration
 	tmpDir := t.TempDir()
 	configFile := tmpDir + "/config.yaml"
@@ -244,8 +234,6 @@ log_level: "debug"
 }
 
 func TestNewRootCmd_Execute_WithFlags(t *testing.T) {
-	resetPrometheusRegistry()
-
 	// Create a temporary config file with multiple devices
 	tmpDir := t.TempDir()
 	configFile := tmpDir + "/config.yaml"
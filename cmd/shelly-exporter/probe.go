@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/aimar/shelly-prometheus-exporter/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// probeOptions holds the flags of the probe command
+type probeOptions struct {
+	username           string
+	password           string
+	timeout            time.Duration
+	insecureSkipVerify bool
+}
+
+// clientConfig returns the client configuration for the connection flags
+func (o probeOptions) clientConfig() *config.Config {
+	return &config.Config{
+		ScrapeTimeout: o.timeout,
+		Auth: config.AuthConfig{
+			Username: o.username,
+			Password: config.Secret(o.password),
+		},
+		TLS: config.TLSConfig{
+			Enabled:            o.insecureSkipVerify,
+			InsecureSkipVerify: o.insecureSkipVerify,
+		},
+	}
+}
+
+func newProbeCmd() *cobra.Command {
+	var opts probeOptions
+
+	cmd := &cobra.Command{
+		Use:   "probe <url>",
+		Short: "Query a single device and print its status and metrics",
+		Long: `Queries a single Shelly device without starting the HTTP server and prints the
+detected generation, the raw status JSON and the Prometheus text exposition the
+exporter generates for the device.
+
+The password can also be given in the SHELLY_EXPORTER_AUTH_PASSWORD environment variable.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.password == "" {
+				opts.password = os.Getenv("SHELLY_EXPORTER_AUTH_PASSWORD")
+			}
+			return probeDevice(cmd.Context(), cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+
+	opts.addFlags(cmd)
+
+	return cmd
+}
+
+// addFlags registers the connection flags shared by the commands that query a single device
+func (o *probeOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.username, "user", "admin", "Username for devices with authentication enabled")
+	cmd.Flags().StringVar(&o.password, "password", "", "Password for devices with authentication enabled")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", 10*time.Second, "Timeout for each request to the device")
+	cmd.Flags().BoolVar(&o.insecureSkipVerify, "insecure-skip-verify", false, "Skip TLS certificate verification")
+}
+
+// probeDevice prints the generation, raw status and metrics of a device
+func probeDevice(ctx context.Context, out io.Writer, target string, opts probeOptions) error {
+	target = normalizeTarget(target)
+
+	cfg := opts.clientConfig()
+	httpClient, err := client.NewHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := client.New(target, cfg, logger)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	// Generation
+	device, err := discovery.Probe(ctx, httpClient, discovery.Candidate{URL: target})
+	if err != nil {
+		return fmt.Errorf("failed to identify device: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "Device:     %s\n", config.RedactURL(target))
+	_, _ = fmt.Fprintf(out, "Generation: %d\n", device.Gen)
+	_, _ = fmt.Fprintf(out, "Model:      %s\n", device.Model)
+	if device.App != "" {
+		_, _ = fmt.Fprintf(out, "App:        %s\n", device.App)
+	}
+	_, _ = fmt.Fprintf(out, "MAC:        %s\n", device.MAC)
+	_, _ = fmt.Fprintf(out, "Firmware:   %s\n", device.Firmware)
+	_, _ = fmt.Fprintf(out, "Auth:       %t\n", device.AuthEnabled)
+
+	// Raw status
+	raw, err := c.GetRawStatus(ctx, device.Gen)
+	if err != nil {
+		return fmt.Errorf("failed to get device status: %w", err)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return fmt.Errorf("failed to format device status: %w", err)
+	}
+	_, _ = fmt.Fprintf(out, "\n# Status\n%s\n", indented.String())
+
+	// Exposition
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics.NewCollector([]*client.Client{c}, logger))
+
+	families, err := registry.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "\n# Metrics\n")
+	encoder := expfmt.NewEncoder(out, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return fmt.Errorf("failed to encode metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// normalizeTarget adds the default scheme to a device address and removes a trailing slash
+func normalizeTarget(target string) string {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	return strings.TrimSuffix(target, "/")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProbeCmd(t *testing.T) {
+	cmd := newRootCmd()
+
+	probe, _, err := cmd.Find([]string{"probe"})
+	require.NoError(t, err)
+	assert.Equal(t, "probe <url>", probe.Use)
+	assert.NotNil(t, probe.Flags().Lookup("user"))
+	assert.NotNil(t, probe.Flags().Lookup("password"))
+	assert.NotNil(t, probe.Flags().Lookup("timeout"))
+
+	user, _ := probe.Flags().GetString("user")
+	assert.Equal(t, "admin", user)
+}
+
+func TestProbeCmd_Execute(t *testing.T) {
+	device := newCheckTestDevice(t)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"probe", strings.TrimPrefix(device.URL, "http://"), "--user", "admin"})
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Generation: 2")
+	assert.Contains(t, out.String(), "Model:      SPEM-003CEBEU")
+	assert.Contains(t, out.String(), "# Status")
+	assert.Contains(t, out.String(), `"sys": {`)
+	assert.Contains(t, out.String(), "# Metrics")
+	assert.Contains(t, out.String(), `shelly_device_up{device="`+device.URL+`"} 1`)
+}
+
+func TestProbeCmd_Execute_InsecureSkipVerify(t *testing.T) {
+	device := httptest.NewTLSServer(checkTestHandler())
+	t.Cleanup(device.Close)
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"probe", device.URL, "--insecure-skip-verify"})
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Model:      SPEM-003CEBEU")
+	assert.Contains(t, out.String(), `shelly_device_up{device="`+device.URL+`"} 1`)
+}
+
+func TestProbeCmd_Execute_Unreachable(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"probe", "http://127.0.0.1:1", "--timeout", "1s"})
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to identify device")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/simulator"
+	"github.com/spf13/cobra"
+)
+
+// simulatorShutdownTimeout limits how long open requests may delay stopping the simulator
+const simulatorShutdownTimeout = 5 * time.Second
+
+// simulateOptions holds the flags of the simulate command
+type simulateOptions struct {
+	configFile string
+	models     []string
+	listen     string
+	name       string
+	username   string
+	password   string
+	profile    simulator.ProfileConfig
+	faults     simulator.FaultConfig
+}
+
+// simulation is a set of running simulated devices
+type simulation struct {
+	servers   []*http.Server
+	listeners []net.Listener
+	errs      chan error
+}
+
+func newSimulateCmd() *cobra.Command {
+	var opts simulateOptions
+
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Serve simulated Shelly devices for testing and demos",
+		Long: `Serves simulated Shelly devices answering the Gen1 /shelly, /status and /settings
+endpoints and the Gen2 /rpc API. Devices are given with --model, one per model on
+consecutive ports starting at --listen, or in a configuration file with --config.
+
+Models: ` + strings.Join(simulator.Models(), ", "),
+		Example: `  shelly-exporter simulate --model pro3em --model 1pm --listen 127.0.0.1:9101
+  shelly-exporter simulate --model plugs --profile sine --watts 800 --amplitude 600 --period 10m
+  shelly-exporter simulate --config simulator.yaml`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := simulateConfig(opts)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			sim, err := startSimulation(cfg, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			return sim.wait(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.configFile, "config", "", "Simulator configuration file, other flags are ignored when set")
+	cmd.Flags().StringSliceVar(&opts.models, "model", nil, "Model to simulate (repeatable)")
+	cmd.Flags().StringVar(&opts.listen, "listen", "127.0.0.1:9101", "Address of the first device, further devices use the following ports")
+	cmd.Flags().StringVar(&opts.name, "name", "", "Device name reported by the devices")
+	cmd.Flags().StringVar(&opts.username, "user", "admin", "Username of devices with authentication enabled")
+	cmd.Flags().StringVar(&opts.password, "password", "", "Enable authentication with this password")
+	cmd.Flags().StringVar(&opts.profile.Type, "profile", simulator.ProfileConstant, "Load profile: constant, sine or random")
+	cmd.Flags().Float64Var(&opts.profile.Watts, "watts", 100, "Constant load, sine midpoint or random mean in watts")
+	cmd.Flags().Float64Var(&opts.profile.Amplitude, "amplitude", 0, "Sine amplitude or maximum random deviation in watts")
+	cmd.Flags().DurationVar(&opts.profile.Period, "period", 10*time.Minute, "Period of the sine profile")
+	cmd.Flags().DurationVar(&opts.faults.Delay, "delay", 0, "Delay added to every response")
+	cmd.Flags().Float64Var(&opts.faults.TimeoutRate, "timeout-rate", 0, "Share of requests that are never answered (0-1)")
+	cmd.Flags().Float64Var(&opts.faults.MalformedRate, "malformed-rate", 0, "Share of requests answered with malformed JSON (0-1)")
+	cmd.Flags().Float64Var(&opts.faults.ErrorRate, "error-rate", 0, "Share of requests answered with HTTP 500 (0-1)")
+
+	return cmd
+}
+
+// simulateConfig returns the simulator configuration from the file or the flags
+func simulateConfig(opts simulateOptions) (*simulator.Config, error) {
+	if opts.configFile != "" {
+		return simulator.LoadConfig(opts.configFile)
+	}
+
+	if len(opts.models) == 0 {
+		return nil, fmt.Errorf("at least one --model or --config is required")
+	}
+
+	host, portValue, err := net.SplitHostPort(opts.listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", opts.listen, err)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", opts.listen, err)
+	}
+
+	cfg := &simulator.Config{}
+	for i, model := range opts.models {
+		// Port 0 lets the system assign a port to every device
+		listen := opts.listen
+		if port != 0 {
+			listen = net.JoinHostPort(host, strconv.Itoa(port+i))
+		}
+		cfg.Devices = append(cfg.Devices, simulator.DeviceConfig{
+			Listen:   listen,
+			Model:    model,
+			Name:     opts.name,
+			Username: opts.username,
+			Password: opts.password,
+			Profile:  opts.profile,
+			Faults:   opts.faults,
+		})
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// startSimulation starts listening for all configured devices and serves them in the background
+func startSimulation(cfg *simulator.Config, out io.Writer) (*simulation, error) {
+	sim := &simulation{errs: make(chan error, len(cfg.Devices))}
+
+	for _, deviceConfig := range cfg.Devices {
+		listener, err := net.Listen("tcp", deviceConfig.Listen)
+		if err != nil {
+			sim.close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", deviceConfig.Listen, err)
+		}
+		sim.listeners = append(sim.listeners, listener)
+
+		// Derived MAC addresses stay unique for devices sharing a system assigned port
+		deviceConfig.Listen = listener.Addr().String()
+		device, err := simulator.NewDevice(deviceConfig)
+		if err != nil {
+			sim.close()
+			return nil, err
+		}
+
+		sim.servers = append(sim.servers, &http.Server{
+			Handler:           device,
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+
+		model := device.Model()
+		_, _ = fmt.Fprintf(out, "Simulating %s (%s, Gen%d, MAC %s) on http://%s\n",
+			model.Name, model.Type, model.Gen, device.MAC(), listener.Addr())
+	}
+
+	for i, server := range sim.servers {
+		go func(server *http.Server, listener net.Listener) {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				sim.errs <- fmt.Errorf("failed to serve on %s: %w", listener.Addr(), err)
+			}
+		}(server, sim.listeners[i])
+	}
+
+	return sim, nil
+}
+
+// addrs returns the addresses the devices listen on
+func (s *simulation) addrs() []string {
+	addrs := make([]string, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr().String())
+	}
+	return addrs
+}
+
+// wait serves the devices until the context is cancelled or a server fails
+func (s *simulation) wait(ctx context.Context) error {
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-s.errs:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), simulatorShutdownTimeout)
+	defer cancel()
+	for _, server := range s.servers {
+		// Requests held open by the timeout fault would block a graceful shutdown
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			_ = server.Close()
+		}
+	}
+
+	return err
+}
+
+// close releases the listeners of a simulation that did not start
+func (s *simulation) close() {
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/aimar/shelly-prometheus-exporter/internal/simulator"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSimulateCmd(t *testing.T) {
+	cmd := newRootCmd()
+
+	simulate, _, err := cmd.Find([]string{"simulate"})
+	require.NoError(t, err)
+	assert.Equal(t, "simulate", simulate.Use)
+	for _, flag := range []string{"config", "model", "listen", "password", "profile", "timeout-rate", "malformed-rate"} {
+		assert.NotNil(t, simulate.Flags().Lookup(flag), flag)
+	}
+}
+
+func TestSimulateConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       simulateOptions
+		wantListen []string
+		wantErr    string
+	}{
+		{
+			name:       "consecutive ports",
+			opts:       simulateOptions{models: []string{"pro3em", "1pm"}, listen: "127.0.0.1:9101"},
+			wantListen: []string{"127.0.0.1:9101", "127.0.0.1:9102"},
+		},
+		{
+			name:       "system assigned ports",
+			opts:       simulateOptions{models: []string{"ht", "ht"}, listen: "127.0.0.1:0"},
+			wantListen: []string{"127.0.0.1:0", "127.0.0.1:0"},
+		},
+		{
+			name:    "no model",
+			opts:    simulateOptions{listen: "127.0.0.1:9101"},
+			wantErr: "at least one --model or --config is required",
+		},
+		{
+			name:    "invalid listen address",
+			opts:    simulateOptions{models: []string{"pro3em"}, listen: "9101"},
+			wantErr: "invalid listen address",
+		},
+		{
+			name:    "unknown model",
+			opts:    simulateOptions{models: []string{"pro4em"}, listen: "127.0.0.1:9101"},
+			wantErr: `model "pro4em" is unknown`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := simulateConfig(tt.opts)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var listen []string
+			for _, device := range cfg.Devices {
+				listen = append(listen, device.Listen)
+			}
+			assert.Equal(t, tt.wantListen, listen)
+		})
+	}
+}
+
+func TestSimulateConfig_File(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "simulator.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+devices:
+  - listen: "127.0.0.1:0"
+    model: plus1pm
+`), 0644))
+
+	cfg, err := simulateConfig(simulateOptions{configFile: configFile, models: []string{"ignored"}})
+
+	require.NoError(t, err)
+	require.Len(t, cfg.Devices, 1)
+	assert.Equal(t, "plus1pm", cfg.Devices[0].Model)
+}
+
+func TestStartSimulation(t *testing.T) {
+	cfg, err := simulateConfig(simulateOptions{
+		models:   []string{"pro3em", "plugs"},
+		listen:   "127.0.0.1:0",
+		username: "admin",
+		password: "secret",
+		profile:  simulator.ProfileConfig{Type: simulator.ProfileConstant, Watts: 250},
+	})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	sim, err := startSimulation(cfg, &out)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sim.wait(ctx) }()
+
+	assert.Contains(t, out.String(), "Simulating pro3em (SPEM-003CEBEU, Gen2")
+	assert.Contains(t, out.String(), "Simulating plugs (SHPLG-S, Gen1")
+
+	clientConfig := &config.Config{
+		ScrapeTimeout: 2 * time.Second,
+		Auth:          config.AuthConfig{Username: "admin", Password: "secret"},
+	}
+	macs := map[string]bool{}
+	for _, addr := range sim.addrs() {
+		url := "http://" + addr
+
+		device, err := discovery.Probe(ctx, http.DefaultClient, discovery.Candidate{URL: url})
+		require.NoError(t, err)
+		assert.True(t, device.AuthEnabled)
+		macs[device.MAC] = true
+
+		status, err := client.New(url, clientConfig, logrus.New()).GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 250.0, status.EM.TotalActPower)
+	}
+	assert.Len(t, macs, 2)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,185 @@
+package anonymize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// Replacement values are derived from documentation prefixes so that they are recognizably fake
+const (
+	macPrefix = "AABBCC"
+	ipPrefix  = "192.0.2."
+)
+
+var (
+	// macPattern matches MAC addresses with or without separators, e.g. in shellyplug-s-c8f09e1a2b3c
+	macPattern = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{2}[:-]){5}[0-9a-f]{2}\b|\b[0-9a-f]{12}\b`)
+	// ipPattern matches IPv4 addresses
+	ipPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+)
+
+// keyCategories maps JSON keys whose string values identify the user to the prefix of their replacement
+var keyCategories = map[string]string{
+	"ssid":      "ssid",
+	"name":      "name",
+	"user":      "user",
+	"username":  "user",
+	"client_id": "client",
+}
+
+// secretKeys are JSON keys whose values are removed
+var secretKeys = map[string]bool{
+	"pass":     true,
+	"password": true,
+	"key":      true,
+}
+
+// locationKeys are JSON keys holding coordinates, replaced by 0
+var locationKeys = map[string]bool{
+	"lat": true,
+	"lon": true,
+	"lng": true,
+}
+
+// Anonymizer replaces identifiers in device responses. The same identifier gets the same
+// replacement in every document processed by an Anonymizer, so documents stay consistent.
+type Anonymizer struct {
+	macs   map[string]string
+	ips    map[string]string
+	values map[string]map[string]string
+}
+
+// New creates a new Anonymizer
+func New() *Anonymizer {
+	return &Anonymizer{
+		macs:   make(map[string]string),
+		ips:    make(map[string]string),
+		values: make(map[string]map[string]string),
+	}
+}
+
+// JSON anonymizes a JSON document and returns it indented
+func (a *Anonymizer) JSON(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON document: %w", err)
+	}
+
+	anonymized, err := json.MarshalIndent(a.value("", document), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON document: %w", err)
+	}
+	return append(anonymized, '\n'), nil
+}
+
+// value anonymizes a decoded JSON value stored under key
+func (a *Anonymizer) value(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = a.value(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = a.value(key, item)
+		}
+		return v
+	case json.Number:
+		if locationKeys[key] {
+			return json.Number("0")
+		}
+		return v
+	case string:
+		return a.stringValue(key, v)
+	default:
+		return v
+	}
+}
+
+// stringValue anonymizes a string value stored under key
+func (a *Anonymizer) stringValue(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretKeys[key] {
+		return ""
+	}
+	if category, ok := keyCategories[key]; ok {
+		return a.replace(category, value)
+	}
+	return a.String(value)
+}
+
+// String replaces MAC and IPv4 addresses in free text
+func (a *Anonymizer) String(s string) string {
+	s = macPattern.ReplaceAllStringFunc(s, a.mac)
+	return ipPattern.ReplaceAllStringFunc(s, a.ip)
+}
+
+// mac returns the replacement of a MAC address, keeping its separators and case
+func (a *Anonymizer) mac(mac string) string {
+	digits := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+
+	replacement, ok := a.macs[digits]
+	if !ok {
+		replacement = fmt.Sprintf("%s%06X", macPrefix, len(a.macs)+1)
+		a.macs[digits] = replacement
+	}
+
+	// Restore the separators of the original
+	var out strings.Builder
+	hex := 0
+	for _, r := range mac {
+		if r == ':' || r == '-' {
+			out.WriteRune(r)
+			continue
+		}
+		out.WriteByte(replacement[hex])
+		hex++
+	}
+
+	if strings.ToLower(mac) == mac {
+		return strings.ToLower(out.String())
+	}
+	return out.String()
+}
+
+// ip returns the replacement of an IPv4 address. Special addresses such as netmasks,
+// loopback and unspecified addresses are kept.
+func (a *Anonymizer) ip(s string) string {
+	addr, err := netip.ParseAddr(s)
+	if err != nil || !addr.Is4() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() || addr.As4()[0] >= 240 {
+		return s
+	}
+
+	replacement, ok := a.ips[s]
+	if !ok {
+		replacement = fmt.Sprintf("%s%d", ipPrefix, len(a.ips)%254+1)
+		a.ips[s] = replacement
+	}
+	return replacement
+}
+
+// replace returns the numbered replacement of a value in a category, e.g. ssid-1
+func (a *Anonymizer) replace(category, value string) string {
+	values, ok := a.values[category]
+	if !ok {
+		values = make(map[string]string)
+		a.values[category] = values
+	}
+
+	replacement, ok := values[value]
+	if !ok {
+		replacement = fmt.Sprintf("%s-%d", category, len(values)+1)
+		values[value] = replacement
+	}
+	return replacement
+}
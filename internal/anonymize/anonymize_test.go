@@ -0,0 +1,115 @@
+package anonymize
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizer_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "compact mac", input: "C8F09E1A2B3C", want: "AABBCC000001"},
+		{name: "lower case mac in hostname", input: "shellyplug-s-c8f09e1a2b3c", want: "shellyplug-s-aabbcc000001"},
+		{name: "separated mac", input: "c8:f0:9e:1a:2b:3c", want: "aa:bb:cc:00:00:01"},
+		{name: "other mac", input: "C8-F0-9E-00-00-FF", want: "AA-BB-CC-00-00-02"},
+		{name: "ip", input: "connected to 192.168.1.57:1883", want: "connected to 192.0.2.1:1883"},
+		{name: "netmask is kept", input: "255.255.255.0", want: "255.255.255.0"},
+		{name: "unspecified is kept", input: "0.0.0.0", want: "0.0.0.0"},
+		{name: "firmware is kept", input: "20230913-112003/v1.14.0-gcb84623", want: "20230913-112003/v1.14.0-gcb84623"},
+		{name: "hash is kept", input: strings.Repeat("ab", 32), want: strings.Repeat("ab", 32)},
+	}
+
+	// Replacements are numbered in order of appearance and shared between calls
+	a := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.String(tt.input); got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizer_JSON(t *testing.T) {
+	a := New()
+
+	shelly, err := a.JSON([]byte(`{
+		"name": "Living Room",
+		"id": "shellyplus1pm-c8f09e1a2b3c",
+		"mac": "C8F09E1A2B3C",
+		"gen": 2,
+		"fw_id": "20231106-152106/1.0.8-gd0fb8d5"
+	}`))
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+
+	config, err := a.JSON([]byte(`{
+		"sys": {
+			"device": {"name": "Living Room", "mac": "C8F09E1A2B3C"},
+			"location": {"tz": "Europe/Tallinn", "lat": 59.437, "lon": 24.7536}
+		},
+		"wifi": {
+			"sta": {"ssid": "Home WiFi", "ip": "192.168.1.57", "netmask": "255.255.255.0", "pass": "hunter2"},
+			"sta1": {"ssid": null}
+		},
+		"mqtt": {"server": "192.168.1.10:1883", "user": "mqtt-user", "client_id": "shellyplus1pm-c8f09e1a2b3c"},
+		"switch:0": {"name": null, "power_limit": 3500}
+	}`))
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+
+	var gotShelly map[string]interface{}
+	if err := json.Unmarshal(shelly, &gotShelly); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	wantShelly := map[string]interface{}{
+		"name":  "name-1",
+		"id":    "shellyplus1pm-aabbcc000001",
+		"mac":   "AABBCC000001",
+		"gen":   float64(2),
+		"fw_id": "20231106-152106/1.0.8-gd0fb8d5",
+	}
+	for key, want := range wantShelly {
+		if gotShelly[key] != want {
+			t.Errorf("shelly %s = %v, want %v", key, gotShelly[key], want)
+		}
+	}
+
+	for _, want := range []string{
+		`"name": "name-1"`,
+		`"mac": "AABBCC000001"`,
+		`"tz": "Europe/Tallinn"`,
+		`"lat": 0`,
+		`"lon": 0`,
+		`"ssid": "ssid-1"`,
+		`"ip": "192.0.2.1"`,
+		`"netmask": "255.255.255.0"`,
+		`"pass": ""`,
+		`"server": "192.0.2.2:1883"`,
+		`"user": "user-1"`,
+		`"client_id": "client-1"`,
+		`"ssid": null`,
+		`"power_limit": 3500`,
+	} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("JSON() result does not contain %s:\n%s", want, config)
+		}
+	}
+	for _, secret := range []string{"Living Room", "Home WiFi", "hunter2", "59.437", "c8f09e", "192.168.1"} {
+		if strings.Contains(strings.ToLower(string(config)), strings.ToLower(secret)) {
+			t.Errorf("JSON() result contains %q:\n%s", secret, config)
+		}
+	}
+}
+
+func TestAnonymizer_JSON_Invalid(t *testing.T) {
+	if _, err := New().JSON([]byte(`{"mac":`)); err == nil {
+		t.Error("JSON() expected error for invalid document, got nil")
+	}
+}
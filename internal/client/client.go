@@ -5,7 +5,13 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aimar/shelly-prometheus-exporter/internal/config"
 	"github.com/sirupsen/logrus"
@@ -17,46 +23,136 @@ const (
 	ErrMsgExecuteRequest = "failed to execute request: %w"
 )
 
+// API paths reported by Client.API
+const (
+	// APIRPC is the unauthenticated GET /rpc/Shelly.GetStatus of Gen2+ devices
+	APIRPC = "/rpc/Shelly.GetStatus"
+	// APIRPCAuth is the JSON-RPC POST to /rpc used for protected Gen2+ devices
+	APIRPCAuth = "/rpc"
+	// APILegacy is the /status endpoint of Gen1 devices
+	APILegacy = "/status"
+	// APIPush is a status pushed by the device, such as a CoIoT update
+	APIPush = "push"
+)
+
+// Components of a status, see StatusResponse.HasComponent
+const (
+	// ComponentEM is the three-phase energy meter of the Pro 3EM
+	ComponentEM = "em:0"
+	// ComponentEMData holds the energy totals of the three-phase energy meter
+	ComponentEMData = "emdata:0"
+	// ComponentTemperature is the device temperature sensor
+	ComponentTemperature = "temperature:0"
+	// ComponentMeters are the single-phase power meters of Gen1 devices, their totals start
+	// from 0 after every reboot
+	ComponentMeters = "meters"
+	// ComponentEMeters are the energy meters of the Gen1 Shelly EM and 3EM
+	ComponentEMeters = "emeters"
+	// ComponentPowerMeters are the metered switches (switch:N) and power meters (pm1:N) of
+	// Gen2+ devices, their totals survive reboots
+	ComponentPowerMeters = "power_meters"
+	// ComponentOvertemperature is the overtemperature protection, reported by Gen1 devices
+	// and by the switches of Gen2+ devices
+	ComponentOvertemperature = "overtemperature"
+	// ComponentSys is the system status of Gen2+ devices, the status of Gen1 devices is
+	// converted into it without the reset reason and pending restart
+	ComponentSys = "sys"
+)
+
+// wattMinutesPerWattHour converts the energy totals of Gen1 power meters, which are
+// reported in watt-minutes, to watt-hours
+const wattMinutesPerWattHour = 60
+
+// legacyUpdatePending is the update status of Gen1 devices that offer an update
+const legacyUpdatePending = "pending"
+
+// StatusError is returned when a device answers with an unexpected HTTP status code
+type StatusError struct {
+	StatusCode int
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// StatusSource provides device status received without polling, such as CoIoT updates
+type StatusSource interface {
+	// Status returns the latest status of the device with the given MAC address, if a fresh
+	// one is available
+	Status(mac string) (*StatusResponse, bool)
+}
+
 // Client represents a client for interacting with Shelly devices
 type Client struct {
-	httpClient *http.Client
-	logger     *logrus.Logger
-	baseURL    string
+	httpClient   *http.Client
+	logger       *logrus.Logger
+	baseURL      string
+	username     string
+	password     string
+	rpc          rpcState
+	statusSource StatusSource
+	id           string
+	labels       map[string]string
+	timeout      time.Duration
+	api          atomic.Value
+	polled       atomic.Pointer[StatusResponse]
+	firmware     firmwareCache
+}
+
+// firmwareCache holds the installed firmware of a Gen2 device, which is not part of its
+// status. It is refetched when the device rebooted, as it does to install an update.
+type firmwareCache struct {
+	mu      sync.Mutex
+	version string
+	uptime  int
+	known   bool
 }
 
 // New creates a new Shelly client
 func New(baseURL string, cfg *config.Config, logger *logrus.Logger) *Client {
-	// Create HTTP client with TLS configuration
-	httpClient := &http.Client{
-		Timeout: cfg.ScrapeTimeout,
+	httpClient, err := NewHTTPClient(cfg)
+	if err != nil {
+		logger.WithError(err).WithField("device", config.RedactURL(baseURL)).Warn("Failed to load TLS configuration")
 	}
 
-	if cfg.TLS.Enabled {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
-		}
+	return &Client{
+		httpClient: httpClient,
+		logger:     logger,
+		baseURL:    baseURL,
+		username:   cfg.Auth.Username,
+		password:   string(cfg.Auth.Password),
+		timeout:    cfg.ScrapeTimeout,
+	}
+}
 
-		if cfg.TLS.CAFile != "" {
-			// TODO: Load CA certificate
-			_ = cfg.TLS.CAFile // Suppress unused variable warning
-		}
+// NewHTTPClient creates an HTTP client for device connections with the scrape timeout and
+// TLS configuration. The returned client is usable even if an error is returned, it then
+// connects without the CA and client certificates that could not be loaded.
+func NewHTTPClient(cfg *config.Config) (*http.Client, error) {
+	httpClient := &http.Client{
+		Timeout: cfg.ScrapeTimeout,
+	}
 
-		if cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "" {
-			// TODO: Load client certificate
-			_ = cfg.TLS.CertFile // Suppress unused variable warning
-			_ = cfg.TLS.KeyFile  // Suppress unused variable warning
-		}
+	if !cfg.TLS.Enabled {
+		return httpClient, nil
+	}
 
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: tlsConfig,
+	tlsConfig, err := cfg.TLS.ClientConfig()
+	if err != nil {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
 		}
 	}
-
-	return &Client{
-		httpClient: httpClient,
-		logger:     logger,
-		baseURL:    baseURL,
+	httpClient.Transport = &http.Transport{
+		TLSClientConfig: tlsConfig,
 	}
+	return httpClient, err
+}
+
+// Timeout returns the scrape timeout of the device, which bounds all requests of a scrape
+func (c *Client) Timeout() time.Duration {
+	return c.timeout
 }
 
 // BaseURL returns the base URL of the client
@@ -64,8 +160,89 @@ func (c *Client) BaseURL() string {
 	return c.baseURL
 }
 
+// SetStatusSource sets a source of pushed status updates that is consulted before polling the device
+func (c *Client) SetStatusSource(source StatusSource) {
+	c.statusSource = source
+}
+
+// SetID sets a stable identity of the device, such as the MAC address of a discovered device
+// whose address may change. It must be called before the client is handed to a collector.
+func (c *Client) SetID(id string) {
+	c.id = id
+}
+
+// ID returns the identity of the device used as its device label and to key the state kept
+// for it, the base URL unless an ID was set
+func (c *Client) ID() string {
+	if c.id != "" {
+		return c.id
+	}
+	return c.baseURL
+}
+
+// SetLabels sets the labels added to every series of the device.
+// It must be called before the client is handed to a collector.
+func (c *Client) SetLabels(labels map[string]string) {
+	c.labels = labels
+}
+
+// Labels returns the labels added to every series of the device
+func (c *Client) Labels() map[string]string {
+	return c.labels
+}
+
+// API returns the API path of the last successful GetStatus, one of the API constants,
+// or an empty string if no status has been retrieved yet
+func (c *Client) API() string {
+	api, _ := c.api.Load().(string)
+	return api
+}
+
+// setBasicAuth adds HTTP basic authentication used by Gen1 devices with restricted login
+func (c *Client) setBasicAuth(req *http.Request) {
+	if c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
 // GetStatus retrieves the status from a Shelly device
 func (c *Client) GetStatus(ctx context.Context) (*StatusResponse, error) {
+	// Use pushed updates when available
+	if status, ok := c.pushedStatus(); ok {
+		c.api.Store(APIPush)
+		return status, nil
+	}
+
+	status, err := c.pollStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.polled.Store(status)
+	return status, nil
+}
+
+// pushedStatus returns the last polled status updated with a fresh pushed status. Pushed
+// updates only carry the switch and meter values, so the device is polled until its MAC
+// address and system information are known.
+func (c *Client) pushedStatus() (*StatusResponse, bool) {
+	if c.statusSource == nil {
+		return nil, false
+	}
+
+	polled := c.polled.Load()
+	if polled == nil || polled.Sys.Mac == "" {
+		return nil, false
+	}
+
+	pushed, ok := c.statusSource.Status(polled.Sys.Mac)
+	if !ok {
+		return nil, false
+	}
+	return polled.withPushed(pushed), true
+}
+
+// pollStatus requests the status from the device
+func (c *Client) pollStatus(ctx context.Context) (*StatusResponse, error) {
 	// Try Pro3em RPC API first
 	url := fmt.Sprintf("%s/rpc/Shelly.GetStatus", c.baseURL)
 
@@ -84,6 +261,19 @@ func (c *Client) GetStatus(ctx context.Context) (*StatusResponse, error) {
 		}
 	}()
 
+	// Protected Gen2 devices issue a digest challenge, protected Gen1 devices a basic one
+	// and are left to the legacy API below
+	if resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Digest ") {
+		// Protected Gen2 device, use the authenticated JSON-RPC transport
+		var status StatusResponse
+		if err := c.Call(ctx, "Shelly.GetStatus", nil, &status); err != nil {
+			return nil, err
+		}
+		status.FirmwareVersion = c.firmwareVersion(ctx, status.Sys.Uptime)
+		c.api.Store(APIRPCAuth)
+		return &status, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		// Try legacy API for Shelly 1PM
 		if err := resp.Body.Close(); err != nil {
@@ -102,6 +292,8 @@ func (c *Client) GetStatus(ctx context.Context) (*StatusResponse, error) {
 		return c.getStatusLegacy(ctx)
 	}
 
+	status.FirmwareVersion = c.firmwareVersion(ctx, status.Sys.Uptime)
+	c.api.Store(APIRPC)
 	return &status, nil
 }
 
@@ -113,6 +305,7 @@ func (c *Client) getStatusLegacy(ctx context.Context) (*StatusResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf(ErrMsgCreateRequest, err)
 	}
+	c.setBasicAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -125,12 +318,20 @@ func (c *Client) getStatusLegacy(ctx context.Context) (*StatusResponse, error) {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	// Parse legacy JSON response
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
 	var legacyStatus LegacyStatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&legacyStatus); err != nil {
+	if err := json.Unmarshal(raw, &legacyStatus); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	legacyKeys, err := jsonKeys(raw)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
 	}
 
@@ -143,6 +344,7 @@ func (c *Client) getStatusLegacy(ctx context.Context) (*StatusResponse, error) {
 		FSSize:  legacyStatus.FSSize,
 		FSFree:  legacyStatus.FSFree,
 	}
+	status.components = make(map[string]bool)
 
 	// Set system info
 	status.Sys.Mac = legacyStatus.Mac
@@ -160,23 +362,64 @@ func (c *Client) getStatusLegacy(ctx context.Context) (*StatusResponse, error) {
 		status.Wifi.Status = "got ip"
 	}
 
-	// Set temperature
-	status.Temperature.TC = legacyStatus.Temperature
+	// Set temperature, devices without a sensor do not report it
+	if legacyKeys["temperature"] {
+		status.Temperature.TC = legacyStatus.Temperature
+		status.TemperatureStatus = legacyStatus.TemperatureStatus
+		status.SetComponent(ComponentTemperature)
+	}
+	if legacyKeys["overtemperature"] {
+		status.Overtemperature = legacyStatus.Overtemperature
+		status.SetComponent(ComponentOvertemperature)
+	}
+
+	// Set firmware info, new_version is the installed version while no update is available
+	// and beta_version is the latest beta, which may be the installed version
+	status.FirmwareVersion = legacyStatus.Update.OldVersion
+	status.HasUpdate = legacyStatus.Update.HasUpdate || legacyStatus.Update.Status == legacyUpdatePending
+	if status.HasUpdate && legacyStatus.Update.NewVersion != legacyStatus.Update.OldVersion {
+		status.Sys.AvailableUpdates.Stable.Version = legacyStatus.Update.NewVersion
+	}
+	if beta := legacyStatus.Update.BetaVersion; beta != "" && beta != legacyStatus.Update.OldVersion {
+		status.Sys.AvailableUpdates.Beta.Version = beta
+	}
 
 	// Set relay info (Shelly 1PM and Plug S have one relay)
 	if len(legacyStatus.Relays) > 0 {
 		status.Relays = legacyStatus.Relays
 	}
 
-	// Set meter info (Shelly 1PM and Plug S have one meter)
+	// Set meter info, power meters count in watt-minutes and energy meters in watt-hours
 	if len(legacyStatus.Meters) > 0 {
-		// Convert to EM format for consistency
-		meter := legacyStatus.Meters[0]
-		status.EM.AActPower = meter.Power
-		status.EM.TotalActPower = meter.Power
-		status.EMData.TotalAct = float64(meter.Total)
+		status.Meters = legacyStatus.Meters
+		status.SetComponent(ComponentMeters)
+		for _, meter := range legacyStatus.Meters {
+			status.EnergyMeters = append(status.EnergyMeters, EnergyMeter{
+				Power: meter.Power,
+				Total: float64(meter.Total) / wattMinutesPerWattHour,
+			})
+		}
+	}
+	if len(legacyStatus.EMeters) > 0 {
+		status.SetComponent(ComponentEMeters)
+		for _, emeter := range legacyStatus.EMeters {
+			status.EnergyMeters = append(status.EnergyMeters, EnergyMeter{
+				Power: emeter.Power,
+				Total: emeter.Total,
+			})
+		}
+	}
+
+	// Convert to EM format for consistency, the totals cover all meters
+	if len(status.EnergyMeters) > 0 {
+		status.EM.AActPower = status.EnergyMeters[0].Power
+		for _, meter := range status.EnergyMeters {
+			status.EM.TotalActPower += meter.Power
+			status.EMData.TotalAct += meter.Total
+		}
 	}
 
+	c.api.Store(APILegacy)
 	return status, nil
 }
 
@@ -188,6 +431,7 @@ func (c *Client) GetMeters(ctx context.Context) (*MetersResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf(ErrMsgCreateRequest, err)
 	}
+	c.setBasicAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -200,7 +444,7 @@ func (c *Client) GetMeters(ctx context.Context) (*MetersResponse, error) {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	// Parse JSON response
@@ -212,32 +456,128 @@ func (c *Client) GetMeters(ctx context.Context) (*MetersResponse, error) {
 	return &meters, nil
 }
 
+// GetRawStatus retrieves the unparsed status document of a device.
+// Gen2+ devices are queried through Shelly.GetStatus, Gen1 devices through /status.
+func (c *Client) GetRawStatus(ctx context.Context, gen int) (json.RawMessage, error) {
+	if gen >= 2 {
+		var raw json.RawMessage
+		if err := c.Call(ctx, "Shelly.GetStatus", nil, &raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+
+	return c.GetRaw(ctx, "/status")
+}
+
+// GetRaw retrieves the unparsed JSON document of an HTTP endpoint, e.g. /settings of a Gen1 device
+func (c *Client) GetRaw(ctx context.Context, path string) (json.RawMessage, error) {
+	url := c.baseURL + path
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf(ErrMsgCreateRequest, err)
+	}
+	c.setBasicAuth(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf(ErrMsgExecuteRequest, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.logger.Warnf("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrAuthRequired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	return raw, nil
+}
+
+// GetDeviceInfo retrieves the device information of a Gen2 device
+func (c *Client) GetDeviceInfo(ctx context.Context) (*DeviceInfo, error) {
+	var info DeviceInfo
+	if err := c.Call(ctx, "Shelly.GetDeviceInfo", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// firmwareVersion returns the installed firmware of a Gen2 device given its uptime. The
+// device info is only fetched again after a reboot. If that fails the last known version is
+// returned and the fetch is retried on the next call.
+func (c *Client) firmwareVersion(ctx context.Context, uptime int) string {
+	c.firmware.mu.Lock()
+	defer c.firmware.mu.Unlock()
+
+	if c.firmware.known && uptime >= c.firmware.uptime {
+		c.firmware.uptime = uptime
+		return c.firmware.version
+	}
+
+	info, err := c.GetDeviceInfo(ctx)
+	if err != nil {
+		c.logger.WithError(err).WithField("device", config.RedactURL(c.baseURL)).Debug("Failed to get device info")
+		return c.firmware.version
+	}
+
+	c.firmware.version = info.Version
+	c.firmware.uptime = uptime
+	c.firmware.known = true
+	return c.firmware.version
+}
+
+// GetSysConfig retrieves the system configuration of a Gen2 device
+func (c *Client) GetSysConfig(ctx context.Context) (*SysConfig, error) {
+	var sysConfig SysConfig
+	if err := c.Call(ctx, "Sys.GetConfig", nil, &sysConfig); err != nil {
+		return nil, err
+	}
+	return &sysConfig, nil
+}
+
+// GetEMDataStatus retrieves the energy meter totals of a Gen2 energy meter
+func (c *Client) GetEMDataStatus(ctx context.Context, id int) (*EMDataStatus, error) {
+	var status EMDataStatus
+	if err := c.Call(ctx, "EMData.GetStatus", map[string]int{"id": id}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
 // StatusResponse represents the status response from a Shelly device
 type StatusResponse struct {
 	// System information
 	Sys struct {
-		Mac              string `json:"mac"`
-		RestartRequired  bool   `json:"restart_required"`
-		Time             string `json:"time"`
-		Unixtime         int64  `json:"unixtime"`
-		LastSyncTs       int64  `json:"last_sync_ts"`
-		Uptime           int    `json:"uptime"`
-		RAMSize          int    `json:"ram_size"`
-		RAMFree          int    `json:"ram_free"`
-		RAMMinFree       int    `json:"ram_min_free"`
-		FSSize           int    `json:"fs_size"`
-		FSFree           int    `json:"fs_free"`
-		CfgRev           int    `json:"cfg_rev"`
-		KvsRev           int    `json:"kvs_rev"`
-		ScheduleRev      int    `json:"schedule_rev"`
-		WebhookRev       int    `json:"webhook_rev"`
-		BtrelayRev       int    `json:"btrelay_rev"`
-		AvailableUpdates struct {
-			Stable struct {
-				Version string `json:"version"`
-			} `json:"stable"`
-		} `json:"available_updates"`
-		ResetReason int `json:"reset_reason"`
+		Mac              string           `json:"mac"`
+		RestartRequired  bool             `json:"restart_required"`
+		Time             string           `json:"time"`
+		Unixtime         int64            `json:"unixtime"`
+		LastSyncTs       int64            `json:"last_sync_ts"`
+		Uptime           int              `json:"uptime"`
+		RAMSize          int              `json:"ram_size"`
+		RAMFree          int              `json:"ram_free"`
+		RAMMinFree       int              `json:"ram_min_free"`
+		FSSize           int              `json:"fs_size"`
+		FSFree           int              `json:"fs_free"`
+		CfgRev           int              `json:"cfg_rev"`
+		KvsRev           int              `json:"kvs_rev"`
+		ScheduleRev      int              `json:"schedule_rev"`
+		WebhookRev       int              `json:"webhook_rev"`
+		BtrelayRev       int              `json:"btrelay_rev"`
+		AvailableUpdates AvailableUpdates `json:"available_updates"`
+		ResetReason      int              `json:"reset_reason"`
 	} `json:"sys"`
 
 	// WiFi information
@@ -315,9 +655,241 @@ type StatusResponse struct {
 	FSFree    int    `json:"fs_free"`
 	Uptime    int    `json:"uptime"`
 
+	// Overtemperature protection and temperature status of Gen1 devices
+	Overtemperature   bool   `json:"overtemperature"`
+	TemperatureStatus string `json:"temperature_status"`
+
+	// FirmwareVersion is the installed firmware, taken from the status of Gen1 devices and
+	// from the device info of Gen2 devices
+	FirmwareVersion string `json:"-"`
+
 	// Relay and meter information (for Shelly 1PM and Plug S)
 	Relays []Relay `json:"relays"`
 	Meters []Meter `json:"meters"`
+
+	// EnergyMeters are the meters of Gen1 devices with their totals in watt-hours, one per channel
+	EnergyMeters []EnergyMeter `json:"-"`
+
+	// components holds the components reported by the device
+	components map[string]bool
+}
+
+// UnmarshalJSON implements json.Unmarshaler and records the components present in the document
+func (s *StatusResponse) UnmarshalJSON(data []byte) error {
+	type plain StatusResponse
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+	s.components = keys
+	return s.decodePowerMeters(data)
+}
+
+// powerMeter is the status of a Gen2+ switch or power meter. Switches without power
+// metering do not report aenergy.
+type powerMeter struct {
+	ID      int     `json:"id"`
+	Output  *bool   `json:"output"`
+	Source  string  `json:"source"`
+	APower  float64 `json:"apower"`
+	AEnergy *struct {
+		Total float64 `json:"total"`
+	} `json:"aenergy"`
+	Temperature *struct {
+		TC *float64 `json:"tC"`
+		TF *float64 `json:"tF"`
+	} `json:"temperature"`
+	Errors []string `json:"errors"`
+}
+
+// powerMeterTypes are the Gen2+ component types carrying a power meter, in the order their
+// meters are reported
+var powerMeterTypes = []string{"switch", "pm1"}
+
+// decodePowerMeters converts the switches and power meters of a Gen2+ status like the meters
+// of Gen1 devices: outputs become relays, metered components energy meters in watt-hours.
+// Switches report their overtemperature protection as an overtemp error. The totals are
+// left to the three-phase meter of devices that have one.
+func (s *StatusResponse) decodePowerMeters(data []byte) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	for _, componentType := range powerMeterTypes {
+		var meters []powerMeter
+		for key, value := range values {
+			if !strings.HasPrefix(key, componentType+":") || string(value) == "null" {
+				continue
+			}
+			var meter powerMeter
+			if err := json.Unmarshal(value, &meter); err != nil {
+				return fmt.Errorf("failed to decode %s: %w", key, err)
+			}
+			meters = append(meters, meter)
+		}
+		slices.SortFunc(meters, func(a, b powerMeter) int { return a.ID - b.ID })
+
+		for _, meter := range meters {
+			if meter.Output != nil {
+				if slices.Contains(meter.Errors, "overtemp") {
+					s.Overtemperature = true
+				}
+				s.SetComponent(ComponentOvertemperature)
+				s.Relays = append(s.Relays, Relay{
+					IsOn:      *meter.Output,
+					Overpower: slices.Contains(meter.Errors, "overpower"),
+					IsValid:   true,
+					Source:    meter.Source,
+				})
+			}
+			if meter.AEnergy != nil {
+				s.EnergyMeters = append(s.EnergyMeters, EnergyMeter{Power: meter.APower, Total: meter.AEnergy.Total})
+			}
+			// Switches report the device temperature, the first one is used
+			if meter.Temperature != nil && meter.Temperature.TC != nil && !s.HasComponent(ComponentTemperature) {
+				s.Temperature.TC = *meter.Temperature.TC
+				if meter.Temperature.TF != nil {
+					s.Temperature.TF = *meter.Temperature.TF
+				}
+				s.SetComponent(ComponentTemperature)
+			}
+		}
+	}
+
+	if len(s.EnergyMeters) == 0 || s.HasComponent(ComponentEM) || s.HasComponent(ComponentEMData) {
+		return nil
+	}
+
+	s.SetComponent(ComponentPowerMeters)
+	s.EM.AActPower = s.EnergyMeters[0].Power
+	for _, meter := range s.EnergyMeters {
+		s.EM.TotalActPower += meter.Power
+		s.EMData.TotalAct += meter.Total
+	}
+	return nil
+}
+
+// withPushed returns a copy of the status with the relay, meter and temperature values of a
+// pushed status. The remaining values, such as the uptime, memory and WiFi, are kept.
+func (s *StatusResponse) withPushed(pushed *StatusResponse) *StatusResponse {
+	merged := *s
+	merged.components = maps.Clone(s.components)
+	for component := range pushed.components {
+		merged.SetComponent(component)
+	}
+
+	if len(pushed.Relays) > 0 {
+		merged.Relays = pushed.Relays
+	}
+	if pushed.HasComponent(ComponentMeters) {
+		merged.EM = pushed.EM
+		merged.EMData = pushed.EMData
+		merged.EnergyMeters = pushed.EnergyMeters
+	}
+	if pushed.HasComponent(ComponentTemperature) {
+		merged.Temperature = pushed.Temperature
+	}
+	if pushed.HasComponent(ComponentOvertemperature) {
+		merged.Overtemperature = pushed.Overtemperature
+	}
+	return &merged
+}
+
+// HasComponent reports whether the device reported a component, e.g. ComponentEM.
+// Series of components a device does not have are not exported.
+func (s *StatusResponse) HasComponent(component string) bool {
+	return s.components[component]
+}
+
+// SetComponent marks a component as present in a status that was not decoded from
+// Shelly.GetStatus, such as a converted Gen1 or CoIoT status
+func (s *StatusResponse) SetComponent(component string) {
+	if s.components == nil {
+		s.components = make(map[string]bool)
+	}
+	s.components[component] = true
+}
+
+// jsonKeys returns the keys of a JSON object that have a non-null value
+func jsonKeys(data []byte) (map[string]bool, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(values))
+	for key, value := range values {
+		if string(value) != "null" {
+			keys[key] = true
+		}
+	}
+	return keys, nil
+}
+
+// AvailableUpdates are the firmware updates a device offers per release channel. A channel
+// without a newer firmware has an empty version.
+type AvailableUpdates struct {
+	Stable FirmwareUpdate `json:"stable"`
+	Beta   FirmwareUpdate `json:"beta"`
+}
+
+// FirmwareUpdate is a firmware update offered by a device
+type FirmwareUpdate struct {
+	Version string `json:"version"`
+}
+
+// DeviceInfo represents the Shelly.GetDeviceInfo response of a Gen2 device
+type DeviceInfo struct {
+	Name       string `json:"name"`
+	ID         string `json:"id"`
+	Mac        string `json:"mac"`
+	Model      string `json:"model"`
+	Gen        int    `json:"gen"`
+	FirmwareID string `json:"fw_id"`
+	Version    string `json:"ver"`
+	App        string `json:"app"`
+	AuthEn     bool   `json:"auth_en"`
+	AuthDomain string `json:"auth_domain"`
+	Profile    string `json:"profile"`
+}
+
+// SysConfig represents the Sys.GetConfig response of a Gen2 device
+type SysConfig struct {
+	Device struct {
+		Name         string `json:"name"`
+		Mac          string `json:"mac"`
+		FirmwareID   string `json:"fw_id"`
+		EcoMode      bool   `json:"eco_mode"`
+		Discoverable bool   `json:"discoverable"`
+		Profile      string `json:"profile"`
+	} `json:"device"`
+	Location struct {
+		TZ  string  `json:"tz"`
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"location"`
+	SNTP struct {
+		Server string `json:"server"`
+	} `json:"sntp"`
+	CfgRev int `json:"cfg_rev"`
+}
+
+// EMDataStatus represents the EMData.GetStatus response of a Gen2 energy meter
+type EMDataStatus struct {
+	ID                 int     `json:"id"`
+	ATotalActEnergy    float64 `json:"a_total_act_energy"`
+	ATotalActRetEnergy float64 `json:"a_total_act_ret_energy"`
+	BTotalActEnergy    float64 `json:"b_total_act_energy"`
+	BTotalActRetEnergy float64 `json:"b_total_act_ret_energy"`
+	CTotalActEnergy    float64 `json:"c_total_act_energy"`
+	CTotalActRetEnergy float64 `json:"c_total_act_ret_energy"`
+	TotalAct           float64 `json:"total_act"`
+	TotalActRet        float64 `json:"total_act_ret"`
 }
 
 // LegacyStatusResponse represents the legacy API response from Shelly 1PM and Plug S
@@ -338,23 +910,26 @@ type LegacyStatusResponse struct {
 		Connected bool `json:"connected"`
 	} `json:"mqtt"`
 
-	Time              string  `json:"time"`
-	Unixtime          int64   `json:"unixtime"`
-	Serial            int     `json:"serial"`
-	HasUpdate         bool    `json:"has_update"`
-	Mac               string  `json:"mac"`
-	Relays            []Relay `json:"relays"`
-	Meters            []Meter `json:"meters"`
-	Temperature       float64 `json:"temperature"`
-	Overtemperature   bool    `json:"overtemperature"`
-	TemperatureStatus string  `json:"temperature_status"`
+	Time              string   `json:"time"`
+	Unixtime          int64    `json:"unixtime"`
+	Serial            int      `json:"serial"`
+	HasUpdate         bool     `json:"has_update"`
+	Mac               string   `json:"mac"`
+	Relays            []Relay  `json:"relays"`
+	Meters            []Meter  `json:"meters"`
+	EMeters           []EMeter `json:"emeters"`
+	Temperature       float64  `json:"temperature"`
+	Overtemperature   bool     `json:"overtemperature"`
+	TemperatureStatus string   `json:"temperature_status"`
 	Update            struct {
-		Status     string `json:"status"`
-		HasUpdate  bool   `json:"has_update"`
-		NewVersion string `json:"new_version"`
-		OldVersion string `json:"old_version"`
+		Status      string `json:"status"`
+		HasUpdate   bool   `json:"has_update"`
+		NewVersion  string `json:"new_version"`
+		OldVersion  string `json:"old_version"`
+		BetaVersion string `json:"beta_version"`
 	} `json:"update"`
-	RAMSize int `json:"ram_size"`
+	// Gen1 devices report the RAM size as ram_total
+	RAMSize int `json:"ram_total"`
 	RAMFree int `json:"ram_free"`
 	FSSize  int `json:"fs_size"`
 	FSFree  int `json:"fs_free"`
@@ -383,7 +958,7 @@ type Relay struct {
 	Source         string `json:"source"`
 }
 
-// Meter represents a meter in a Shelly device
+// Meter represents a meter in a Shelly device. Total is in watt-minutes.
 type Meter struct {
 	Power     float64   `json:"power"`
 	Overpower float64   `json:"overpower"`
@@ -392,3 +967,21 @@ type Meter struct {
 	Counters  []float64 `json:"counters"`
 	Total     int64     `json:"total"`
 }
+
+// EMeter represents an energy meter of a Gen1 Shelly EM or 3EM. Totals are in watt-hours.
+type EMeter struct {
+	Power         float64 `json:"power"`
+	Reactive      float64 `json:"reactive"`
+	Voltage       float64 `json:"voltage"`
+	Current       float64 `json:"current"`
+	PF            float64 `json:"pf"`
+	IsValid       bool    `json:"is_valid"`
+	Total         float64 `json:"total"`
+	TotalReturned float64 `json:"total_returned"`
+}
+
+// EnergyMeter is a power meter channel with its energy total in watt-hours
+type EnergyMeter struct {
+	Power float64
+	Total float64
+}
@@ -3,8 +3,14 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -70,6 +76,68 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		tls     config.TLSConfig
+		wantErr bool
+		wantOK  bool
+	}{
+		{
+			name:   "configured CA",
+			tls:    config.TLSConfig{Enabled: true, CAFile: caFile},
+			wantOK: true,
+		},
+		{
+			name:   "insecure skip verify",
+			tls:    config.TLSConfig{Enabled: true, InsecureSkipVerify: true},
+			wantOK: true,
+		},
+		{
+			name:   "unknown CA",
+			tls:    config.TLSConfig{Enabled: true},
+			wantOK: false,
+		},
+		{
+			name:    "missing CA file",
+			tls:     config.TLSConfig{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+			wantErr: true,
+			wantOK:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := NewHTTPClient(&config.Config{ScrapeTimeout: time.Second, TLS: tt.tls})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if httpClient.Timeout != time.Second {
+				t.Errorf("NewHTTPClient() timeout = %v, want 1s", httpClient.Timeout)
+			}
+
+			resp, err := httpClient.Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Get() error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
 func TestClient_BaseURL(t *testing.T) {
 	cfg := &config.Config{
 		ScrapeTimeout: 10 * time.Second,
@@ -106,11 +174,7 @@ func TestClient_GetStatus_RPC(t *testing.T) {
 			ScheduleRev     int    `json:"schedule_rev"`
 			WebhookRev      int    `json:"webhook_rev"`
 			BtrelayRev      int    `json:"btrelay_rev"`
-			AvailableUpdates struct {
-				Stable struct {
-					Version string `json:"version"`
-				} `json:"stable"`
-			} `json:"available_updates"`
+			AvailableUpdates AvailableUpdates `json:"available_updates"`
 			ResetReason int `json:"reset_reason"`
 		}{
 			Mac:     "AA:BB:CC:DD:EE:FF",
@@ -308,6 +372,372 @@ func TestClient_GetStatus_Legacy(t *testing.T) {
 	if status.EM.AActPower != 150.5 {
 		t.Errorf("GetStatus() EM.AActPower = %v, want 150.5", status.EM.AActPower)
 	}
+	if !status.HasComponent(ComponentMeters) || !status.HasComponent(ComponentTemperature) {
+		t.Error("GetStatus() legacy status should have meters and temperature")
+	}
+	if status.HasComponent(ComponentEM) {
+		t.Error("GetStatus() legacy status should not have a three-phase meter")
+	}
+}
+
+func TestClient_GetStatus_LegacyMeters(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		component  string
+		want       []EnergyMeter
+		wantPower  float64
+		wantEnergy float64
+	}{
+		{
+			name:       "power meters in watt-minutes",
+			body:       `{"mac":"AABBCCDDEEFF","meters":[{"power":100,"total":6000},{"power":20.5,"total":90}]}`,
+			component:  ComponentMeters,
+			want:       []EnergyMeter{{Power: 100, Total: 100}, {Power: 20.5, Total: 1.5}},
+			wantPower:  120.5,
+			wantEnergy: 101.5,
+		},
+		{
+			name:       "energy meters in watt-hours",
+			body:       `{"mac":"AABBCCDDEEFF","emeters":[{"power":1800,"total":2400.5},{"power":0,"total":80}]}`,
+			component:  ComponentEMeters,
+			want:       []EnergyMeter{{Power: 1800, Total: 2400.5}, {Power: 0, Total: 80}},
+			wantPower:  1800,
+			wantEnergy: 2480.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/status" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{ScrapeTimeout: 5 * time.Second}
+			status, err := New(server.URL, cfg, logrus.New()).GetStatus(context.Background())
+			if err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+
+			if !status.HasComponent(tt.component) {
+				t.Errorf("HasComponent(%q) = false, want true", tt.component)
+			}
+			if len(status.EnergyMeters) != len(tt.want) {
+				t.Fatalf("EnergyMeters = %+v, want %+v", status.EnergyMeters, tt.want)
+			}
+			for i, meter := range status.EnergyMeters {
+				if meter != tt.want[i] {
+					t.Errorf("EnergyMeters[%d] = %+v, want %+v", i, meter, tt.want[i])
+				}
+			}
+			if status.EM.TotalActPower != tt.wantPower {
+				t.Errorf("EM.TotalActPower = %v, want %v", status.EM.TotalActPower, tt.wantPower)
+			}
+			if status.EMData.TotalAct != tt.wantEnergy {
+				t.Errorf("EMData.TotalAct = %v, want %v", status.EMData.TotalAct, tt.wantEnergy)
+			}
+		})
+	}
+}
+
+func TestClient_GetStatus_LegacyTemperatureAndUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"mac": "AABBCCDDEEFF",
+			"temperature": 94.2,
+			"overtemperature": true,
+			"temperature_status": "Very High",
+			"update": {"status": "pending", "has_update": true, "new_version": "v1.14.1", "old_version": "v1.14.0"}
+		}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: 5 * time.Second}
+	status, err := New(server.URL, cfg, logrus.New()).GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+
+	if !status.Overtemperature {
+		t.Error("Overtemperature = false, want true")
+	}
+	if status.TemperatureStatus != "Very High" {
+		t.Errorf("TemperatureStatus = %q, want Very High", status.TemperatureStatus)
+	}
+	if status.FirmwareVersion != "v1.14.0" {
+		t.Errorf("FirmwareVersion = %q, want v1.14.0", status.FirmwareVersion)
+	}
+	if !status.HasUpdate || status.Sys.AvailableUpdates.Stable.Version != "v1.14.1" {
+		t.Errorf("HasUpdate = %v, Sys.AvailableUpdates.Stable.Version = %q, want true and v1.14.1", status.HasUpdate, status.Sys.AvailableUpdates.Stable.Version)
+	}
+	if status.Sys.AvailableUpdates.Beta.Version != "" {
+		t.Errorf("Sys.AvailableUpdates.Beta.Version = %q, want empty", status.Sys.AvailableUpdates.Beta.Version)
+	}
+}
+
+func TestClient_GetStatus_LegacyBeta(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+		stable string
+		beta   string
+	}{
+		{
+			name:   "beta newer than installed",
+			update: `{"status": "idle", "has_update": false, "new_version": "v1.14.0", "old_version": "v1.14.0", "beta_version": "v1.14.1-rc1"}`,
+			beta:   "v1.14.1-rc1",
+		},
+		{
+			name:   "beta installed",
+			update: `{"status": "idle", "has_update": false, "new_version": "v1.14.1-rc1", "old_version": "v1.14.1-rc1", "beta_version": "v1.14.1-rc1"}`,
+		},
+		{
+			name:   "pending update",
+			update: `{"status": "pending", "has_update": false, "new_version": "v1.14.1", "old_version": "v1.14.0"}`,
+			stable: "v1.14.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/status" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"mac": "AABBCCDDEEFF", "update": ` + tt.update + `}`))
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{ScrapeTimeout: 5 * time.Second}
+			status, err := New(server.URL, cfg, logrus.New()).GetStatus(context.Background())
+			if err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+
+			if got := status.Sys.AvailableUpdates.Stable.Version; got != tt.stable {
+				t.Errorf("Sys.AvailableUpdates.Stable.Version = %q, want %q", got, tt.stable)
+			}
+			if got := status.Sys.AvailableUpdates.Beta.Version; got != tt.beta {
+				t.Errorf("Sys.AvailableUpdates.Beta.Version = %q, want %q", got, tt.beta)
+			}
+		})
+	}
+}
+
+func TestClient_GetStatus_FirmwareVersion(t *testing.T) {
+	uptime := 100
+	deviceInfoCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == APIRPC:
+			_, _ = fmt.Fprintf(w, `{"sys": {"mac": "AABBCCDDEEFF", "uptime": %d, "available_updates": {"beta": {"version": "1.5.0-beta1"}}}}`, uptime)
+		case r.Method == http.MethodPost && r.URL.Path == "/rpc":
+			var req rpcRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "Shelly.GetDeviceInfo" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			deviceInfoCalls++
+			_, _ = fmt.Fprintf(w, `{"id": %d, "src": "shelly", "result": {"gen": 2, "ver": "1.4.%d"}}`, req.ID, deviceInfoCalls)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: 5 * time.Second}
+	client := New(server.URL, cfg, logrus.New())
+
+	steps := []struct {
+		uptime int
+		want   string
+		calls  int
+	}{
+		{uptime: 100, want: "1.4.1", calls: 1},
+		{uptime: 160, want: "1.4.1", calls: 1},
+		// The device rebooted and may have installed an update
+		{uptime: 5, want: "1.4.2", calls: 2},
+	}
+
+	for _, step := range steps {
+		uptime = step.uptime
+		status, err := client.GetStatus(context.Background())
+		if err != nil {
+			t.Fatalf("GetStatus() error = %v", err)
+		}
+		if status.FirmwareVersion != step.want {
+			t.Errorf("uptime %d: FirmwareVersion = %q, want %q", step.uptime, status.FirmwareVersion, step.want)
+		}
+		if deviceInfoCalls != step.calls {
+			t.Errorf("uptime %d: Shelly.GetDeviceInfo called %d times, want %d", step.uptime, deviceInfoCalls, step.calls)
+		}
+		if status.Sys.AvailableUpdates.Beta.Version != "1.5.0-beta1" {
+			t.Errorf("Sys.AvailableUpdates.Beta.Version = %q, want 1.5.0-beta1", status.Sys.AvailableUpdates.Beta.Version)
+		}
+	}
+}
+
+func TestStatusResponse_PowerMeters(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		relays          []bool
+		overpower       bool
+		overtemperature bool
+		meters          []EnergyMeter
+		power           float64
+		total           float64
+		temperature     float64
+	}{
+		{
+			name: "metered switches",
+			body: `{"sys":{},
+				"switch:1":{"id":1,"output":false,"apower":0,"aenergy":{"total":20.5}},
+				"switch:0":{"id":0,"output":true,"apower":12.4,"aenergy":{"total":31875.412}}}`,
+			relays: []bool{true, false},
+			meters: []EnergyMeter{{Power: 12.4, Total: 31875.412}, {Power: 0, Total: 20.5}},
+			power:  12.4,
+			total:  31895.912,
+		},
+		{
+			name: "switches with temperature",
+			body: `{"sys":{},
+				"switch:1":{"id":1,"output":false,"temperature":{"tC":45.0,"tF":113.0}},
+				"switch:0":{"id":0,"output":true,"temperature":{"tC":41.2,"tF":106.2}}}`,
+			relays:      []bool{true, false},
+			temperature: 41.2,
+		},
+		{
+			name:   "switch with failed temperature sensor",
+			body:   `{"sys":{},"switch:0":{"id":0,"output":true,"temperature":{"tC":null,"tF":null}}}`,
+			relays: []bool{true},
+		},
+		{
+			name:   "switch without metering",
+			body:   `{"sys":{},"switch:0":{"id":0,"output":true}}`,
+			relays: []bool{true},
+		},
+		{
+			name:      "overpowered switch",
+			body:      `{"sys":{},"switch:0":{"id":0,"output":false,"apower":0,"aenergy":{"total":3},"errors":["overpower"]}}`,
+			relays:    []bool{false},
+			overpower: true,
+			meters:    []EnergyMeter{{Power: 0, Total: 3}},
+			total:     3,
+		},
+		{
+			name:            "overheated switch",
+			body:            `{"sys":{},"switch:0":{"id":0,"output":false,"errors":["overtemp"]}}`,
+			relays:          []bool{false},
+			overtemperature: true,
+		},
+		{
+			name:   "power meter",
+			body:   `{"sys":{},"pm1:0":{"id":0,"apower":230,"aenergy":{"total":1500}}}`,
+			meters: []EnergyMeter{{Power: 230, Total: 1500}},
+			power:  230,
+			total:  1500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status StatusResponse
+			if err := json.Unmarshal([]byte(tt.body), &status); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			var relays []bool
+			for _, relay := range status.Relays {
+				relays = append(relays, relay.IsOn)
+			}
+			if !slices.Equal(relays, tt.relays) {
+				t.Errorf("Relays = %v, want %v", relays, tt.relays)
+			}
+			if len(status.Relays) > 0 && status.Relays[0].Overpower != tt.overpower {
+				t.Errorf("Relays[0].Overpower = %v, want %v", status.Relays[0].Overpower, tt.overpower)
+			}
+			if !slices.Equal(status.EnergyMeters, tt.meters) {
+				t.Errorf("EnergyMeters = %v, want %v", status.EnergyMeters, tt.meters)
+			}
+			if status.Overtemperature != tt.overtemperature {
+				t.Errorf("Overtemperature = %v, want %v", status.Overtemperature, tt.overtemperature)
+			}
+			if got := status.HasComponent(ComponentOvertemperature); got != (len(tt.relays) > 0) {
+				t.Errorf("HasComponent(%q) = %v, want %v", ComponentOvertemperature, got, len(tt.relays) > 0)
+			}
+			if got := status.HasComponent(ComponentPowerMeters); got != (len(tt.meters) > 0) {
+				t.Errorf("HasComponent(%q) = %v, want %v", ComponentPowerMeters, got, len(tt.meters) > 0)
+			}
+			if status.EM.TotalActPower != tt.power || status.EMData.TotalAct != tt.total {
+				t.Errorf("EM.TotalActPower, EMData.TotalAct = %v, %v, want %v, %v", status.EM.TotalActPower, status.EMData.TotalAct, tt.power, tt.total)
+			}
+			if got := status.HasComponent(ComponentTemperature); got != (tt.temperature != 0) {
+				t.Errorf("HasComponent(%q) = %v, want %v", ComponentTemperature, got, tt.temperature != 0)
+			}
+			if status.Temperature.TC != tt.temperature {
+				t.Errorf("Temperature.TC = %v, want %v", status.Temperature.TC, tt.temperature)
+			}
+		})
+	}
+}
+
+func TestStatusResponse_HasComponent(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "three-phase meter",
+			body:    `{"sys":{"mac":"AABBCCDDEEFF"},"em:0":{"total_act_power":100},"emdata:0":{"total_act":1},"temperature:0":{"tC":40}}`,
+			present: []string{ComponentEM, ComponentEMData, ComponentTemperature},
+			absent:  []string{ComponentMeters},
+		},
+		{
+			name:    "switch without meter",
+			body:    `{"sys":{"mac":"AABBCCDDEEFF"},"switch:0":{"output":true},"temperature:0":null}`,
+			present: nil,
+			absent:  []string{ComponentEM, ComponentEMData, ComponentTemperature, ComponentMeters},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status StatusResponse
+			if err := json.Unmarshal([]byte(tt.body), &status); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if status.Sys.Mac != "AABBCCDDEEFF" {
+				t.Errorf("Sys.Mac = %q, want AABBCCDDEEFF", status.Sys.Mac)
+			}
+			for _, component := range tt.present {
+				if !status.HasComponent(component) {
+					t.Errorf("HasComponent(%q) = false, want true", component)
+				}
+			}
+			for _, component := range tt.absent {
+				if status.HasComponent(component) {
+					t.Errorf("HasComponent(%q) = true, want false", component)
+				}
+			}
+		})
+	}
 }
 
 func TestClient_GetStatus_Error(t *testing.T) {
@@ -333,6 +763,56 @@ func TestClient_GetStatus_Error(t *testing.T) {
 	if err == nil {
 		t.Error("GetStatus() expected error, got nil")
 	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GetStatus() error = %v, want StatusError with status 500", err)
+	}
+	if api := client.API(); api != "" {
+		t.Errorf("API() = %q after failed GetStatus, want empty", api)
+	}
+}
+
+func TestClient_API(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "rpc",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(StatusResponse{})
+			},
+			want: APIRPC,
+		},
+		{
+			name: "legacy",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/status" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(LegacyStatusResponse{})
+			},
+			want: APILegacy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			client := New(server.URL, &config.Config{ScrapeTimeout: 10 * time.Second}, logrus.New())
+			if _, err := client.GetStatus(context.Background()); err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+			if api := client.API(); api != tt.want {
+				t.Errorf("API() = %q, want %q", api, tt.want)
+			}
+		})
+	}
 }
 
 func TestClient_GetMeters(t *testing.T) {
@@ -442,3 +922,80 @@ func TestClient_ContextCancellation(t *testing.T) {
 		t.Error("GetStatus() expected timeout error, got nil")
 	}
 }
+
+// staticStatusSource is a StatusSource that serves a fixed status for a single device
+type staticStatusSource struct {
+	mac    string
+	status *StatusResponse
+}
+
+func (s *staticStatusSource) Status(mac string) (*StatusResponse, bool) {
+	if mac != s.mac || s.status == nil {
+		return nil, false
+	}
+	return s.status, true
+}
+
+func TestClient_GetStatus_StatusSource(t *testing.T) {
+	// Status requests, the device info fetched along with the first status is not counted
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == APIRPC {
+			requests++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sys":{"mac":"AABBCCDDEEFF","uptime":100,"ram_free":1000},"wifi":{"ssid":"home","rssi":-60}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		ScrapeTimeout: 10 * time.Second,
+		TLS: config.TLSConfig{
+			Enabled: false,
+		},
+	}
+	logger := logrus.New()
+	client := New(server.URL, cfg, logger)
+
+	pushed := &StatusResponse{Relays: []Relay{{IsOn: true, IsValid: true}}}
+	pushed.EMData.TotalAct = 1000
+	pushed.SetComponent(ComponentMeters)
+	source := &staticStatusSource{mac: "AABBCCDDEEFF", status: pushed}
+	client.SetStatusSource(source)
+
+	// Device is polled until its MAC address is known
+	if _, err := client.GetStatus(context.Background()); err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("GetStatus() made %d requests, want 1", requests)
+	}
+
+	// Pushed status is used without polling and merged onto the polled one
+	status, err := client.GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("GetStatus() made %d requests, want 1", requests)
+	}
+	if client.API() != APIPush {
+		t.Errorf("API() = %q, want %q", client.API(), APIPush)
+	}
+	if len(status.Relays) != 1 || !status.Relays[0].IsOn || status.EMData.TotalAct != 1000 ||
+		!status.HasComponent(ComponentMeters) {
+		t.Errorf("GetStatus() = %+v, want pushed relays and meters", status)
+	}
+	if status.Sys.Uptime != 100 || status.Sys.RAMFree != 1000 || status.Wifi.SSID != "home" || status.Wifi.RSSI != -60 {
+		t.Errorf("GetStatus() = %+v, want polled system and WiFi values", status)
+	}
+
+	// Device is polled when no pushed status is available
+	source.status = nil
+	if _, err := client.GetStatus(context.Background()); err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("GetStatus() made %d requests, want 2", requests)
+	}
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rpcSource identifies the exporter as the origin of RPC requests
+const rpcSource = "shelly-exporter"
+
+// Shelly digest authentication constants
+const (
+	authAlgorithm = "SHA-256"
+	authQOP       = "auth"
+	authHA2Input  = "dummy_method:dummy_uri"
+)
+
+// ErrAuthRequired is returned when a device requires authentication and no valid credentials are configured
+var ErrAuthRequired = errors.New("device requires authentication")
+
+// RPCError is the error object of a JSON-RPC 2.0 response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCCall is a single call of a batch
+type RPCCall struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Err    error
+}
+
+// rpcRequest is a JSON-RPC 2.0 request frame
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Src     string      `json:"src"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	Auth    *rpcAuth    `json:"auth,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response frame
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Src    string          `json:"src"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// rpcAuth is the authentication object sent with requests to protected devices
+type rpcAuth struct {
+	Realm     string `json:"realm"`
+	Username  string `json:"username"`
+	Nonce     uint64 `json:"nonce"`
+	CNonce    string `json:"cnonce"`
+	Response  string `json:"response"`
+	Algorithm string `json:"algorithm"`
+}
+
+// authChallenge holds the digest challenge issued by a device. Shelly devices issue numeric
+// nonces and expect them back as numbers.
+type authChallenge struct {
+	Realm string
+	Nonce uint64
+	NC    int
+}
+
+// rpcState holds the per-client JSON-RPC state
+type rpcState struct {
+	mu        sync.Mutex
+	nextID    uint64
+	challenge *authChallenge
+}
+
+// Call invokes an RPC method on the device using a JSON-RPC 2.0 POST to /rpc.
+// The result, if non-nil, is decoded from the result member of the response.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	raw, err := c.call(ctx, method, params)
+	if err != nil {
+		return err
+	}
+
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+
+	return nil
+}
+
+// Batch invokes several RPC methods in order, storing each result and error on its call.
+// Shelly devices accept a single request per POST, so the calls share the connection and
+// authentication state rather than a JSON-RPC batch array. The first error is returned.
+func (c *Client) Batch(ctx context.Context, calls ...*RPCCall) error {
+	var firstErr error
+	for _, call := range calls {
+		call.Err = c.Call(ctx, call.Method, call.Params, call.Result)
+		if call.Err != nil && firstErr == nil {
+			firstErr = call.Err
+		}
+	}
+	return firstErr
+}
+
+// call performs a single RPC, answering an authentication challenge once if needed
+func (c *Client) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	resp, challenge, err := c.doRPC(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge != nil {
+		if c.password == "" {
+			return nil, ErrAuthRequired
+		}
+
+		c.rpc.mu.Lock()
+		c.rpc.challenge = challenge
+		c.rpc.mu.Unlock()
+
+		resp, challenge, err = c.doRPC(ctx, method, params)
+		if err != nil {
+			return nil, err
+		}
+		if challenge != nil {
+			return nil, fmt.Errorf("%w: invalid credentials", ErrAuthRequired)
+		}
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	return resp.Result, nil
+}
+
+// doRPC sends a request frame and decodes the response.
+// A non-nil challenge is returned when the device rejected the request with 401.
+func (c *Client) doRPC(ctx context.Context, method string, params interface{}) (*rpcResponse, *authChallenge, error) {
+	c.rpc.mu.Lock()
+	c.rpc.nextID++
+	request := rpcRequest{
+		JSONRPC: "2.0",
+		ID:      c.rpc.nextID,
+		Src:     rpcSource,
+		Method:  method,
+		Params:  params,
+	}
+	if c.rpc.challenge != nil {
+		request.Auth = c.authenticate(c.rpc.challenge)
+	}
+	c.rpc.mu.Unlock()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/rpc", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf(ErrMsgCreateRequest, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf(ErrMsgExecuteRequest, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.logger.Warnf("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge, err := parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+
+	var rpcResp rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+		return nil, nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	if rpcResp.Error != nil && rpcResp.Error.Code == http.StatusUnauthorized {
+		challenge, err := parseErrorChallenge(rpcResp.Error.Message)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+
+	if rpcResp.Error == nil && rpcResp.ID != request.ID {
+		return nil, nil, fmt.Errorf("response id %d does not match request id %d", rpcResp.ID, request.ID)
+	}
+
+	return &rpcResp, nil, nil
+}
+
+// authenticate builds the auth object answering the given challenge
+func (c *Client) authenticate(challenge *authChallenge) *rpcAuth {
+	cnonce := make([]byte, 8)
+	if _, err := rand.Read(cnonce); err != nil {
+		c.logger.Warnf("Failed to generate cnonce: %v", err)
+	}
+	cnonceHex := hex.EncodeToString(cnonce)
+
+	nc := challenge.NC
+	if nc == 0 {
+		nc = 1
+	}
+
+	ha1 := sha256Hex(c.username + ":" + challenge.Realm + ":" + c.password)
+	ha2 := sha256Hex(authHA2Input)
+	response := sha256Hex(strings.Join([]string{
+		ha1, strconv.FormatUint(challenge.Nonce, 10), strconv.Itoa(nc), cnonceHex, authQOP, ha2,
+	}, ":"))
+
+	return &rpcAuth{
+		Realm:     challenge.Realm,
+		Username:  c.username,
+		Nonce:     challenge.Nonce,
+		CNonce:    cnonceHex,
+		Response:  response,
+		Algorithm: authAlgorithm,
+	}
+}
+
+// parseDigestChallenge parses a WWW-Authenticate digest header
+func parseDigestChallenge(header string) (*authChallenge, error) {
+	params, ok := strings.CutPrefix(header, "Digest ")
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported challenge %q", ErrAuthRequired, header)
+	}
+
+	challenge := &authChallenge{NC: 1}
+	for _, part := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "realm":
+			challenge.Realm = value
+		case "nonce":
+			nonce, err := parseNonce(value)
+			if err != nil {
+				return nil, err
+			}
+			challenge.Nonce = nonce
+		case "algorithm":
+			if value != authAlgorithm {
+				return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrAuthRequired, value)
+			}
+		}
+	}
+
+	if challenge.Realm == "" || challenge.Nonce == 0 {
+		return nil, fmt.Errorf("%w: incomplete challenge %q", ErrAuthRequired, header)
+	}
+
+	return challenge, nil
+}
+
+// parseErrorChallenge parses the challenge embedded in a 401 RPC error message
+func parseErrorChallenge(message string) (*authChallenge, error) {
+	var payload struct {
+		AuthType  string          `json:"auth_type"`
+		Nonce     json.RawMessage `json:"nonce"`
+		NC        int             `json:"nc"`
+		Realm     string          `json:"realm"`
+		Algorithm string          `json:"algorithm"`
+	}
+	if err := json.Unmarshal([]byte(message), &payload); err != nil {
+		return nil, fmt.Errorf("%w: invalid challenge: %v", ErrAuthRequired, err)
+	}
+
+	if payload.Algorithm != "" && payload.Algorithm != authAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrAuthRequired, payload.Algorithm)
+	}
+
+	if payload.Realm == "" || len(payload.Nonce) == 0 {
+		return nil, fmt.Errorf("%w: incomplete challenge", ErrAuthRequired)
+	}
+
+	// The nonce is a number, older firmware sends it as a string
+	nonce, err := parseNonce(strings.Trim(string(payload.Nonce), `"`))
+	if err != nil {
+		return nil, err
+	}
+
+	return &authChallenge{Realm: payload.Realm, Nonce: nonce, NC: payload.NC}, nil
+}
+
+// parseNonce parses the numeric nonce of a challenge
+func parseNonce(value string) (uint64, error) {
+	nonce, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || nonce == 0 {
+		return 0, fmt.Errorf("%w: invalid nonce %q", ErrAuthRequired, value)
+	}
+	return nonce, nil
+}
+
+// sha256Hex returns the hex encoded SHA-256 digest of s
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
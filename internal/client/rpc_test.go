@@ -0,0 +1,473 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testRealm    = "shellypro3em-aabbccddeeff"
+	testNonce    = "1625038762"
+	testPassword = "secret"
+)
+
+// rpcHandler serves JSON-RPC requests, checking authentication when a challenge mode is set
+type rpcHandler struct {
+	t         *testing.T
+	challenge string // "", "header" or "error"
+	results   map[string]interface{}
+	requests  []rpcRequest
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/rpc" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.t.Errorf("Failed to decode request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.requests = append(h.requests, req)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if h.challenge != "" && !h.validAuth(req.Auth) {
+		switch h.challenge {
+		case "header":
+			w.Header().Set("WWW-Authenticate", `Digest qop="auth", realm="`+testRealm+`", nonce="`+testNonce+`", algorithm=SHA-256`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "error":
+			message := `{"auth_type": "digest", "nonce": ` + testNonce + `, "nc": 1, "realm": "` + testRealm + `", "algorithm": "SHA-256"}`
+			h.write(w, map[string]interface{}{
+				"id":    req.ID,
+				"src":   "shellypro3em-aabbccddeeff",
+				"error": map[string]interface{}{"code": 401, "message": message},
+			})
+		}
+		return
+	}
+
+	result, ok := h.results[req.Method]
+	if !ok {
+		h.write(w, map[string]interface{}{
+			"id":    req.ID,
+			"src":   "shellypro3em-aabbccddeeff",
+			"error": map[string]interface{}{"code": 404, "message": "No handler for " + req.Method},
+		})
+		return
+	}
+
+	h.write(w, map[string]interface{}{
+		"id":     req.ID,
+		"src":    "shellypro3em-aabbccddeeff",
+		"result": result,
+	})
+}
+
+func (h *rpcHandler) validAuth(auth *rpcAuth) bool {
+	if auth == nil || auth.Realm != testRealm || strconv.FormatUint(auth.Nonce, 10) != testNonce || auth.Algorithm != "SHA-256" {
+		return false
+	}
+	ha1 := sha256Hex("admin:" + testRealm + ":" + testPassword)
+	ha2 := sha256Hex("dummy_method:dummy_uri")
+	expected := sha256Hex(strings.Join([]string{ha1, testNonce, "1", auth.CNonce, "auth", ha2}, ":"))
+	return auth.Response == expected
+}
+
+func (h *rpcHandler) write(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.t.Errorf("Failed to encode response: %v", err)
+	}
+}
+
+func newRPCTestClient(url, password string) *Client {
+	cfg := &config.Config{
+		ScrapeTimeout: 10 * time.Second,
+		Auth: config.AuthConfig{
+			Username: "admin",
+			Password: config.Secret(password),
+		},
+	}
+	return New(url, cfg, logrus.New())
+}
+
+func TestClient_Call(t *testing.T) {
+	handler := &rpcHandler{
+		t: t,
+		results: map[string]interface{}{
+			"Shelly.GetDeviceInfo": map[string]interface{}{
+				"id":    "shellypro3em-aabbccddeeff",
+				"mac":   "AABBCCDDEEFF",
+				"model": "SPEM-003CEBEU",
+				"gen":   2,
+				"ver":   "1.0.0",
+			},
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, "")
+
+	info, err := client.GetDeviceInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+	if info.Model != "SPEM-003CEBEU" || info.Gen != 2 || info.Version != "1.0.0" {
+		t.Errorf("GetDeviceInfo() = %+v", info)
+	}
+
+	if len(handler.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(handler.requests))
+	}
+	req := handler.requests[0]
+	if req.JSONRPC != "2.0" || req.Method != "Shelly.GetDeviceInfo" || req.ID == 0 || req.Src != rpcSource {
+		t.Errorf("request = %+v", req)
+	}
+	if req.Auth != nil {
+		t.Error("request contains auth without challenge")
+	}
+}
+
+func TestClient_Call_RequestIDs(t *testing.T) {
+	handler := &rpcHandler{t: t, results: map[string]interface{}{"Sys.GetConfig": map[string]interface{}{}}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, "")
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetSysConfig(context.Background()); err != nil {
+			t.Fatalf("GetSysConfig() error = %v", err)
+		}
+	}
+
+	seen := make(map[uint64]bool)
+	for _, req := range handler.requests {
+		if seen[req.ID] {
+			t.Errorf("request id %d reused", req.ID)
+		}
+		seen[req.ID] = true
+	}
+}
+
+func TestClient_Call_RPCError(t *testing.T) {
+	server := httptest.NewServer(&rpcHandler{t: t})
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, "")
+
+	err := client.Call(context.Background(), "EM.GetStatus", map[string]int{"id": 0}, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("Call() error = %v, want RPCError", err)
+	}
+	if rpcErr.Code != 404 || rpcErr.Message != "No handler for EM.GetStatus" {
+		t.Errorf("RPCError = %+v", rpcErr)
+	}
+}
+
+func TestClient_Call_Auth(t *testing.T) {
+	for _, mode := range []string{"header", "error"} {
+		t.Run(mode, func(t *testing.T) {
+			handler := &rpcHandler{
+				t:         t,
+				challenge: mode,
+				results: map[string]interface{}{
+					"EMData.GetStatus": map[string]interface{}{"id": 0, "total_act": 1234.5},
+				},
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			client := newRPCTestClient(server.URL, testPassword)
+
+			status, err := client.GetEMDataStatus(context.Background(), 0)
+			if err != nil {
+				t.Fatalf("GetEMDataStatus() error = %v", err)
+			}
+			if status.TotalAct != 1234.5 {
+				t.Errorf("GetEMDataStatus() TotalAct = %v, want 1234.5", status.TotalAct)
+			}
+			if len(handler.requests) != 2 {
+				t.Errorf("requests = %d, want 2", len(handler.requests))
+			}
+
+			// The challenge is reused for subsequent calls
+			if _, err := client.GetEMDataStatus(context.Background(), 0); err != nil {
+				t.Fatalf("GetEMDataStatus() error = %v", err)
+			}
+			if len(handler.requests) != 3 {
+				t.Errorf("requests = %d, want 3", len(handler.requests))
+			}
+		})
+	}
+}
+
+func TestClient_Call_AuthRequired(t *testing.T) {
+	server := httptest.NewServer(&rpcHandler{t: t, challenge: "header"})
+	defer server.Close()
+
+	// No password configured
+	client := newRPCTestClient(server.URL, "")
+	if err := client.Call(context.Background(), "Shelly.GetStatus", nil, nil); !errors.Is(err, ErrAuthRequired) {
+		t.Errorf("Call() error = %v, want ErrAuthRequired", err)
+	}
+
+	// Wrong password
+	client = newRPCTestClient(server.URL, "wrong")
+	if err := client.Call(context.Background(), "Shelly.GetStatus", nil, nil); !errors.Is(err, ErrAuthRequired) {
+		t.Errorf("Call() error = %v, want ErrAuthRequired", err)
+	}
+}
+
+func TestClient_Batch(t *testing.T) {
+	handler := &rpcHandler{
+		t: t,
+		results: map[string]interface{}{
+			"Shelly.GetDeviceInfo": map[string]interface{}{"model": "SPEM-003CEBEU"},
+			"Sys.GetConfig":        map[string]interface{}{"device": map[string]interface{}{"name": "main"}},
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, "")
+
+	var info DeviceInfo
+	var sysConfig SysConfig
+	calls := []*RPCCall{
+		{Method: "Shelly.GetDeviceInfo", Result: &info},
+		{Method: "Sys.GetConfig", Result: &sysConfig},
+		{Method: "EMData.GetStatus", Params: map[string]int{"id": 0}, Result: &EMDataStatus{}},
+	}
+
+	err := client.Batch(context.Background(), calls...)
+	if err == nil {
+		t.Fatal("Batch() expected error for unknown method, got nil")
+	}
+
+	if calls[0].Err != nil || info.Model != "SPEM-003CEBEU" {
+		t.Errorf("call 0 = %+v, info = %+v", calls[0], info)
+	}
+	if calls[1].Err != nil || sysConfig.Device.Name != "main" {
+		t.Errorf("call 1 = %+v, sysConfig = %+v", calls[1], sysConfig)
+	}
+	if calls[2].Err == nil {
+		t.Error("call 2 expected error, got nil")
+	}
+}
+
+func TestClient_GetStatus_Auth(t *testing.T) {
+	handler := &rpcHandler{
+		t:         t,
+		challenge: "header",
+		results: map[string]interface{}{
+			"Shelly.GetStatus": map[string]interface{}{
+				"sys": map[string]interface{}{"mac": "AABBCCDDEEFF", "uptime": 100},
+			},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rpc/Shelly.GetStatus" {
+			w.Header().Set("WWW-Authenticate", `Digest qop="auth", realm="`+testRealm+`", nonce="`+testNonce+`", algorithm=SHA-256`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, testPassword)
+
+	status, err := client.GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if status.Sys.Mac != "AABBCCDDEEFF" || status.Sys.Uptime != 100 {
+		t.Errorf("GetStatus() Sys = %+v", status.Sys)
+	}
+	if api := client.API(); api != APIRPCAuth {
+		t.Errorf("API() = %q, want %q", api, APIRPCAuth)
+	}
+}
+
+func TestClient_GetStatus_LegacyBasicAuth(t *testing.T) {
+	// A protected Gen1 device challenges every request for basic authentication
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != testPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="shelly1pm-AABBCC"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mac": "AABBCCDDEEFF", "uptime": 42}`))
+	}))
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, testPassword)
+
+	status, err := client.GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if status.Sys.Mac != "AABBCCDDEEFF" || status.Sys.Uptime != 42 {
+		t.Errorf("GetStatus() Sys = %+v", status.Sys)
+	}
+	if api := client.API(); api != APILegacy {
+		t.Errorf("API() = %q, want %q", api, APILegacy)
+	}
+
+	var statusErr *StatusError
+	if _, err := newRPCTestClient(server.URL, "wrong").GetStatus(context.Background()); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GetStatus() with wrong password error = %v, want status code 401", err)
+	}
+}
+
+func TestClient_GetRawStatus(t *testing.T) {
+	handler := &rpcHandler{
+		t: t,
+		results: map[string]interface{}{
+			"Shelly.GetStatus": map[string]interface{}{"sys": map[string]interface{}{"mac": "AABBCCDDEEFF"}},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", handler)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mac": "112233445566", "uptime": 42}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := newRPCTestClient(server.URL, "")
+
+	raw, err := client.GetRawStatus(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("GetRawStatus(2) error = %v", err)
+	}
+	if !strings.Contains(string(raw), "AABBCCDDEEFF") {
+		t.Errorf("GetRawStatus(2) = %s, want Shelly.GetStatus result", raw)
+	}
+
+	raw, err = client.GetRawStatus(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetRawStatus(1) error = %v", err)
+	}
+	if !strings.Contains(string(raw), "112233445566") {
+		t.Errorf("GetRawStatus(1) = %s, want /status document", raw)
+	}
+}
+
+func TestClient_GetRaw(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		switch {
+		case r.URL.Path != "/settings":
+			w.WriteHeader(http.StatusNotFound)
+		case username != "admin" || password != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"name": "Kitchen"}`))
+		}
+	}))
+	defer server.Close()
+
+	raw, err := newRPCTestClient(server.URL, "secret").GetRaw(context.Background(), "/settings")
+	if err != nil {
+		t.Fatalf("GetRaw() error = %v", err)
+	}
+	if !strings.Contains(string(raw), "Kitchen") {
+		t.Errorf("GetRaw() = %s, want /settings document", raw)
+	}
+
+	if _, err := newRPCTestClient(server.URL, "").GetRaw(context.Background(), "/settings"); !errors.Is(err, ErrAuthRequired) {
+		t.Errorf("GetRaw() without password error = %v, want ErrAuthRequired", err)
+	}
+	if _, err := newRPCTestClient(server.URL, "secret").GetRaw(context.Background(), "/missing"); err == nil {
+		t.Error("GetRaw(/missing) expected error, got nil")
+	}
+}
+
+func TestParseErrorChallenge(t *testing.T) {
+	// Current firmware sends the nonce as a number, older firmware as a string
+	for _, nonce := range []string{`1625038762`, `"1625038762"`} {
+		message := `{"auth_type": "digest", "nonce": ` + nonce + `, "nc": 2, "realm": "shelly", "algorithm": "SHA-256"}`
+		challenge, err := parseErrorChallenge(message)
+		if err != nil {
+			t.Fatalf("parseErrorChallenge(%s) error = %v", message, err)
+		}
+		if challenge.Realm != "shelly" || challenge.Nonce != 1625038762 || challenge.NC != 2 {
+			t.Errorf("parseErrorChallenge(%s) = %+v", message, challenge)
+		}
+	}
+
+	for _, message := range []string{`{"realm": "shelly"}`, `{"realm": "shelly", "nonce": "abc"}`, `{"realm": "shelly", "nonce": 1, "algorithm": "MD5"}`} {
+		if _, err := parseErrorChallenge(message); !errors.Is(err, ErrAuthRequired) {
+			t.Errorf("parseErrorChallenge(%s) error = %v, want ErrAuthRequired", message, err)
+		}
+	}
+}
+
+func TestClient_Call_NumericNonce(t *testing.T) {
+	var bodies []string
+	handler := &rpcHandler{
+		t:         t,
+		challenge: "header",
+		results:   map[string]interface{}{"Shelly.GetDeviceInfo": map[string]interface{}{"gen": 2}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read request: %v", err)
+			return
+		}
+		bodies = append(bodies, string(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	if _, err := newRPCTestClient(server.URL, testPassword).GetDeviceInfo(context.Background()); err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[1], `"nonce":`+testNonce+`,`) {
+		t.Errorf("authenticated request = %v, want the nonce %s as a number", bodies, testNonce)
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	challenge, err := parseDigestChallenge(`Digest qop="auth", realm="shelly", nonce="1625038762", algorithm=SHA-256`)
+	if err != nil {
+		t.Fatalf("parseDigestChallenge() error = %v", err)
+	}
+	if challenge.Realm != "shelly" || challenge.Nonce != 1625038762 || challenge.NC != 1 {
+		t.Errorf("parseDigestChallenge() = %+v", challenge)
+	}
+
+	for _, header := range []string{"", `Basic realm="shelly"`, `Digest realm="shelly"`, `Digest realm="shelly", nonce="abc"`, `Digest realm="shelly", nonce="1625038762", algorithm=MD5`} {
+		if _, err := parseDigestChallenge(header); !errors.Is(err, ErrAuthRequired) {
+			t.Errorf("parseDigestChallenge(%q) error = %v, want ErrAuthRequired", header, err)
+		}
+	}
+}
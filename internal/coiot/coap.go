@@ -0,0 +1,213 @@
+package coiot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CoAP message types
+const (
+	typeConfirmable    = 0
+	typeNonConfirmable = 1
+)
+
+// CoAP method and option numbers used by CoIoT
+const (
+	codeGet = 1
+
+	optionURIPath = 11
+
+	// Shelly specific options
+	optionDeviceID = 3332
+	optionValidity = 3412
+)
+
+const payloadMarker = 0xFF
+
+var errShortMessage = errors.New("coap: message too short")
+
+// option is a single CoAP option
+type option struct {
+	Number uint16
+	Value  []byte
+}
+
+// message is a minimal CoAP (RFC 7252) message
+type message struct {
+	Type      uint8
+	Code      uint8
+	MessageID uint16
+	Token     []byte
+	Options   []option
+	Payload   []byte
+}
+
+// parseMessage decodes a CoAP message from its wire format
+func parseMessage(data []byte) (*message, error) {
+	if len(data) < 4 {
+		return nil, errShortMessage
+	}
+
+	if version := data[0] >> 6; version != 1 {
+		return nil, fmt.Errorf("coap: unsupported version %d", version)
+	}
+
+	msg := &message{
+		Type:      (data[0] >> 4) & 0x03,
+		Code:      data[1],
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	tokenLen := int(data[0] & 0x0F)
+	if tokenLen > 8 {
+		return nil, fmt.Errorf("coap: invalid token length %d", tokenLen)
+	}
+	if len(data) < 4+tokenLen {
+		return nil, errShortMessage
+	}
+	msg.Token = data[4 : 4+tokenLen]
+
+	rest := data[4+tokenLen:]
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			msg.Payload = rest[1:]
+			break
+		}
+
+		delta := int(rest[0] >> 4)
+		length := int(rest[0] & 0x0F)
+		rest = rest[1:]
+
+		var err error
+		if delta, rest, err = readExtended(delta, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = readExtended(length, rest); err != nil {
+			return nil, err
+		}
+		if len(rest) < length {
+			return nil, errShortMessage
+		}
+
+		number += delta
+		msg.Options = append(msg.Options, option{Number: uint16(number), Value: rest[:length]})
+		rest = rest[length:]
+	}
+
+	return msg, nil
+}
+
+// readExtended resolves an extended option delta or length field
+func readExtended(value int, rest []byte) (int, []byte, error) {
+	switch value {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, errShortMessage
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, errShortMessage
+		}
+		return int(binary.BigEndian.Uint16(rest[:2])) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, errors.New("coap: reserved option nibble")
+	default:
+		return value, rest, nil
+	}
+}
+
+// marshal encodes the message into its wire format
+func (m *message) marshal() []byte {
+	buf := []byte{
+		1<<6 | (m.Type&0x03)<<4 | uint8(len(m.Token)),
+		m.Code,
+		0, 0,
+	}
+	binary.BigEndian.PutUint16(buf[2:4], m.MessageID)
+	buf = append(buf, m.Token...)
+
+	options := append([]option(nil), m.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Number < options[j].Number })
+
+	previous := 0
+	for _, opt := range options {
+		delta, deltaExt := splitExtended(int(opt.Number) - previous)
+		length, lengthExt := splitExtended(len(opt.Value))
+		buf = append(buf, byte(delta<<4|length))
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, opt.Value...)
+		previous = int(opt.Number)
+	}
+
+	if len(m.Payload) > 0 {
+		buf = append(buf, payloadMarker)
+		buf = append(buf, m.Payload...)
+	}
+
+	return buf
+}
+
+// splitExtended returns the nibble and extended bytes for an option delta or length
+func splitExtended(value int) (int, []byte) {
+	switch {
+	case value < 13:
+		return value, nil
+	case value < 269:
+		return 13, []byte{byte(value - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(value-269))
+		return 14, ext
+	}
+}
+
+// path returns the request path built from the Uri-Path options
+func (m *message) path() string {
+	var segments []string
+	for _, opt := range m.Options {
+		if opt.Number == optionURIPath {
+			segments = append(segments, string(opt.Value))
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// option returns the value of the first option with the given number
+func (m *message) option(number uint16) ([]byte, bool) {
+	for _, opt := range m.Options {
+		if opt.Number == number {
+			return opt.Value, true
+		}
+	}
+	return nil, false
+}
+
+// uintOption returns the value of a uint option
+func (m *message) uintOption(number uint16) (uint32, bool) {
+	value, ok := m.option(number)
+	if !ok || len(value) > 4 {
+		return 0, false
+	}
+
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	return v, true
+}
+
+// uintValue encodes a uint option value using the minimal number of bytes
+func uintValue(v uint32) []byte {
+	var buf []byte
+	for v > 0 {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+	}
+	return buf
+}
@@ -0,0 +1,96 @@
+package coiot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMessage_MarshalParse(t *testing.T) {
+	original := &message{
+		Type:      typeNonConfirmable,
+		Code:      30,
+		MessageID: 1234,
+		Token:     []byte{0x01, 0x02},
+		Options: []option{
+			{Number: optionURIPath, Value: []byte("cit")},
+			{Number: optionURIPath, Value: []byte("s")},
+			{Number: optionDeviceID, Value: []byte("SHSW-PM#A4CF12F45678#2")},
+			{Number: optionValidity, Value: uintValue(380)},
+		},
+		Payload: []byte(`{"G":[]}`),
+	}
+
+	parsed, err := parseMessage(original.marshal())
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+
+	if parsed.Type != original.Type {
+		t.Errorf("Type = %v, want %v", parsed.Type, original.Type)
+	}
+	if parsed.Code != original.Code {
+		t.Errorf("Code = %v, want %v", parsed.Code, original.Code)
+	}
+	if parsed.MessageID != original.MessageID {
+		t.Errorf("MessageID = %v, want %v", parsed.MessageID, original.MessageID)
+	}
+	if !bytes.Equal(parsed.Token, original.Token) {
+		t.Errorf("Token = %v, want %v", parsed.Token, original.Token)
+	}
+	if parsed.path() != "/cit/s" {
+		t.Errorf("path() = %v, want /cit/s", parsed.path())
+	}
+	if id, ok := parsed.option(optionDeviceID); !ok || string(id) != "SHSW-PM#A4CF12F45678#2" {
+		t.Errorf("option(optionDeviceID) = %q, %v", id, ok)
+	}
+	if validity, ok := parsed.uintOption(optionValidity); !ok || validity != 380 {
+		t.Errorf("uintOption(optionValidity) = %v, %v, want 380, true", validity, ok)
+	}
+	if string(parsed.Payload) != `{"G":[]}` {
+		t.Errorf("Payload = %s, want {\"G\":[]}", parsed.Payload)
+	}
+}
+
+func TestMessage_LongOptionValue(t *testing.T) {
+	value := strings.Repeat("x", 300)
+	original := &message{
+		Type:    typeConfirmable,
+		Code:    codeGet,
+		Options: []option{{Number: optionDeviceID, Value: []byte(value)}},
+	}
+
+	parsed, err := parseMessage(original.marshal())
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+
+	if got, ok := parsed.option(optionDeviceID); !ok || string(got) != value {
+		t.Errorf("option(optionDeviceID) length = %d, want %d", len(got), len(value))
+	}
+	if len(parsed.Payload) != 0 {
+		t.Errorf("Payload = %q, want empty", parsed.Payload)
+	}
+}
+
+func TestParseMessage_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "short header", data: []byte{0x50, 0x1E}},
+		{name: "wrong version", data: []byte{0x90, 0x1E, 0x00, 0x01}},
+		{name: "truncated token", data: []byte{0x54, 0x1E, 0x00, 0x01, 0x01}},
+		{name: "truncated option", data: []byte{0x50, 0x1E, 0x00, 0x01, 0xB5, 'c'}},
+		{name: "reserved nibble", data: []byte{0x50, 0x1E, 0x00, 0x01, 0xF0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMessage(tt.data); err == nil {
+				t.Errorf("parseMessage() expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package coiot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/sirupsen/logrus"
+)
+
+// statusPath is the path of the status updates published by Gen1 devices
+const statusPath = "/cit/s"
+
+// descriptionRetry limits how often a description is fetched for a single device
+const descriptionRetry = time.Minute
+
+// Description is the decoded /cit/d payload of a device
+type Description struct {
+	Blocks  []Block  `json:"blk"`
+	Sensors []Sensor `json:"sen"`
+}
+
+// Block is a logical unit of a device, such as a relay or the device itself
+type Block struct {
+	ID          int    `json:"I"`
+	Description string `json:"D"`
+}
+
+// Sensor describes a single value reported in /cit/s
+type Sensor struct {
+	ID          int             `json:"I"`
+	Type        string          `json:"T"`
+	Description string          `json:"D"`
+	Unit        string          `json:"U"`
+	Block       json.RawMessage `json:"L"`
+}
+
+// blocks returns the block ids the sensor belongs to
+func (s Sensor) blocks() []int {
+	var single int
+	if err := json.Unmarshal(s.Block, &single); err == nil {
+		return []int{single}
+	}
+
+	var multiple []int
+	if err := json.Unmarshal(s.Block, &multiple); err == nil {
+		return multiple
+	}
+
+	return nil
+}
+
+// statusPayload is the decoded /cit/s payload of a device
+type statusPayload struct {
+	Generic [][]json.RawMessage `json:"G"`
+}
+
+// deviceState holds the latest data received from a single device
+type deviceState struct {
+	mac         string
+	values      map[int]float64
+	receivedAt  time.Time
+	validity    time.Duration
+	description *Description
+	fetching    bool
+	lastFetch   time.Time
+}
+
+// DescriptionFetcher retrieves the /cit/d description of the device at the given address
+type DescriptionFetcher func(ctx context.Context, ip string) (*Description, error)
+
+// Listener receives CoIoT status updates broadcast by Gen1 Shelly devices
+type Listener struct {
+	config  *config.Config
+	logger  *logrus.Logger
+	fetch   DescriptionFetcher
+	now     func() time.Time
+	devices map[string]*deviceState
+
+	mu sync.RWMutex
+}
+
+// NewListener creates a new CoIoT listener
+func NewListener(cfg *config.Config, logger *logrus.Logger) *Listener {
+	l := &Listener{
+		config:  cfg,
+		logger:  logger,
+		now:     time.Now,
+		devices: make(map[string]*deviceState),
+	}
+	l.fetch = l.fetchDescription
+	return l
+}
+
+// Run joins the CoIoT multicast group and processes updates until the context is cancelled
+func (l *Listener) Run(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp4", l.config.CoIoT.MulticastAddress)
+	if err != nil {
+		return fmt.Errorf("invalid multicast address: %w", err)
+	}
+
+	var iface *net.Interface
+	if l.config.CoIoT.Interface != "" {
+		iface, err = net.InterfaceByName(l.config.CoIoT.Interface)
+		if err != nil {
+			return fmt.Errorf("failed to find interface %q: %w", l.config.CoIoT.Interface, err)
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", iface, addr)
+	if err != nil {
+		return fmt.Errorf("failed to join multicast group: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := conn.Close(); err != nil {
+			l.logger.Warnf("Failed to close CoIoT connection: %v", err)
+		}
+	}()
+
+	l.logger.WithField("address", addr.String()).Info("Listening for CoIoT updates")
+
+	buf := make([]byte, 4096)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read CoIoT packet: %w", err)
+		}
+
+		if err := l.HandlePacket(ctx, src.IP.String(), buf[:n]); err != nil {
+			l.logger.WithError(err).WithField("source", src.String()).Debug("Ignoring CoIoT packet")
+		}
+	}
+}
+
+// HandlePacket processes a single CoIoT packet received from the given address
+func (l *Listener) HandlePacket(ctx context.Context, ip string, data []byte) error {
+	msg, err := parseMessage(data)
+	if err != nil {
+		return err
+	}
+
+	if path := msg.path(); path != statusPath {
+		return fmt.Errorf("unexpected path %q", path)
+	}
+
+	var payload statusPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to decode status payload: %w", err)
+	}
+
+	values := make(map[int]float64, len(payload.Generic))
+	for _, entry := range payload.Generic {
+		if len(entry) != 3 {
+			continue
+		}
+		var id int
+		var value float64
+		if err := json.Unmarshal(entry[1], &id); err != nil {
+			continue
+		}
+		if err := json.Unmarshal(entry[2], &value); err != nil {
+			continue
+		}
+		values[id] = value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, ok := l.devices[ip]
+	if !ok {
+		state = &deviceState{}
+		l.devices[ip] = state
+	}
+
+	if id, ok := msg.option(optionDeviceID); ok {
+		// The device id has the form "SHSW-PM#A4CF12F45678#2"
+		if parts := strings.Split(string(id), "#"); len(parts) > 1 {
+			state.mac = discovery.FormatMAC(parts[1])
+		}
+	}
+
+	state.values = values
+	state.receivedAt = l.now()
+	state.validity = l.config.CoIoT.Validity
+	if validity, ok := msg.uintOption(optionValidity); ok && validity > 0 {
+		state.validity = decodeValidity(validity)
+	}
+
+	if l.needsDescription(state) {
+		state.fetching = true
+		state.lastFetch = l.now()
+		go l.updateDescription(ctx, ip)
+	}
+
+	return nil
+}
+
+// needsDescription reports whether the description of a device should be (re)fetched
+func (l *Listener) needsDescription(state *deviceState) bool {
+	if state.fetching || (!state.lastFetch.IsZero() && l.now().Sub(state.lastFetch) < descriptionRetry) {
+		return false
+	}
+
+	if state.description == nil {
+		return true
+	}
+
+	known := make(map[int]bool, len(state.description.Sensors))
+	for _, sensor := range state.description.Sensors {
+		known[sensor.ID] = true
+	}
+	for id := range state.values {
+		if !known[id] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateDescription fetches and stores the description of the device at ip
+func (l *Listener) updateDescription(ctx context.Context, ip string) {
+	ctx, cancel := context.WithTimeout(ctx, l.config.ScrapeTimeout)
+	defer cancel()
+
+	desc, err := l.fetch(ctx, ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.devices[ip]
+	state.fetching = false
+	if err != nil {
+		l.logger.WithError(err).WithField("device", ip).Warn("Failed to fetch CoIoT description")
+		return
+	}
+	state.description = desc
+}
+
+// fetchDescription requests /cit/d from the device over unicast CoAP
+func (l *Listener) fetchDescription(ctx context.Context, ip string) (*Description, error) {
+	_, port, err := net.SplitHostPort(l.config.CoIoT.MulticastAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast address: %w", err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to device: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			l.logger.Warnf("Failed to close CoAP connection: %v", err)
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set deadline: %w", err)
+		}
+	}
+
+	request := &message{
+		Type:      typeConfirmable,
+		Code:      codeGet,
+		MessageID: uint16(rand.Intn(1 << 16)),
+		Options: []option{
+			{Number: optionURIPath, Value: []byte("cit")},
+			{Number: optionURIPath, Value: []byte("d")},
+		},
+	}
+	if _, err := conn.Write(request.marshal()); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	buf := make([]byte, 16384)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		response, err := parseMessage(buf[:n])
+		if err != nil || response.MessageID != request.MessageID {
+			// Multicast status updates may arrive on the same port, skip them
+			continue
+		}
+
+		var desc Description
+		if err := json.Unmarshal(response.Payload, &desc); err != nil {
+			return nil, fmt.Errorf("failed to decode description: %w", err)
+		}
+		return &desc, nil
+	}
+}
+
+// Status returns the latest CoIoT status of the device with the given MAC address, converted
+// to a StatusResponse. It reports false when no fresh update with a known description is available.
+func (l *Listener) Status(mac string) (*client.StatusResponse, bool) {
+	mac = discovery.FormatMAC(mac)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for ip, state := range l.devices {
+		if state.mac != mac {
+			continue
+		}
+		if state.description == nil || l.now().Sub(state.receivedAt) > state.validity {
+			return nil, false
+		}
+		return state.toStatus(ip), true
+	}
+
+	return nil, false
+}
+
+// toStatus converts the CoIoT values into the format used by the RPC and legacy APIs
+func (s *deviceState) toStatus(ip string) *client.StatusResponse {
+	status := &client.StatusResponse{Mac: s.mac}
+	status.Sys.Mac = s.mac
+	status.Wifi.StaIP = ip
+	status.Wifi.Status = "got ip"
+
+	blocks := make(map[int]string, len(s.description.Blocks))
+	for _, block := range s.description.Blocks {
+		blocks[block.ID] = block.Description
+	}
+
+	relays := make(map[int]*client.Relay)
+	relay := func(block int) *client.Relay {
+		index, ok := relayIndex(blocks[block])
+		if !ok {
+			return nil
+		}
+		if _, ok := relays[index]; !ok {
+			relays[index] = &client.Relay{IsValid: true}
+		}
+		return relays[index]
+	}
+
+	// Power and energy sensors of the same block belong to the same meter
+	meters := make(map[int]*client.EnergyMeter)
+	meter := func(block int) *client.EnergyMeter {
+		if _, ok := meters[block]; !ok {
+			meters[block] = &client.EnergyMeter{}
+		}
+		return meters[block]
+	}
+
+	firstPower := true
+	for _, sensor := range s.description.Sensors {
+		value, ok := s.values[sensor.ID]
+		if !ok {
+			continue
+		}
+
+		for _, block := range sensor.blocks() {
+			switch sensor.Description {
+			case "output":
+				if r := relay(block); r != nil {
+					r.IsOn = value == 1
+				}
+			case "overpower":
+				if r := relay(block); r != nil {
+					r.Overpower = value == 1
+				}
+			case "power":
+				if firstPower {
+					status.EM.AActPower = value
+					firstPower = false
+				}
+				status.EM.TotalActPower += value
+				meter(block).Power = value
+				status.SetComponent(client.ComponentMeters)
+			case "energy":
+				status.EMData.TotalAct += toWattHours(value, sensor.Unit)
+				meter(block).Total = toWattHours(value, sensor.Unit)
+				status.SetComponent(client.ComponentMeters)
+			case "overtemp":
+				status.Overtemperature = value == 1
+				status.SetComponent(client.ComponentOvertemperature)
+			case "deviceTemp", "temp":
+				if sensor.Unit == "C" {
+					status.Temperature.TC = value
+					status.SetComponent(client.ComponentTemperature)
+				}
+			}
+		}
+	}
+
+	for index := 0; index < len(relays); index++ {
+		r, ok := relays[index]
+		if !ok {
+			break
+		}
+		status.Relays = append(status.Relays, *r)
+	}
+
+	for _, block := range s.description.Blocks {
+		if m, ok := meters[block.ID]; ok {
+			status.EnergyMeters = append(status.EnergyMeters, *m)
+		}
+	}
+
+	return status
+}
+
+// relayIndex extracts the relay index from a block description such as "relay_0"
+func relayIndex(description string) (int, bool) {
+	suffix, ok := strings.CutPrefix(description, "relay_")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// toWattHours converts an energy value in the given CoIoT unit to watt-hours
+func toWattHours(value float64, unit string) float64 {
+	switch unit {
+	case "Wmin":
+		return value / 60
+	case "kWh":
+		return value * 1000
+	default:
+		return value
+	}
+}
+
+// decodeValidity converts the CoIoT validity option into a duration.
+// An even value is expressed in tenths of a second, an odd value in units of four seconds.
+func decodeValidity(v uint32) time.Duration {
+	if v&1 == 0 {
+		return time.Duration(v) * 100 * time.Millisecond
+	}
+	return time.Duration(v) * 4 * time.Second
+}
@@ -0,0 +1,249 @@
+package coiot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Description published by a Shelly 1PM
+const testDescription = `{
+	"blk": [{"I": 1, "D": "relay_0"}, {"I": 2, "D": "device"}],
+	"sen": [
+		{"I": 9103, "T": "EVC", "D": "cfgChanged", "R": "U16", "L": 2},
+		{"I": 1101, "T": "S", "D": "output", "R": "0/1", "L": 1},
+		{"I": 4101, "T": "P", "D": "power", "U": "W", "R": ["0/3500", "-1"], "L": 1},
+		{"I": 4103, "T": "E", "D": "energy", "U": "Wmin", "R": ["U32", "-1"], "L": 1},
+		{"I": 6102, "T": "A", "D": "overpower", "R": ["0/1", "-1"], "L": 1},
+		{"I": 3104, "T": "T", "D": "deviceTemp", "U": "C", "R": ["-40/300", "999"], "L": 2},
+		{"I": 6101, "T": "A", "D": "overtemp", "R": ["0/1", "-1"], "L": 2}
+	]
+}`
+
+// statusPacket builds a /cit/s packet as broadcast by a Shelly 1PM
+func statusPacket(payload string) []byte {
+	msg := &message{
+		Type:      typeNonConfirmable,
+		Code:      30,
+		MessageID: 1,
+		Options: []option{
+			{Number: optionURIPath, Value: []byte("cit")},
+			{Number: optionURIPath, Value: []byte("s")},
+			{Number: optionDeviceID, Value: []byte("SHSW-PM#a4cf12f45678#2")},
+			{Number: optionValidity, Value: uintValue(380)},
+		},
+		Payload: []byte(payload),
+	}
+	return msg.marshal()
+}
+
+func newTestListener(fetch DescriptionFetcher) *Listener {
+	cfg := &config.Config{
+		ScrapeTimeout: 10 * time.Second,
+		CoIoT: config.CoIoTConfig{
+			Enabled:          true,
+			MulticastAddress: "224.0.1.187:5683",
+			Validity:         60 * time.Second,
+		},
+	}
+	l := NewListener(cfg, logrus.New())
+	l.fetch = fetch
+	return l
+}
+
+// waitForDescription waits until the asynchronous description fetch for ip has finished
+func waitForDescription(t *testing.T, l *Listener, ip string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.RLock()
+		state, ok := l.devices[ip]
+		done := ok && !state.fetching
+		l.mu.RUnlock()
+		if done {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("description fetch did not finish")
+}
+
+func TestListener_HandlePacket(t *testing.T) {
+	var desc Description
+	if err := json.Unmarshal([]byte(testDescription), &desc); err != nil {
+		t.Fatalf("Failed to decode test description: %v", err)
+	}
+
+	var mu sync.Mutex
+	var fetched []string
+	l := newTestListener(func(ctx context.Context, ip string) (*Description, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched = append(fetched, ip)
+		return &desc, nil
+	})
+
+	packet := statusPacket(`{"G":[[0,9103,0],[0,1101,1],[0,4101,52.5],[0,4103,6000],[0,6102,0],[0,3104,41.2],[0,6101,0]]}`)
+	if err := l.HandlePacket(context.Background(), "192.168.1.101", packet); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	waitForDescription(t, l, "192.168.1.101")
+
+	if len(fetched) != 1 || fetched[0] != "192.168.1.101" {
+		t.Errorf("description fetched for %v, want [192.168.1.101]", fetched)
+	}
+
+	// Devices are looked up by the MAC address in their polled status
+	status, ok := l.Status("A4CF12F45678")
+	if !ok {
+		t.Fatal("Status() returned no status")
+	}
+
+	if status.Sys.Mac != "A4:CF:12:F4:56:78" {
+		t.Errorf("Sys.Mac = %v, want A4:CF:12:F4:56:78", status.Sys.Mac)
+	}
+	if status.Wifi.StaIP != "192.168.1.101" {
+		t.Errorf("Wifi.StaIP = %v, want 192.168.1.101", status.Wifi.StaIP)
+	}
+	if len(status.Relays) != 1 || !status.Relays[0].IsOn || status.Relays[0].Overpower {
+		t.Errorf("Relays = %+v, want one relay that is on", status.Relays)
+	}
+	if status.EM.TotalActPower != 52.5 {
+		t.Errorf("EM.TotalActPower = %v, want 52.5", status.EM.TotalActPower)
+	}
+	if status.EMData.TotalAct != 100 {
+		t.Errorf("EMData.TotalAct = %v, want 100", status.EMData.TotalAct)
+	}
+	if len(status.EnergyMeters) != 1 || status.EnergyMeters[0] != (client.EnergyMeter{Power: 52.5, Total: 100}) {
+		t.Errorf("EnergyMeters = %+v, want one meter with 52.5 W and 100 Wh", status.EnergyMeters)
+	}
+	if status.Temperature.TC != 41.2 {
+		t.Errorf("Temperature.TC = %v, want 41.2", status.Temperature.TC)
+	}
+	if !status.HasComponent(client.ComponentMeters) || !status.HasComponent(client.ComponentTemperature) {
+		t.Error("Status() should have meters and temperature")
+	}
+	if !status.HasComponent(client.ComponentOvertemperature) || status.Overtemperature {
+		t.Errorf("Status() overtemperature = %v, want reported and false", status.Overtemperature)
+	}
+
+	if _, ok := l.Status("A4CF12F45679"); ok {
+		t.Error("Status() returned status for unknown device")
+	}
+}
+
+func TestListener_Status_Expired(t *testing.T) {
+	var desc Description
+	if err := json.Unmarshal([]byte(testDescription), &desc); err != nil {
+		t.Fatalf("Failed to decode test description: %v", err)
+	}
+
+	now := time.Now()
+	l := newTestListener(func(ctx context.Context, ip string) (*Description, error) {
+		return &desc, nil
+	})
+	l.now = func() time.Time { return now }
+
+	if err := l.HandlePacket(context.Background(), "192.168.1.101", statusPacket(`{"G":[[0,1101,1]]}`)); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	waitForDescription(t, l, "192.168.1.101")
+
+	// Validity of 380 is expressed in tenths of a second
+	now = now.Add(37 * time.Second)
+	if _, ok := l.Status("A4:CF:12:F4:56:78"); !ok {
+		t.Error("Status() expired before validity elapsed")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := l.Status("A4:CF:12:F4:56:78"); ok {
+		t.Error("Status() returned status after validity elapsed")
+	}
+}
+
+func TestListener_HandlePacket_DescriptionError(t *testing.T) {
+	l := newTestListener(func(ctx context.Context, ip string) (*Description, error) {
+		return nil, errors.New("timeout")
+	})
+
+	if err := l.HandlePacket(context.Background(), "192.168.1.101", statusPacket(`{"G":[[0,1101,1]]}`)); err != nil {
+		t.Fatalf("HandlePacket() error = %v", err)
+	}
+	waitForDescription(t, l, "192.168.1.101")
+
+	if _, ok := l.Status("A4:CF:12:F4:56:78"); ok {
+		t.Error("Status() returned status without description")
+	}
+}
+
+func TestListener_HandlePacket_Invalid(t *testing.T) {
+	l := newTestListener(func(ctx context.Context, ip string) (*Description, error) {
+		t.Error("description fetched for invalid packet")
+		return nil, nil
+	})
+
+	other := &message{
+		Type:    typeNonConfirmable,
+		Code:    30,
+		Options: []option{{Number: optionURIPath, Value: []byte("other")}},
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not coap", data: []byte("hello")},
+		{name: "wrong path", data: other.marshal()},
+		{name: "invalid payload", data: statusPacket(`{"G":`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.HandlePacket(context.Background(), "192.168.1.101", tt.data); err == nil {
+				t.Error("HandlePacket() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestDecodeValidity(t *testing.T) {
+	tests := []struct {
+		value uint32
+		want  time.Duration
+	}{
+		{value: 380, want: 38 * time.Second},
+		{value: 0, want: 0},
+		{value: 15, want: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := decodeValidity(tt.value); got != tt.want {
+			t.Errorf("decodeValidity(%d) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestToWattHours(t *testing.T) {
+	tests := []struct {
+		value float64
+		unit  string
+		want  float64
+	}{
+		{value: 120, unit: "Wmin", want: 2},
+		{value: 1.5, unit: "kWh", want: 1500},
+		{value: 42, unit: "Wh", want: 42},
+	}
+
+	for _, tt := range tests {
+		if got := toWattHours(tt.value, tt.unit); got != tt.want {
+			t.Errorf("toWattHours(%v, %q) = %v, want %v", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}
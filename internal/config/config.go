@@ -1,10 +1,21 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
@@ -14,18 +25,58 @@ type Config struct {
 	ListenAddress string `mapstructure:"listen_address"`
 	MetricsPath   string `mapstructure:"metrics_path"`
 
+	// Expose Go runtime and process metrics of the exporter
+	RuntimeMetrics bool `mapstructure:"runtime_metrics"`
+
 	// Logging configuration
 	LogLevel string `mapstructure:"log_level"`
 
 	// Shelly devices configuration
-	ShellyDevices []string `mapstructure:"shelly_devices"`
+	ShellyDevices []DeviceConfig `mapstructure:"shelly_devices"`
 
 	// Scraping configuration
 	ScrapeInterval time.Duration `mapstructure:"scrape_interval"`
 	ScrapeTimeout  time.Duration `mapstructure:"scrape_timeout"`
 
+	// Serve the last values of a device that cannot be scraped for this long, 0 disables it
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+
+	// Export energy counters that survive resets of the device counters
+	MonotonicEnergy bool `mapstructure:"monotonic_energy"`
+
+	// Directory for state kept across restarts, such as the monotonic energy counters
+	StateDir string `mapstructure:"state_dir"`
+
+	// How often the state is written to the state directory
+	StateFlushInterval time.Duration `mapstructure:"state_flush_interval"`
+
 	// TLS configuration
 	TLS TLSConfig `mapstructure:"tls"`
+
+	// Device authentication
+	Auth AuthConfig `mapstructure:"auth"`
+
+	// CoIoT configuration
+	CoIoT CoIoTConfig `mapstructure:"coiot"`
+
+	// Discovery configuration
+	Discovery DiscoveryConfig `mapstructure:"discovery"`
+
+	// Probe modules for the /probe endpoint
+	Modules map[string]ModuleConfig `mapstructure:"modules"`
+
+	// Reload the configuration when the file changes
+	WatchConfig bool `mapstructure:"watch_config"`
+
+	// ConfigFile is the configuration file that was read, if any
+	ConfigFile string `mapstructure:"-"`
+}
+
+// DeviceConfig holds configuration for a single Shelly device.
+// In the configuration file an entry may also be given as a plain URL string.
+type DeviceConfig struct {
+	URL    string            `mapstructure:"url"`
+	Labels map[string]string `mapstructure:"labels"`
 }
 
 // TLSConfig holds TLS configuration for Shelly device connections
@@ -37,6 +88,206 @@ type TLSConfig struct {
 	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
 }
 
+// validate checks the TLS configuration found under the given configuration key
+func (t TLSConfig) validate(key string) []string {
+	var errors []string
+	if t.Enabled {
+		if t.CertFile != "" && t.KeyFile == "" {
+			errors = append(errors, key+".key_file is required when "+key+".cert_file is set")
+		}
+		if t.KeyFile != "" && t.CertFile == "" {
+			errors = append(errors, key+".cert_file is required when "+key+".key_file is set")
+		}
+		if len(errors) == 0 {
+			if _, err := t.ClientConfig(); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	}
+	return errors
+}
+
+// ClientConfig returns the TLS configuration for connections to devices with the CA
+// certificate and client certificate loaded from their files
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.CertFile != "" && t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// AuthConfig holds credentials for Shelly devices with authentication enabled.
+// The password may reference environment variables as ${NAME} or be read from password_file.
+type AuthConfig struct {
+	Username     string `mapstructure:"username"`
+	Password     Secret `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password_file"`
+}
+
+// Secret is a configuration value that is redacted when the configuration is printed
+type Secret string
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// MarshalText implements encoding.TextMarshaler so that secrets are redacted in JSON and YAML
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// resolve expands environment references in the password or reads it from password_file.
+// The key is used in error messages.
+func (a *AuthConfig) resolve(key string) error {
+	if a.PasswordFile != "" {
+		if a.Password != "" {
+			return fmt.Errorf("%s.password and %s.password_file are mutually exclusive", key, key)
+		}
+		data, err := os.ReadFile(a.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s.password_file: %w", key, err)
+		}
+		a.Password = Secret(strings.TrimRight(string(data), "\r\n"))
+		return nil
+	}
+
+	password, err := expandEnv(string(a.Password))
+	if err != nil {
+		return fmt.Errorf("%s.password: %w", key, err)
+	}
+	a.Password = Secret(password)
+	return nil
+}
+
+// CoIoTConfig holds configuration for the Gen1 CoIoT (CoAP multicast) listener
+type CoIoTConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	MulticastAddress string        `mapstructure:"multicast_address"`
+	Interface        string        `mapstructure:"interface"`
+	Validity         time.Duration `mapstructure:"validity"`
+}
+
+// DiscoveryConfig holds configuration for automatic discovery of Shelly devices
+type DiscoveryConfig struct {
+	Interval     time.Duration `mapstructure:"interval"`
+	ExpireAfter  time.Duration `mapstructure:"expire_after"`
+	ProbeTimeout time.Duration `mapstructure:"probe_timeout"`
+	Concurrency  int           `mapstructure:"concurrency"`
+	Include      FilterConfig  `mapstructure:"include"`
+	Exclude      FilterConfig  `mapstructure:"exclude"`
+	MDNS         MDNSConfig    `mapstructure:"mdns"`
+	Subnets      []string      `mapstructure:"subnets"`
+	FileSD       FileSDConfig  `mapstructure:"file_sd"`
+}
+
+// FilterConfig holds glob patterns matched against discovered device models and names
+type FilterConfig struct {
+	Models []string `mapstructure:"models"`
+	Names  []string `mapstructure:"names"`
+}
+
+// MDNSConfig holds configuration for mDNS / DNS-SD discovery
+type MDNSConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Interface string        `mapstructure:"interface"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// FileSDConfig holds configuration for Prometheus file_sd compatible target files
+type FileSDConfig struct {
+	Files           []string      `mapstructure:"files"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// ModuleConfig holds the settings used to probe a target through the /probe endpoint.
+// Settings that are not set fall back to the global configuration.
+type ModuleConfig struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+	Auth    AuthConfig    `mapstructure:"auth"`
+	TLS     TLSConfig     `mapstructure:"tls"`
+}
+
+// Enabled reports whether any probing discovery mechanism (mDNS or subnet scan) is enabled
+func (d DiscoveryConfig) Enabled() bool {
+	return d.MDNS.Enabled || len(d.Subnets) > 0
+}
+
+// minSubnetBits is the prefix length of the largest subnet that may be scanned
+const minSubnetBits = 16
+
+// envPrefix is the prefix of environment variables overriding configuration keys.
+// Nested keys are joined with underscores, e.g. SHELLY_EXPORTER_TLS_CA_FILE for tls.ca_file.
+const envPrefix = "SHELLY_EXPORTER"
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "<secret>"
+
+// envRefRE matches ${NAME} references to environment variables in secrets
+var envRefRE = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// labelNameRE matches valid Prometheus label names
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabels checks that labels can be attached to Prometheus series.
+// Names starting with "__" are reserved.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// stringToSliceHook splits comma separated strings, as set in environment variables, into lists.
+// Surrounding whitespace and empty items are dropped.
+func stringToSliceHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to.Kind() != reflect.Slice {
+		return data, nil
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(data.(string), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// deviceDecodeHook allows shelly_devices entries to be given as plain URL strings
+func deviceDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(DeviceConfig{}) {
+		return data, nil
+	}
+	return DeviceConfig{URL: data.(string)}, nil
+}
+
 // Load loads configuration from file and environment variables
 func Load(cfgFile string) (*Config, error) {
 	v := viper.New()
@@ -45,7 +296,10 @@ func Load(cfgFile string) (*Config, error) {
 	setDefaults(v)
 
 	// Enable reading from environment variables
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
+	bindEnv(v, reflect.TypeOf(Config{}), "")
 
 	// Set config file
 	if cfgFile != "" {
@@ -68,10 +322,21 @@ func Load(cfgFile string) (*Config, error) {
 
 	// Unmarshal into struct
 	var cfg Config
-	if err := v.Unmarshal(&cfg); err != nil {
+	if err := v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		stringToSliceHook,
+		deviceDecodeHook,
+	))); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	cfg.ConfigFile = v.ConfigFileUsed()
+
+	// Resolve secrets, files are read again on every load
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -80,15 +345,95 @@ func Load(cfgFile string) (*Config, error) {
 	return &cfg, nil
 }
 
+// expandEnv replaces ${NAME} references with the value of the environment variable.
+// Referencing a variable that is not set is an error.
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envRefRE.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRefRE.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// resolveSecrets expands environment references in secrets and reads secrets from files
+func (c *Config) resolveSecrets() error {
+	if err := c.Auth.resolve("auth"); err != nil {
+		return err
+	}
+	for name, module := range c.Modules {
+		if err := module.Auth.resolve("modules." + name + ".auth"); err != nil {
+			return err
+		}
+		c.Modules[name] = module
+	}
+	return nil
+}
+
+// RedactURL hides the password of a URL with user information so that it can be shown
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// bindEnv binds an environment variable to every configuration key of the struct type.
+// Without an explicit binding, keys that have no default and are not present in the
+// configuration file would be ignored when unmarshaling.
+func bindEnv(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" || key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			bindEnv(v, field.Type, key)
+			continue
+		}
+		// BindEnv only fails when called without a key
+		_ = v.BindEnv(key)
+	}
+}
+
 // setDefaults sets default configuration values
 func setDefaults(v *viper.Viper) {
 	v.SetDefault("listen_address", ":8080")
 	v.SetDefault("metrics_path", "/metrics")
 	v.SetDefault("log_level", "info")
+	v.SetDefault("watch_config", false)
+	v.SetDefault("runtime_metrics", false)
 	v.SetDefault("scrape_interval", 30*time.Second)
 	v.SetDefault("scrape_timeout", 10*time.Second)
+	v.SetDefault("max_staleness", 0)
+	v.SetDefault("monotonic_energy", false)
+	v.SetDefault("state_flush_interval", time.Minute)
 	v.SetDefault("tls.enabled", false)
 	v.SetDefault("tls.insecure_skip_verify", false)
+	v.SetDefault("auth.username", "admin")
+	v.SetDefault("coiot.enabled", false)
+	v.SetDefault("coiot.multicast_address", "224.0.1.187:5683")
+	v.SetDefault("coiot.validity", 60*time.Second)
+	v.SetDefault("discovery.interval", 5*time.Minute)
+	v.SetDefault("discovery.expire_after", 15*time.Minute)
+	v.SetDefault("discovery.probe_timeout", 2*time.Second)
+	v.SetDefault("discovery.concurrency", 32)
+	v.SetDefault("discovery.mdns.enabled", false)
+	v.SetDefault("discovery.mdns.timeout", 5*time.Second)
+	v.SetDefault("discovery.file_sd.refresh_interval", 5*time.Minute)
 }
 
 // Validate validates the configuration
@@ -103,10 +448,19 @@ func (c *Config) Validate() error {
 		errors = append(errors, "metrics_path cannot be empty")
 	}
 
-	if len(c.ShellyDevices) == 0 {
+	if len(c.ShellyDevices) == 0 && !c.Discovery.Enabled() && len(c.Discovery.FileSD.Files) == 0 && len(c.Modules) == 0 {
 		errors = append(errors, "at least one shelly device must be configured")
 	}
 
+	for i, device := range c.ShellyDevices {
+		if device.URL == "" {
+			errors = append(errors, fmt.Sprintf("shelly_devices[%d].url cannot be empty", i))
+		}
+		if err := ValidateLabels(device.Labels); err != nil {
+			errors = append(errors, fmt.Sprintf("shelly_devices[%d].labels: %v", i, err))
+		}
+	}
+
 	if c.ScrapeInterval <= 0 {
 		errors = append(errors, "scrape_interval must be positive")
 	}
@@ -119,14 +473,87 @@ func (c *Config) Validate() error {
 		errors = append(errors, "scrape_timeout must be less than scrape_interval")
 	}
 
+	if c.MaxStaleness < 0 {
+		errors = append(errors, "max_staleness cannot be negative")
+	}
+
+	if c.StateDir != "" && c.StateFlushInterval <= 0 {
+		errors = append(errors, "state_flush_interval must be positive")
+	}
+
 	// Validate TLS configuration
-	if c.TLS.Enabled {
-		if c.TLS.CertFile != "" && c.TLS.KeyFile == "" {
-			errors = append(errors, "tls.key_file is required when tls.cert_file is set")
+	errors = append(errors, c.TLS.validate("tls")...)
+
+	// Validate CoIoT configuration
+	if c.CoIoT.Enabled {
+		if _, err := net.ResolveUDPAddr("udp4", c.CoIoT.MulticastAddress); err != nil {
+			errors = append(errors, fmt.Sprintf("coiot.multicast_address is invalid: %v", err))
 		}
-		if c.TLS.KeyFile != "" && c.TLS.CertFile == "" {
-			errors = append(errors, "tls.cert_file is required when tls.key_file is set")
+		if c.CoIoT.Validity <= 0 {
+			errors = append(errors, "coiot.validity must be positive")
+		}
+	}
+
+	// Validate discovery configuration
+	if c.Discovery.Enabled() {
+		if c.Discovery.Interval <= 0 {
+			errors = append(errors, "discovery.interval must be positive")
+		}
+		if c.Discovery.ExpireAfter < c.Discovery.Interval {
+			errors = append(errors, "discovery.expire_after must not be less than discovery.interval")
+		}
+		if c.Discovery.ProbeTimeout <= 0 {
+			errors = append(errors, "discovery.probe_timeout must be positive")
+		}
+		if c.Discovery.Concurrency <= 0 {
+			errors = append(errors, "discovery.concurrency must be positive")
+		}
+		if c.Discovery.MDNS.Enabled && c.Discovery.MDNS.Timeout <= 0 {
+			errors = append(errors, "discovery.mdns.timeout must be positive")
+		}
+		for _, subnet := range c.Discovery.Subnets {
+			prefix, err := netip.ParsePrefix(subnet)
+			if err != nil || !prefix.Addr().Is4() {
+				errors = append(errors, fmt.Sprintf("discovery.subnets entry %q is not a valid IPv4 CIDR", subnet))
+				continue
+			}
+			if prefix.Bits() < minSubnetBits {
+				errors = append(errors, fmt.Sprintf("discovery.subnets entry %q is larger than /%d", subnet, minSubnetBits))
+			}
+		}
+		for _, filter := range []FilterConfig{c.Discovery.Include, c.Discovery.Exclude} {
+			for _, pattern := range append(filter.Models, filter.Names...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errors = append(errors, fmt.Sprintf("invalid discovery filter pattern %q", pattern))
+				}
+			}
+		}
+	}
+
+	// Validate file_sd configuration
+	if len(c.Discovery.FileSD.Files) > 0 {
+		if c.Discovery.FileSD.RefreshInterval <= 0 {
+			errors = append(errors, "discovery.file_sd.refresh_interval must be positive")
+		}
+		for _, pattern := range c.Discovery.FileSD.Files {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				errors = append(errors, fmt.Sprintf("invalid discovery.file_sd.files pattern %q", pattern))
+				continue
+			}
+			switch filepath.Ext(pattern) {
+			case ".json", ".yml", ".yaml":
+			default:
+				errors = append(errors, fmt.Sprintf("discovery.file_sd.files entry %q must end in .json, .yml or .yaml", pattern))
+			}
+		}
+	}
+
+	// Validate probe modules
+	for name, module := range c.Modules {
+		if module.Timeout < 0 {
+			errors = append(errors, fmt.Sprintf("modules.%s.timeout must not be negative", name))
 		}
+		errors = append(errors, module.TLS.validate("modules."+name+".tls")...)
 	}
 
 	if len(errors) > 0 {
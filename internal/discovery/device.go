@@ -0,0 +1,118 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Candidate is a possible Shelly device found by a discovery source
+type Candidate struct {
+	// URL is the base URL of the candidate, e.g. http://192.168.1.100
+	URL string
+	// Name is a name hint from the source, used when the device does not report one
+	Name string
+}
+
+// Device is a Shelly device identified through its /shelly endpoint
+type Device struct {
+	URL         string
+	MAC         string
+	Model       string
+	App         string
+	Gen         int
+	Name        string
+	Firmware    string
+	AuthEnabled bool
+}
+
+// shellyResponse is the /shelly response of Gen1 and Gen2+ devices
+type shellyResponse struct {
+	// Gen1 fields
+	Type string `json:"type"`
+	Auth bool   `json:"auth"`
+	FW   string `json:"fw"`
+
+	// Gen2+ fields
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Model  string `json:"model"`
+	Gen    int    `json:"gen"`
+	App    string `json:"app"`
+	Ver    string `json:"ver"`
+	AuthEn bool   `json:"auth_en"`
+
+	// Common fields
+	Mac string `json:"mac"`
+}
+
+// Probe requests /shelly from the candidate to confirm it is a Shelly device
+func Probe(ctx context.Context, httpClient *http.Client, candidate Candidate) (*Device, error) {
+	url := strings.TrimSuffix(candidate.URL, "/") + "/shelly"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var shelly shellyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&shelly); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	if shelly.Mac == "" || (shelly.Type == "" && shelly.Model == "") {
+		return nil, fmt.Errorf("not a Shelly device")
+	}
+
+	device := &Device{
+		URL:  strings.TrimSuffix(candidate.URL, "/"),
+		MAC:  FormatMAC(shelly.Mac),
+		Name: candidate.Name,
+	}
+
+	if shelly.Gen >= 2 {
+		device.Gen = shelly.Gen
+		device.Model = shelly.Model
+		device.App = shelly.App
+		device.Firmware = shelly.Ver
+		device.AuthEnabled = shelly.AuthEn
+		if shelly.Name != "" {
+			device.Name = shelly.Name
+		}
+	} else {
+		device.Gen = 1
+		device.Model = shelly.Type
+		device.Firmware = shelly.FW
+		device.AuthEnabled = shelly.Auth
+	}
+
+	return device, nil
+}
+
+// FormatMAC normalizes a MAC address to the AA:BB:CC:DD:EE:FF form
+func FormatMAC(mac string) string {
+	mac = strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if len(mac) != 12 {
+		return mac
+	}
+
+	parts := make([]string, 0, 6)
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, mac[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		hint     string
+		want     Device
+	}{
+		{
+			name:     "gen1 device",
+			response: `{"type":"SHSW-PM","mac":"A4CF12F45678","auth":true,"fw":"20230913-112003/v1.14.0-gcb84623","num_outputs":1,"num_meters":1}`,
+			hint:     "shelly1pm-A4CF12F45678",
+			want: Device{
+				MAC:         "A4:CF:12:F4:56:78",
+				Model:       "SHSW-PM",
+				Gen:         1,
+				Name:        "shelly1pm-A4CF12F45678",
+				Firmware:    "20230913-112003/v1.14.0-gcb84623",
+				AuthEnabled: true,
+			},
+		},
+		{
+			name:     "gen2 device with name",
+			response: `{"name":"Main Meter","id":"shellypro3em-aabbccddeeff","mac":"AABBCCDDEEFF","model":"SPEM-003CEBEU","gen":2,"fw_id":"20231107-164738/1.0.8-g","ver":"1.0.8","app":"Pro3EM","auth_en":false}`,
+			hint:     "shellypro3em-aabbccddeeff",
+			want: Device{
+				MAC:      "AA:BB:CC:DD:EE:FF",
+				Model:    "SPEM-003CEBEU",
+				App:      "Pro3EM",
+				Gen:      2,
+				Name:     "Main Meter",
+				Firmware: "1.0.8",
+			},
+		},
+		{
+			name:     "gen2 device without name",
+			response: `{"name":null,"id":"shellyplus1pm-aabbccddee00","mac":"AABBCCDDEE00","model":"SNSW-001P16EU","gen":2,"ver":"1.1.0","app":"Plus1PM","auth_en":true}`,
+			hint:     "shellyplus1pm-aabbccddee00",
+			want: Device{
+				MAC:         "AA:BB:CC:DD:EE:00",
+				Model:       "SNSW-001P16EU",
+				App:         "Plus1PM",
+				Gen:         2,
+				Name:        "shellyplus1pm-aabbccddee00",
+				Firmware:    "1.1.0",
+				AuthEnabled: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/shelly" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			got, err := Probe(context.Background(), &http.Client{Timeout: time.Second}, Candidate{URL: server.URL + "/", Name: tt.hint})
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+
+			tt.want.URL = server.URL
+			if *got != tt.want {
+				t.Errorf("Probe() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbe_NotShelly(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		payload string
+	}{
+		{name: "not found", status: http.StatusNotFound, payload: ""},
+		{name: "invalid json", status: http.StatusOK, payload: "<html></html>"},
+		{name: "other device", status: http.StatusOK, payload: `{"name":"router"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.payload))
+			}))
+			defer server.Close()
+
+			if _, err := Probe(context.Background(), &http.Client{Timeout: time.Second}, Candidate{URL: server.URL}); err == nil {
+				t.Error("Probe() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestFormatMAC(t *testing.T) {
+	tests := map[string]string{
+		"a4cf12f45678":      "A4:CF:12:F4:56:78",
+		"A4:CF:12:F4:56:78": "A4:CF:12:F4:56:78",
+		"a4-cf-12-f4-56-78": "A4:CF:12:F4:56:78",
+		"short":             "SHORT",
+	}
+
+	for input, want := range tests {
+		if got := FormatMAC(input); got != want {
+			t.Errorf("FormatMAC(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
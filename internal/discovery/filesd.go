@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// schemeLabel selects the URL scheme of targets given as host:port, as in Prometheus
+const schemeLabel = "__scheme__"
+
+// Target is a device read from a file_sd file
+type Target struct {
+	// URL is the base URL of the device, e.g. http://192.168.1.100
+	URL string
+	// Labels are added to every series of the device
+	Labels map[string]string
+}
+
+// TargetsHandler is notified with the complete set of targets whenever it changes
+type TargetsHandler interface {
+	TargetsChanged(targets []Target)
+}
+
+// targetGroup is an entry of a Prometheus file_sd file
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// FileSD reads devices from Prometheus file_sd compatible JSON and YAML files.
+// The files are re-read when they change and on every refresh interval.
+type FileSD struct {
+	config  config.FileSDConfig
+	logger  *logrus.Logger
+	handler TargetsHandler
+
+	// files holds the targets of every file that was read successfully
+	files   map[string][]Target
+	targets []Target
+}
+
+// NewFileSD creates a new file_sd source
+func NewFileSD(cfg config.FileSDConfig, logger *logrus.Logger, handler TargetsHandler) *FileSD {
+	return &FileSD{
+		config:  cfg,
+		logger:  logger,
+		handler: handler,
+		files:   make(map[string][]Target),
+	}
+}
+
+// Run reads the files immediately and then whenever they change until the context is cancelled
+func (f *FileSD) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	// Watch the directories rather than the files so that files replaced
+	// by a rename are picked up as well
+	dirs := make(map[string]bool)
+	for _, pattern := range f.config.Files {
+		dirs[filepath.Dir(pattern)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			f.logger.WithError(err).WithField("directory", dir).Warn("Failed to watch file_sd directory, relying on refresh interval")
+		}
+	}
+
+	ticker := time.NewTicker(f.config.RefreshInterval)
+	defer ticker.Stop()
+
+	f.Refresh()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if f.matches(event.Name) {
+				f.Refresh()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			f.logger.WithError(err).Warn("file_sd watcher error")
+		case <-ticker.C:
+			f.Refresh()
+		}
+	}
+}
+
+// Refresh reads all files and notifies the handler if the targets changed.
+// A file that cannot be read keeps its previous targets.
+func (f *FileSD) Refresh() {
+	files := make(map[string][]Target)
+	for _, pattern := range f.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			f.logger.WithError(err).WithField("pattern", pattern).Warn("Invalid file_sd pattern")
+			continue
+		}
+		for _, file := range matches {
+			targets, err := readTargetFile(file)
+			if err != nil {
+				f.logger.WithError(err).WithField("file", file).Warn("Failed to read file_sd file")
+				if previous, ok := f.files[file]; ok {
+					files[file] = previous
+				}
+				continue
+			}
+			files[file] = targets
+		}
+	}
+	f.files = files
+
+	targets := mergeTargets(files)
+	if reflect.DeepEqual(targets, f.targets) {
+		return
+	}
+	f.targets = targets
+
+	f.logger.WithFields(logrus.Fields{
+		"files":   len(files),
+		"targets": len(targets),
+	}).Info("file_sd targets changed")
+	f.handler.TargetsChanged(targets)
+}
+
+// matches reports whether the path matches any of the configured file patterns
+func (f *FileSD) matches(name string) bool {
+	name = filepath.Clean(name)
+	for _, pattern := range f.config.Files {
+		if ok, _ := filepath.Match(filepath.Clean(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTargets combines the targets of all files ordered by file name.
+// A device listed more than once keeps the first entry.
+func mergeTargets(files map[string][]Target) []Target {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+	var targets []Target
+	for _, name := range names {
+		for _, target := range files[name] {
+			if seen[target.URL] {
+				continue
+			}
+			seen[target.URL] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// readTargetFile parses a file_sd file in JSON or YAML format
+func readTargetFile(file string) ([]Target, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var groups []targetGroup
+	switch filepath.Ext(file) {
+	case ".json":
+		err = json.Unmarshal(data, &groups)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &groups)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", filepath.Ext(file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	var targets []Target
+	for _, group := range groups {
+		// Labels starting with "__" are meta labels and not attached to series
+		labels := make(map[string]string)
+		for name, value := range group.Labels {
+			if !strings.HasPrefix(name, "__") {
+				labels[name] = value
+			}
+		}
+		if err := config.ValidateLabels(labels); err != nil {
+			return nil, err
+		}
+
+		for _, target := range group.Targets {
+			deviceURL, err := targetURL(target, group.Labels[schemeLabel])
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, Target{URL: deviceURL, Labels: labels})
+		}
+	}
+	return targets, nil
+}
+
+// targetURL converts a file_sd target into a device base URL.
+// Targets are usually given as host:port, but full URLs are accepted as well.
+func targetURL(target, scheme string) (string, error) {
+	if scheme == "" {
+		scheme = "http"
+	}
+	if !strings.Contains(target, "://") {
+		target = scheme + "://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	return strings.TrimSuffix(target, "/"), nil
+}
@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingTargetsHandler records the targets passed to TargetsChanged
+type recordingTargetsHandler struct {
+	mu      sync.Mutex
+	calls   int
+	targets []Target
+}
+
+func (h *recordingTargetsHandler) TargetsChanged(targets []Target) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	h.targets = targets
+}
+
+func (h *recordingTargetsHandler) get() (int, []Target) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls, h.targets
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestReadTargetFile(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "targets.json")
+	writeFile(t, jsonFile, `[
+  {"targets": ["192.168.1.100", "192.168.1.101:8080"], "labels": {"room": "kitchen", "__meta_cmdb": "x"}},
+  {"targets": ["https://shelly.example.com/"]}
+]`)
+
+	targets, err := readTargetFile(jsonFile)
+	if err != nil {
+		t.Fatalf("readTargetFile() error = %v", err)
+	}
+	want := []string{"http://192.168.1.100", "http://192.168.1.101:8080", "https://shelly.example.com"}
+	if len(targets) != len(want) {
+		t.Fatalf("readTargetFile() returned %d targets, want %d", len(targets), len(want))
+	}
+	for i, target := range targets {
+		if target.URL != want[i] {
+			t.Errorf("targets[%d].URL = %v, want %v", i, target.URL, want[i])
+		}
+	}
+	if targets[0].Labels["room"] != "kitchen" || len(targets[0].Labels) != 1 {
+		t.Errorf("targets[0].Labels = %v, want only room=kitchen", targets[0].Labels)
+	}
+
+	yamlFile := filepath.Join(dir, "targets.yml")
+	writeFile(t, yamlFile, `
+- targets:
+    - 192.168.1.102
+  labels:
+    __scheme__: https
+    site: home
+`)
+
+	targets, err = readTargetFile(yamlFile)
+	if err != nil {
+		t.Fatalf("readTargetFile() error = %v", err)
+	}
+	if len(targets) != 1 || targets[0].URL != "https://192.168.1.102" || targets[0].Labels["site"] != "home" {
+		t.Errorf("readTargetFile() = %+v, want https://192.168.1.102 with site=home", targets)
+	}
+
+	for name, content := range map[string]string{
+		"invalid.json":  `{"targets": "not a list"}`,
+		"badlabel.json": `[{"targets": ["192.168.1.100"], "labels": {"bad-name": "x"}}]`,
+		"badtarget.yml": `[{"targets": ["http://"]}]`,
+	} {
+		file := filepath.Join(dir, name)
+		writeFile(t, file, content)
+		if _, err := readTargetFile(file); err == nil {
+			t.Errorf("readTargetFile(%s) expected error, got nil", name)
+		}
+	}
+}
+
+func TestFileSD_Refresh(t *testing.T) {
+	dir := t.TempDir()
+	handler := &recordingTargetsHandler{}
+	fileSD := NewFileSD(config.FileSDConfig{
+		Files:           []string{filepath.Join(dir, "*.json")},
+		RefreshInterval: time.Minute,
+	}, logrus.New(), handler)
+
+	writeFile(t, filepath.Join(dir, "a.json"), `[{"targets": ["192.168.1.100"], "labels": {"room": "kitchen"}}]`)
+	writeFile(t, filepath.Join(dir, "b.json"), `[{"targets": ["192.168.1.100", "192.168.1.101"]}]`)
+
+	fileSD.Refresh()
+	calls, targets := handler.get()
+	if calls != 1 {
+		t.Fatalf("TargetsChanged() calls = %d, want 1", calls)
+	}
+	// The first file listing a device wins
+	if len(targets) != 2 || targets[0].Labels["room"] != "kitchen" || targets[1].URL != "http://192.168.1.101" {
+		t.Errorf("TargetsChanged() targets = %+v", targets)
+	}
+
+	// Unchanged files do not notify the handler
+	fileSD.Refresh()
+	if calls, _ := handler.get(); calls != 1 {
+		t.Errorf("TargetsChanged() calls = %d after unchanged refresh, want 1", calls)
+	}
+
+	// A broken file keeps its previous targets
+	writeFile(t, filepath.Join(dir, "b.json"), `[{"targets": [`)
+	fileSD.Refresh()
+	if calls, _ := handler.get(); calls != 1 {
+		t.Errorf("TargetsChanged() calls = %d after broken file, want 1", calls)
+	}
+
+	// A removed file drops its targets
+	if err := os.Remove(filepath.Join(dir, "b.json")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	fileSD.Refresh()
+	calls, targets = handler.get()
+	if calls != 2 || len(targets) != 1 || targets[0].URL != "http://192.168.1.100" {
+		t.Errorf("TargetsChanged() calls = %d targets = %+v, want only http://192.168.1.100", calls, targets)
+	}
+}
+
+func TestFileSD_RunWatchesFiles(t *testing.T) {
+	dir := t.TempDir()
+	handler := &recordingTargetsHandler{}
+	fileSD := NewFileSD(config.FileSDConfig{
+		Files:           []string{filepath.Join(dir, "*.yaml")},
+		RefreshInterval: time.Hour,
+	}, logrus.New(), handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- fileSD.Run(ctx) }()
+
+	writeFile(t, filepath.Join(dir, "targets.yaml"), "- targets: [192.168.1.100]\n")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, targets := handler.get()
+		if len(targets) == 1 && targets[0].URL == "http://192.168.1.100" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TargetsChanged() not called after file change, targets = %+v", targets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"path"
+	"strings"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+)
+
+// Filter decides which discovered devices are scraped
+type Filter struct {
+	include config.FilterConfig
+	exclude config.FilterConfig
+}
+
+// NewFilter creates a filter from include and exclude patterns
+func NewFilter(include, exclude config.FilterConfig) *Filter {
+	return &Filter{include: include, exclude: exclude}
+}
+
+// Match reports whether the device passes the filter.
+// A device must match every non-empty include list and no exclude pattern.
+func (f *Filter) Match(d *Device) bool {
+	models := []string{d.Model, d.App}
+	names := []string{d.Name}
+
+	if len(f.include.Models) > 0 && !matchAny(f.include.Models, models) {
+		return false
+	}
+	if len(f.include.Names) > 0 && !matchAny(f.include.Names, names) {
+		return false
+	}
+
+	return !matchAny(f.exclude.Models, models) && !matchAny(f.exclude.Names, names)
+}
+
+// matchAny reports whether any non-empty value matches any of the glob patterns, ignoring case
+func matchAny(patterns, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
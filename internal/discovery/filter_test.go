@@ -0,0 +1,39 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+)
+
+func TestFilter_Match(t *testing.T) {
+	pro3em := &Device{Model: "SPEM-003CEBEU", App: "Pro3EM", Name: "main-meter"}
+	plug := &Device{Model: "SHPLG-S", Name: "shellyplug-s-AABBCC"}
+
+	tests := []struct {
+		name    string
+		include config.FilterConfig
+		exclude config.FilterConfig
+		device  *Device
+		want    bool
+	}{
+		{name: "no filters", device: plug, want: true},
+		{name: "include model matches app", include: config.FilterConfig{Models: []string{"pro3em"}}, device: pro3em, want: true},
+		{name: "include model glob", include: config.FilterConfig{Models: []string{"SHPLG-*"}}, device: plug, want: true},
+		{name: "include model mismatch", include: config.FilterConfig{Models: []string{"SHPLG-*"}}, device: pro3em, want: false},
+		{name: "include name", include: config.FilterConfig{Names: []string{"main-*"}}, device: pro3em, want: true},
+		{name: "include model and name mismatch", include: config.FilterConfig{Models: []string{"Pro3EM"}, Names: []string{"garage"}}, device: pro3em, want: false},
+		{name: "exclude name", exclude: config.FilterConfig{Names: []string{"shellyplug-*"}}, device: plug, want: false},
+		{name: "exclude model", exclude: config.FilterConfig{Models: []string{"SPEM-*"}}, device: pro3em, want: false},
+		{name: "exclude wins over include", include: config.FilterConfig{Models: []string{"*"}}, exclude: config.FilterConfig{Names: []string{"main-meter"}}, device: pro3em, want: false},
+		{name: "include name does not match empty name", include: config.FilterConfig{Names: []string{"*"}}, device: &Device{Model: "SHSW-PM"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewFilter(tt.include, tt.exclude).Match(tt.device); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultProbeConcurrency limits the number of candidates probed at the same time
+// when no concurrency is configured
+const defaultProbeConcurrency = 16
+
+// Source finds candidate Shelly devices
+type Source interface {
+	// Name identifies the source in logs
+	Name() string
+	// Discover returns the candidates currently visible to the source
+	Discover(ctx context.Context) ([]Candidate, error)
+}
+
+// Handler is notified when discovered devices appear or disappear
+type Handler interface {
+	DeviceAdded(d *Device)
+	DeviceRemoved(d *Device)
+}
+
+// trackedDevice is a discovered device and the time it was last seen
+type trackedDevice struct {
+	device   *Device
+	lastSeen time.Time
+}
+
+// Manager periodically runs discovery sources and keeps the handler in sync with
+// the set of discovered devices. Devices are tracked by MAC address.
+type Manager struct {
+	config      *config.Config
+	logger      *logrus.Logger
+	handler     Handler
+	sources     []Source
+	filter      *Filter
+	probe       func(ctx context.Context, candidate Candidate) (*Device, error)
+	concurrency int
+	now         func() time.Time
+	devices     map[string]*trackedDevice
+
+	mu sync.Mutex
+}
+
+// NewManager creates a new discovery manager
+func NewManager(cfg *config.Config, logger *logrus.Logger, handler Handler, sources ...Source) *Manager {
+	timeout := cfg.Discovery.ProbeTimeout
+	if timeout <= 0 {
+		timeout = cfg.ScrapeTimeout
+	}
+	httpClient := &http.Client{Timeout: timeout}
+
+	concurrency := cfg.Discovery.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultProbeConcurrency
+	}
+
+	return &Manager{
+		config:  cfg,
+		logger:  logger,
+		handler: handler,
+		sources: sources,
+		filter:  NewFilter(cfg.Discovery.Include, cfg.Discovery.Exclude),
+		probe: func(ctx context.Context, candidate Candidate) (*Device, error) {
+			return Probe(ctx, httpClient, candidate)
+		},
+		concurrency: concurrency,
+		now:         time.Now,
+		devices:     make(map[string]*trackedDevice),
+	}
+}
+
+// Run refreshes the discovered devices immediately and then on every discovery interval
+// until the context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Discovery.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh runs every source once, probes the candidates and updates the handler
+func (m *Manager) Refresh(ctx context.Context) {
+	candidates := make(map[string]Candidate)
+	for _, source := range m.sources {
+		found, err := source.Discover(ctx)
+		if err != nil {
+			m.logger.WithError(err).WithField("source", source.Name()).Warn("Device discovery failed")
+		}
+		for _, candidate := range found {
+			if _, ok := candidates[candidate.URL]; !ok {
+				candidates[candidate.URL] = candidate
+			}
+		}
+		m.logger.WithFields(logrus.Fields{
+			"source":     source.Name(),
+			"candidates": len(found),
+		}).Debug("Device discovery finished")
+	}
+
+	devices := m.probeAll(ctx, candidates)
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.update(devices)
+}
+
+// probeAll probes candidates with bounded concurrency and returns the confirmed devices
+func (m *Manager) probeAll(ctx context.Context, candidates map[string]Candidate) []*Device {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		devices []*Device
+		sem     = make(chan struct{}, m.concurrency)
+	)
+
+	for _, candidate := range candidates {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		}
+
+		wg.Add(1)
+		go func(candidate Candidate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			device, err := m.probe(ctx, candidate)
+			if err != nil {
+				m.logger.WithError(err).WithField("candidate", candidate.URL).Debug("Candidate is not a Shelly device")
+				return
+			}
+
+			mu.Lock()
+			devices = append(devices, device)
+			mu.Unlock()
+		}(candidate)
+	}
+	wg.Wait()
+
+	return devices
+}
+
+// update reconciles the tracked devices with the devices found in a discovery round
+func (m *Manager) update(found []*Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	seen := make(map[string]bool, len(found))
+
+	for _, device := range found {
+		// A device reachable under several addresses is only tracked once
+		if seen[device.MAC] {
+			continue
+		}
+		seen[device.MAC] = true
+
+		if !m.filter.Match(device) {
+			m.logger.WithFields(logrus.Fields{
+				"device": device.URL,
+				"model":  device.Model,
+				"name":   device.Name,
+			}).Debug("Discovered device excluded by filter")
+			continue
+		}
+
+		tracked, ok := m.devices[device.MAC]
+		switch {
+		case !ok:
+			m.devices[device.MAC] = &trackedDevice{device: device, lastSeen: now}
+			m.logger.WithFields(logrus.Fields{
+				"device": device.URL,
+				"mac":    device.MAC,
+				"model":  device.Model,
+			}).Info("Discovered Shelly device")
+			m.handler.DeviceAdded(device)
+		case tracked.device.URL != device.URL:
+			// The device moved to a new address, replace the old target. The new one is
+			// added first, so that the handler can carry over the state of the device.
+			m.logger.WithFields(logrus.Fields{
+				"mac":  device.MAC,
+				"from": tracked.device.URL,
+				"to":   device.URL,
+			}).Info("Discovered Shelly device changed address")
+			m.handler.DeviceAdded(device)
+			m.handler.DeviceRemoved(tracked.device)
+			tracked.device = device
+			tracked.lastSeen = now
+		default:
+			tracked.device = device
+			tracked.lastSeen = now
+		}
+	}
+
+	for mac, tracked := range m.devices {
+		if now.Sub(tracked.lastSeen) < m.config.Discovery.ExpireAfter {
+			continue
+		}
+		m.logger.WithFields(logrus.Fields{
+			"device": tracked.device.URL,
+			"mac":    mac,
+		}).Info("Discovered Shelly device expired")
+		delete(m.devices, mac)
+		m.handler.DeviceRemoved(tracked.device)
+	}
+}
+
+// Devices returns the currently discovered devices ordered by URL
+func (m *Manager) Devices() []*Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	devices := make([]*Device, 0, len(m.devices))
+	for _, tracked := range m.devices {
+		devices = append(devices, tracked.device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].URL < devices[j].URL })
+	return devices
+}
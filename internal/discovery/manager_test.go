@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// staticSource returns a fixed candidate list
+type staticSource struct {
+	candidates []Candidate
+}
+
+func (s *staticSource) Name() string { return "static" }
+
+func (s *staticSource) Discover(ctx context.Context) ([]Candidate, error) {
+	return s.candidates, nil
+}
+
+// recordingHandler records added and removed devices
+type recordingHandler struct {
+	mu      sync.Mutex
+	added   []string
+	removed []string
+	events  []string
+}
+
+func (h *recordingHandler) DeviceAdded(d *Device) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.added = append(h.added, d.URL)
+	h.events = append(h.events, "add "+d.URL)
+}
+
+func (h *recordingHandler) DeviceRemoved(d *Device) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removed = append(h.removed, d.URL)
+	h.events = append(h.events, "remove "+d.URL)
+}
+
+func newTestManager(handler Handler, source Source, devices map[string]*Device) *Manager {
+	cfg := &config.Config{
+		ScrapeTimeout: time.Second,
+		Discovery: config.DiscoveryConfig{
+			Interval:    time.Minute,
+			ExpireAfter: 3 * time.Minute,
+			Exclude:     config.FilterConfig{Models: []string{"SHHT-1"}},
+		},
+	}
+	m := NewManager(cfg, logrus.New(), handler, source)
+	m.probe = func(ctx context.Context, candidate Candidate) (*Device, error) {
+		device, ok := devices[candidate.URL]
+		if !ok {
+			return nil, errors.New("not a Shelly device")
+		}
+		copied := *device
+		copied.URL = candidate.URL
+		return &copied, nil
+	}
+	return m
+}
+
+func TestManager_Refresh(t *testing.T) {
+	devices := map[string]*Device{
+		"http://192.168.1.100": {MAC: "AA:BB:CC:DD:EE:01", Model: "SPEM-003CEBEU"},
+		"http://192.168.1.101": {MAC: "AA:BB:CC:DD:EE:02", Model: "SHSW-PM"},
+		"http://192.168.1.102": {MAC: "AA:BB:CC:DD:EE:03", Model: "SHHT-1"},
+	}
+	source := &staticSource{candidates: []Candidate{
+		{URL: "http://192.168.1.100"},
+		{URL: "http://192.168.1.101"},
+		{URL: "http://192.168.1.102"},
+		{URL: "http://192.168.1.1"},
+	}}
+	handler := &recordingHandler{}
+
+	m := newTestManager(handler, source, devices)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	m.Refresh(context.Background())
+
+	if len(handler.added) != 2 {
+		t.Fatalf("added = %v, want 2 devices", handler.added)
+	}
+	if got := m.Devices(); len(got) != 2 || got[0].URL != "http://192.168.1.100" || got[1].URL != "http://192.168.1.101" {
+		t.Errorf("Devices() = %v", got)
+	}
+
+	// A second round does not add the devices again
+	m.Refresh(context.Background())
+	if len(handler.added) != 2 || len(handler.removed) != 0 {
+		t.Errorf("added = %v, removed = %v after second round", handler.added, handler.removed)
+	}
+}
+
+func TestManager_Refresh_AddressChange(t *testing.T) {
+	devices := map[string]*Device{
+		"http://192.168.1.100": {MAC: "AA:BB:CC:DD:EE:01", Model: "SHSW-PM"},
+		"http://192.168.1.200": {MAC: "AA:BB:CC:DD:EE:01", Model: "SHSW-PM"},
+	}
+	source := &staticSource{candidates: []Candidate{{URL: "http://192.168.1.100"}}}
+	handler := &recordingHandler{}
+
+	m := newTestManager(handler, source, devices)
+	m.Refresh(context.Background())
+
+	source.candidates = []Candidate{{URL: "http://192.168.1.200"}}
+	m.Refresh(context.Background())
+
+	if len(handler.removed) != 1 || handler.removed[0] != "http://192.168.1.100" {
+		t.Errorf("removed = %v, want [http://192.168.1.100]", handler.removed)
+	}
+	if len(handler.added) != 2 || handler.added[1] != "http://192.168.1.200" {
+		t.Errorf("added = %v, want second device at new address", handler.added)
+	}
+	if got := m.Devices(); len(got) != 1 {
+		t.Errorf("Devices() = %v, want one device", got)
+	}
+
+	// The new address is added first so that the state of the device can be carried over
+	want := []string{"add http://192.168.1.100", "add http://192.168.1.200", "remove http://192.168.1.100"}
+	if !slices.Equal(handler.events, want) {
+		t.Errorf("events = %v, want %v", handler.events, want)
+	}
+}
+
+func TestManager_Refresh_Expiry(t *testing.T) {
+	devices := map[string]*Device{
+		"http://192.168.1.100": {MAC: "AA:BB:CC:DD:EE:01", Model: "SHSW-PM"},
+	}
+	source := &staticSource{candidates: []Candidate{{URL: "http://192.168.1.100"}}}
+	handler := &recordingHandler{}
+
+	m := newTestManager(handler, source, devices)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	m.Refresh(context.Background())
+
+	// Device disappears but is kept until it expires
+	source.candidates = nil
+	now = now.Add(2 * time.Minute)
+	m.Refresh(context.Background())
+	if len(handler.removed) != 0 {
+		t.Errorf("removed = %v before expiry", handler.removed)
+	}
+
+	now = now.Add(2 * time.Minute)
+	m.Refresh(context.Background())
+	if len(handler.removed) != 1 || handler.removed[0] != "http://192.168.1.100" {
+		t.Errorf("removed = %v, want [http://192.168.1.100]", handler.removed)
+	}
+	if len(m.Devices()) != 0 {
+		t.Errorf("Devices() = %v, want none", m.Devices())
+	}
+}
+
+func TestManager_Refresh_Concurrency(t *testing.T) {
+	source := &staticSource{}
+	for i := 0; i < 20; i++ {
+		source.candidates = append(source.candidates, Candidate{URL: "http://10.0.0." + strconv.Itoa(i+1)})
+	}
+
+	handler := &recordingHandler{}
+	m := newTestManager(handler, source, nil)
+	m.concurrency = 3
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	m.probe = func(ctx context.Context, candidate Candidate) (*Device, error) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		// Every address answers with the same device
+		return &Device{URL: candidate.URL, MAC: "AA:BB:CC:DD:EE:01", Model: "SHSW-PM"}, nil
+	}
+
+	m.Refresh(context.Background())
+
+	if peak > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", peak)
+	}
+	if len(handler.added) != 1 || len(handler.removed) != 0 {
+		t.Errorf("added = %v, removed = %v, want a single device tracked by MAC", handler.added, handler.removed)
+	}
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/hashicorp/mdns"
+	"github.com/sirupsen/logrus"
+)
+
+// DNS-SD services advertised by Shelly devices
+const (
+	shellyService = "_shelly._tcp"
+	httpService   = "_http._tcp"
+)
+
+// MDNSSource discovers Shelly devices through mDNS / DNS-SD
+type MDNSSource struct {
+	config config.MDNSConfig
+	logger *logrus.Logger
+	query  func(params *mdns.QueryParam) error
+}
+
+// NewMDNSSource creates a new mDNS discovery source
+func NewMDNSSource(cfg config.MDNSConfig, logger *logrus.Logger) *MDNSSource {
+	return &MDNSSource{
+		config: cfg,
+		logger: logger,
+		query:  mdns.Query,
+	}
+}
+
+// Name implements Source
+func (s *MDNSSource) Name() string {
+	return "mdns"
+}
+
+// Discover implements Source. It browses the Shelly and HTTP services and returns
+// the devices whose instance names identify them as Shelly devices.
+func (s *MDNSSource) Discover(ctx context.Context) ([]Candidate, error) {
+	var iface *net.Interface
+	if s.config.Interface != "" {
+		var err error
+		iface, err = net.InterfaceByName(s.config.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find interface %q: %w", s.config.Interface, err)
+		}
+	}
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		candidates = make(map[string]Candidate)
+		errs       []error
+	)
+
+	for _, service := range []string{shellyService, httpService} {
+		wg.Add(1)
+		go func(service string) {
+			defer wg.Done()
+
+			entries := make(chan *mdns.ServiceEntry, 64)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for entry := range entries {
+					if candidate, ok := entryCandidate(service, entry); ok {
+						mu.Lock()
+						candidates[candidate.URL] = candidate
+						mu.Unlock()
+					}
+				}
+			}()
+
+			err := s.query(&mdns.QueryParam{
+				Service:     service,
+				Domain:      "local",
+				Timeout:     s.config.Timeout,
+				Interface:   iface,
+				Entries:     entries,
+				DisableIPv6: true,
+			})
+			close(entries)
+			<-done
+
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to browse %s: %w", service, err))
+				mu.Unlock()
+			}
+		}(service)
+	}
+	wg.Wait()
+
+	if len(errs) == 2 {
+		return nil, errs[0]
+	}
+	for _, err := range errs {
+		s.logger.WithError(err).Warn("mDNS discovery partially failed")
+	}
+
+	result := make([]Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		result = append(result, candidate)
+	}
+	return result, ctx.Err()
+}
+
+// entryCandidate converts an mDNS service entry into a candidate.
+// Entries of the generic HTTP service are only accepted if their instance name starts with "shelly".
+func entryCandidate(service string, entry *mdns.ServiceEntry) (Candidate, bool) {
+	if entry == nil || entry.AddrV4 == nil {
+		return Candidate{}, false
+	}
+
+	instance := entry.Name
+	if i := strings.Index(instance, "."+service); i >= 0 {
+		instance = instance[:i]
+	}
+	instance = strings.ReplaceAll(instance, `\ `, " ")
+
+	if service == httpService && !strings.HasPrefix(strings.ToLower(instance), "shelly") {
+		return Candidate{}, false
+	}
+
+	host := entry.AddrV4.String()
+	if entry.Port != 0 && entry.Port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(entry.Port))
+	}
+
+	return Candidate{URL: "http://" + host, Name: instance}, true
+}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/hashicorp/mdns"
+	"github.com/sirupsen/logrus"
+)
+
+func TestEntryCandidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		entry   *mdns.ServiceEntry
+		want    Candidate
+		ok      bool
+	}{
+		{
+			name:    "shelly service",
+			service: shellyService,
+			entry:   &mdns.ServiceEntry{Name: "shellypro3em-aabbccddeeff._shelly._tcp.local.", AddrV4: net.ParseIP("192.168.1.100"), Port: 80},
+			want:    Candidate{URL: "http://192.168.1.100", Name: "shellypro3em-aabbccddeeff"},
+			ok:      true,
+		},
+		{
+			name:    "http service with shelly name",
+			service: httpService,
+			entry:   &mdns.ServiceEntry{Name: "shelly1pm-A4CF12F45678._http._tcp.local.", AddrV4: net.ParseIP("192.168.1.101"), Port: 8080},
+			want:    Candidate{URL: "http://192.168.1.101:8080", Name: "shelly1pm-A4CF12F45678"},
+			ok:      true,
+		},
+		{
+			name:    "http service with other name",
+			service: httpService,
+			entry:   &mdns.ServiceEntry{Name: "printer._http._tcp.local.", AddrV4: net.ParseIP("192.168.1.50"), Port: 80},
+			ok:      false,
+		},
+		{
+			name:    "no ipv4 address",
+			service: shellyService,
+			entry:   &mdns.ServiceEntry{Name: "shellyplus1-aabbcc._shelly._tcp.local.", AddrV6: net.ParseIP("fe80::1"), Port: 80},
+			ok:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := entryCandidate(tt.service, tt.entry)
+			if ok != tt.ok {
+				t.Fatalf("entryCandidate() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != tt.want {
+				t.Errorf("entryCandidate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMDNSSource_Discover(t *testing.T) {
+	source := NewMDNSSource(config.MDNSConfig{Enabled: true, Timeout: time.Second}, logrus.New())
+	source.query = func(params *mdns.QueryParam) error {
+		switch params.Service {
+		case shellyService:
+			params.Entries <- &mdns.ServiceEntry{Name: "shellypro3em-aabbccddeeff._shelly._tcp.local.", AddrV4: net.ParseIP("192.168.1.100"), Port: 80}
+		case httpService:
+			params.Entries <- &mdns.ServiceEntry{Name: "shellypro3em-aabbccddeeff._http._tcp.local.", AddrV4: net.ParseIP("192.168.1.100"), Port: 80}
+			params.Entries <- &mdns.ServiceEntry{Name: "shelly1pm-A4CF12F45678._http._tcp.local.", AddrV4: net.ParseIP("192.168.1.101"), Port: 80}
+			params.Entries <- &mdns.ServiceEntry{Name: "nas._http._tcp.local.", AddrV4: net.ParseIP("192.168.1.2"), Port: 80}
+		}
+		return nil
+	}
+
+	candidates, err := source.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+
+	var urls []string
+	for _, candidate := range candidates {
+		urls = append(urls, candidate.URL)
+	}
+	sort.Strings(urls)
+
+	want := []string{"http://192.168.1.100", "http://192.168.1.101"}
+	if len(urls) != len(want) || urls[0] != want[0] || urls[1] != want[1] {
+		t.Errorf("Discover() = %v, want %v", urls, want)
+	}
+}
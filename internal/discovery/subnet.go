@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+)
+
+// SubnetSource discovers Shelly devices by scanning IPv4 subnets.
+// Every host address of the configured CIDRs is returned as a candidate and confirmed by probing.
+type SubnetSource struct {
+	prefixes []netip.Prefix
+}
+
+// NewSubnetSource creates a new subnet scan source from CIDR strings
+func NewSubnetSource(subnets []string) (*SubnetSource, error) {
+	source := &SubnetSource{}
+	for _, subnet := range subnets {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+		if !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid subnet %q: only IPv4 is supported", subnet)
+		}
+		source.prefixes = append(source.prefixes, prefix.Masked())
+	}
+	return source, nil
+}
+
+// Name implements Source
+func (s *SubnetSource) Name() string {
+	return "subnet"
+}
+
+// Discover implements Source
+func (s *SubnetSource) Discover(ctx context.Context) ([]Candidate, error) {
+	var candidates []Candidate
+	for _, prefix := range s.prefixes {
+		for _, addr := range hosts(prefix) {
+			candidates = append(candidates, Candidate{URL: "http://" + addr.String()})
+		}
+		if err := ctx.Err(); err != nil {
+			return candidates, err
+		}
+	}
+	return candidates, nil
+}
+
+// hosts returns the host addresses of an IPv4 prefix, excluding the network and
+// broadcast addresses for prefixes that have them
+func hosts(prefix netip.Prefix) []netip.Addr {
+	var addrs []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr)
+		if !addr.Next().IsValid() {
+			break
+		}
+	}
+
+	if prefix.Bits() < 31 && len(addrs) > 2 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+	return addrs
+}
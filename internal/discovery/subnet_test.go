@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestNewSubnetSource(t *testing.T) {
+	if _, err := NewSubnetSource([]string{"192.168.1.0/24", "10.0.0.5/32"}); err != nil {
+		t.Errorf("NewSubnetSource() error = %v", err)
+	}
+
+	for _, subnet := range []string{"192.168.1.0", "fd00::/64", "invalid"} {
+		if _, err := NewSubnetSource([]string{subnet}); err == nil {
+			t.Errorf("NewSubnetSource(%q) expected error, got nil", subnet)
+		}
+	}
+}
+
+func TestSubnetSource_Discover(t *testing.T) {
+	source, err := NewSubnetSource([]string{"192.168.1.17/30", "10.0.0.5/32"})
+	if err != nil {
+		t.Fatalf("NewSubnetSource() error = %v", err)
+	}
+
+	candidates, err := source.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+
+	want := []string{"http://192.168.1.17", "http://192.168.1.18", "http://10.0.0.5"}
+	if len(candidates) != len(want) {
+		t.Fatalf("Discover() = %v, want %v", candidates, want)
+	}
+	for i, candidate := range candidates {
+		if candidate.URL != want[i] {
+			t.Errorf("Discover()[%d] = %v, want %v", i, candidate.URL, want[i])
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	tests := []struct {
+		prefix string
+		count  int
+		first  string
+		last   string
+	}{
+		{prefix: "192.168.1.0/24", count: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{prefix: "192.168.1.0/31", count: 2, first: "192.168.1.0", last: "192.168.1.1"},
+		{prefix: "192.168.1.9/32", count: 1, first: "192.168.1.9", last: "192.168.1.9"},
+		{prefix: "255.255.255.252/30", count: 2, first: "255.255.255.253", last: "255.255.255.254"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			addrs := hosts(netip.MustParsePrefix(tt.prefix))
+			if len(addrs) != tt.count {
+				t.Fatalf("hosts() count = %d, want %d", len(addrs), tt.count)
+			}
+			if addrs[0].String() != tt.first || addrs[len(addrs)-1].String() != tt.last {
+				t.Errorf("hosts() = %v..%v, want %v..%v", addrs[0], addrs[len(addrs)-1], tt.first, tt.last)
+			}
+		})
+	}
+}
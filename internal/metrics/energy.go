@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
+)
+
+// energyStateKey is the key of the monotonic energy counters in the state store
+const energyStateKey = "energy"
+
+// energyCounter is an exporter maintained energy total of a meter that keeps increasing when
+// the total reported by the device is reset
+type energyCounter struct {
+	// Total is the monotonic total in watt-hours
+	Total float64 `json:"total"`
+	// Last is the total last reported by the device
+	Last float64 `json:"last"`
+	// Uptime is the uptime of the device when Last was reported
+	Uptime int `json:"uptime"`
+}
+
+// update adds the energy reported since the last update and returns the monotonic total.
+// The device total was reset if it decreased, or if the device rebooted and its total does
+// not survive reboots, in which case all of it was consumed since the reset. Reports without
+// an uptime, such as CoIoT updates, are not taken as a reboot and keep the last uptime.
+func (e *energyCounter) update(total float64, uptime int, resetsOnReboot bool) float64 {
+	delta := total - e.Last
+	if total < e.Last || (resetsOnReboot && uptime > 0 && uptime < e.Uptime) {
+		delta = total
+	}
+
+	e.Total += delta
+	e.Last = total
+	if uptime > 0 {
+		e.Uptime = uptime
+	}
+	return e.Total
+}
+
+// energyCounters holds the monotonic energy counters of all devices by device and meter
+type energyCounters struct {
+	store    state.Store
+	counters map[string]map[string]*energyCounter
+	dirty    bool
+
+	mu sync.Mutex
+}
+
+// loadEnergyCounters reads the counters from the state store. The returned counters are
+// usable even if an error is returned.
+func loadEnergyCounters(store state.Store) (*energyCounters, error) {
+	e := &energyCounters{
+		store:    store,
+		counters: make(map[string]map[string]*energyCounter),
+	}
+
+	if _, err := store.Load(energyStateKey, &e.counters); err != nil {
+		e.counters = make(map[string]map[string]*energyCounter)
+		return e, err
+	}
+	return e, nil
+}
+
+// update updates the counter of a meter with the total reported by the device and returns
+// the monotonic total. The first total of a meter is taken over as is.
+func (e *energyCounters) update(device, meter string, total float64, uptime int, resetsOnReboot bool) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meters, ok := e.counters[device]
+	if !ok {
+		meters = make(map[string]*energyCounter)
+		e.counters[device] = meters
+	}
+
+	counter, ok := meters[meter]
+	if !ok {
+		counter = &energyCounter{Total: total, Last: total, Uptime: uptime}
+		meters[meter] = counter
+		e.dirty = true
+		return counter.Total
+	}
+
+	if counter.Last != total || (uptime > 0 && counter.Uptime != uptime) {
+		e.dirty = true
+	}
+	return counter.update(total, uptime, resetsOnReboot)
+}
+
+// save hands the counters to the state store if they changed since the last save
+func (e *energyCounters) save() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.dirty {
+		return nil
+	}
+	if err := e.store.Save(energyStateKey, e.counters); err != nil {
+		return err
+	}
+
+	e.dirty = false
+	return nil
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+func TestEnergyCounter_Update(t *testing.T) {
+	type report struct {
+		total  float64
+		uptime int
+	}
+
+	tests := []struct {
+		name           string
+		resetsOnReboot bool
+		reports        []report
+		want           []float64
+	}{
+		{
+			name:    "increasing",
+			reports: []report{{110, 20}, {125, 30}},
+			want:    []float64{110, 125},
+		},
+		{
+			name:    "counter reset by the user",
+			reports: []report{{20, 20}, {5, 30}},
+			want:    []float64{120, 125},
+		},
+		{
+			name:    "reboot of a device keeping its total",
+			reports: []report{{110, 5}, {120, 15}},
+			want:    []float64{110, 120},
+		},
+		{
+			name:           "reboot of a device counting from 0",
+			resetsOnReboot: true,
+			reports:        []report{{103, 5}, {110, 15}},
+			want:           []float64{203, 210},
+		},
+		{
+			name:           "no reboot of a device counting from 0",
+			resetsOnReboot: true,
+			reports:        []report{{110, 20}},
+			want:           []float64{110},
+		},
+		{
+			name:           "polled and CoIoT reports without uptime",
+			resetsOnReboot: true,
+			reports:        []report{{110, 20}, {110.5, 0}, {112, 30}, {112.5, 0}, {113, 0}, {115, 40}},
+			want:           []float64{110, 110.5, 112, 112.5, 113, 115},
+		},
+		{
+			name:           "reboot between CoIoT reports",
+			resetsOnReboot: true,
+			reports:        []report{{110, 20}, {111, 0}, {2, 5}, {3, 0}},
+			want:           []float64{110, 111, 113, 114},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &energyCounter{Total: 100, Last: 100, Uptime: 10}
+			for i, r := range tt.reports {
+				if got := counter.update(r.total, r.uptime, tt.resetsOnReboot); got != tt.want[i] {
+					t.Errorf("update(%v, %v) = %v, want %v", r.total, r.uptime, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEnergyCounters_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	logger := logrus.New()
+
+	store, err := state.Open(dir, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	counters, err := loadEnergyCounters(store)
+	if err != nil {
+		t.Fatalf("loadEnergyCounters() error = %v", err)
+	}
+	if got := counters.update("http://shelly.test", "total", 100, 10, true); got != 100 {
+		t.Errorf("update() = %v, want 100", got)
+	}
+	if err := counters.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	// The device rebooted while the exporter was restarted
+	store, err = state.Open(dir, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	counters, err = loadEnergyCounters(store)
+	if err != nil {
+		t.Fatalf("loadEnergyCounters() error = %v", err)
+	}
+	if got := counters.update("http://shelly.test", "total", 4, 2, true); got != 104 {
+		t.Errorf("update() after restart = %v, want 104", got)
+	}
+}
+
+func TestEnergyCounters_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, energyStateKey+".json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+
+	store, err := state.Open(dir, logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	counters, err := loadEnergyCounters(store)
+	if err == nil {
+		t.Error("loadEnergyCounters() expected error for corrupt state")
+	}
+	if got := counters.update("http://shelly.test", "total", 50, 10, false); got != 50 {
+		t.Errorf("update() = %v, want 50", got)
+	}
+	if err := counters.save(); err != nil {
+		t.Errorf("save() error = %v", err)
+	}
+}
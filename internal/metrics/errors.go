@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+)
+
+// Reasons of failed scrapes reported by shelly_scrape_errors_total
+const (
+	reasonTimeout    = "timeout"
+	reasonAuth       = "auth"
+	reasonDNS        = "dns"
+	reasonRefused    = "refused"
+	reasonDecode     = "decode"
+	reasonHTTPStatus = "http_status"
+	reasonOther      = "other"
+)
+
+// errorReasons lists all reasons in the order they are reported
+var errorReasons = []string{
+	reasonTimeout,
+	reasonAuth,
+	reasonDNS,
+	reasonRefused,
+	reasonDecode,
+	reasonHTTPStatus,
+	reasonOther,
+}
+
+// errorReason classifies the error of a failed scrape
+func errorReason(err error) string {
+	var (
+		statusErr *client.StatusError
+		rpcErr    *client.RPCError
+		dnsErr    *net.DNSError
+		netErr    net.Error
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.Is(err, client.ErrAuthRequired):
+		return reasonAuth
+	case errors.As(err, &statusErr):
+		if statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden {
+			return reasonAuth
+		}
+		return reasonHTTPStatus
+	case errors.As(err, &rpcErr):
+		// Shelly RPC error codes follow the HTTP status codes
+		if rpcErr.Code == http.StatusUnauthorized {
+			return reasonAuth
+		}
+		return reasonHTTPStatus
+	case errors.As(err, &dnsErr):
+		return reasonDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonRefused
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return reasonDecode
+	default:
+		return reasonOther
+	}
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+)
+
+func TestErrorReason(t *testing.T) {
+	syntaxErr := json.Unmarshal([]byte("{"), &struct{}{})
+
+	// Errors as returned by http.Client.Do
+	urlError := func(err error) error {
+		return fmt.Errorf(client.ErrMsgExecuteRequest, &url.Error{Op: "Get", URL: "http://192.168.1.100/status", Err: err})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "auth required",
+			err:  client.ErrAuthRequired,
+			want: reasonAuth,
+		},
+		{
+			name: "invalid credentials",
+			err:  fmt.Errorf("%w: invalid credentials", client.ErrAuthRequired),
+			want: reasonAuth,
+		},
+		{
+			name: "legacy unauthorized",
+			err:  &client.StatusError{StatusCode: 401},
+			want: reasonAuth,
+		},
+		{
+			name: "status code",
+			err:  &client.StatusError{StatusCode: 500},
+			want: reasonHTTPStatus,
+		},
+		{
+			name: "rpc error",
+			err:  &client.RPCError{Code: 404, Message: "No handler for Shelly.GetStatus"},
+			want: reasonHTTPStatus,
+		},
+		{
+			name: "dns",
+			err:  urlError(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "shelly.invalid", IsNotFound: true}}),
+			want: reasonDNS,
+		},
+		{
+			name: "deadline exceeded",
+			err:  urlError(context.DeadlineExceeded),
+			want: reasonTimeout,
+		},
+		{
+			name: "dial timeout",
+			err:  urlError(&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}),
+			want: reasonTimeout,
+		},
+		{
+			name: "connection refused",
+			err:  urlError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}),
+			want: reasonRefused,
+		},
+		{
+			name: "syntax error",
+			err:  fmt.Errorf("failed to decode JSON response: %w", syntaxErr),
+			want: reasonDecode,
+		},
+		{
+			name: "truncated response",
+			err:  fmt.Errorf("failed to decode JSON response: %w", io.ErrUnexpectedEOF),
+			want: reasonDecode,
+		},
+		{
+			name: "other",
+			err:  errors.New("response id 2 does not match request id 1"),
+			want: reasonOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorReason(tt.err); got != tt.want {
+				t.Errorf("errorReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
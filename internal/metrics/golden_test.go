@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// update rewrites the golden files from the current output:
+//
+//	go test ./internal/metrics -run TestCollector_Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	// fixturesDir holds one directory of device responses per model and firmware, in the
+	// layout written by the dump command
+	fixturesDir = "testdata/fixtures"
+	// goldenDir holds the expected exposition of every fixture
+	goldenDir = "testdata/golden"
+	// goldenDevice replaces the URL of the fixture server in the golden files
+	goldenDevice = "http://shelly.test"
+)
+
+// volatileMetrics change with every scrape and are left out of the golden files
+var volatileMetrics = map[string]bool{
+	"shelly_scrape_duration_seconds":                  true,
+	"shelly_last_successful_scrape_timestamp_seconds": true,
+}
+
+// fixtureHandler serves the responses of a fixture directory like the device they were
+// captured from. /shelly, /status and /settings are served from the JSON files of the same
+// name, GET /rpc/<method> and JSON-RPC POSTs to /rpc from <method>.json. Everything else is
+// not found, so Gen1 fixtures fall back to the legacy API like the real devices.
+func fixtureHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			file    string
+			request struct {
+				ID     uint64 `json:"id"`
+				Method string `json:"method"`
+			}
+		)
+		switch {
+		case r.URL.Path == "/shelly", r.URL.Path == "/status", r.URL.Path == "/settings":
+			file = strings.TrimPrefix(r.URL.Path, "/") + ".json"
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/rpc/"):
+			file = strings.TrimPrefix(r.URL.Path, "/rpc/") + ".json"
+		case r.Method == http.MethodPost && r.URL.Path == "/rpc":
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Method == "" {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			file = request.Method + ".json"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, filepath.Base(file)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if request.Method != "" {
+			data, err = json.Marshal(map[string]interface{}{
+				"id":     request.ID,
+				"src":    "fixture",
+				"result": json.RawMessage(data),
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+}
+
+// collectFixture collects the metrics of a fixture and returns them in the text exposition format
+func collectFixture(t *testing.T, dir string) []byte {
+	t.Helper()
+
+	server := httptest.NewServer(fixtureHandler(dir))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: 5 * time.Second}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+	store, err := state.Open("", logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := collector.EnableMonotonicEnergy(store); err != nil {
+		t.Fatalf("EnableMonotonicEnergy() error = %v", err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		if volatileMetrics[family.GetName()] {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatalf("MetricFamilyToText() error = %v", err)
+		}
+	}
+
+	// The server listens on a random port
+	return bytes.ReplaceAll(buf.Bytes(), []byte(server.URL), []byte(goldenDevice))
+}
+
+// diffLines returns the lines only present in want (-) or got (+)
+func diffLines(want, got []byte) string {
+	count := func(data []byte) map[string]int {
+		lines := make(map[string]int)
+		for _, line := range strings.Split(string(data), "\n") {
+			lines[line]++
+		}
+		return lines
+	}
+	wantLines, gotLines := count(want), count(got)
+
+	var diff strings.Builder
+	for _, line := range strings.Split(string(want), "\n") {
+		if gotLines[line] > 0 {
+			gotLines[line]--
+			continue
+		}
+		fmt.Fprintf(&diff, "- %s\n", line)
+	}
+	for _, line := range strings.Split(string(got), "\n") {
+		if wantLines[line] > 0 {
+			wantLines[line]--
+			continue
+		}
+		fmt.Fprintf(&diff, "+ %s\n", line)
+	}
+	return diff.String()
+}
+
+func TestCollector_Golden(t *testing.T) {
+	entries, err := os.ReadDir(fixturesDir)
+	if err != nil {
+		t.Fatalf("failed to read fixtures: %v", err)
+	}
+
+	fixtures := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fixtures++
+
+		name := entry.Name()
+		t.Run(name, func(t *testing.T) {
+			got := collectFixture(t, filepath.Join(fixturesDir, name))
+			golden := filepath.Join(goldenDir, name+".prom")
+
+			if *update {
+				if err := os.MkdirAll(goldenDir, 0755); err != nil {
+					t.Fatalf("failed to create golden directory: %v", err)
+				}
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatalf("failed to write golden file: %v", err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("exposition of %s differs from %s (-want +got):\n%s", name, golden, diffLines(want, got))
+			}
+		})
+	}
+
+	if fixtures == 0 {
+		t.Fatalf("no fixtures found in %s", fixturesDir)
+	}
+}
+
+func TestCollector_GoldenFilesHaveFixtures(t *testing.T) {
+	goldens, err := filepath.Glob(filepath.Join(goldenDir, "*.prom"))
+	if err != nil {
+		t.Fatalf("failed to list golden files: %v", err)
+	}
+
+	for _, golden := range goldens {
+		name := strings.TrimSuffix(filepath.Base(golden), ".prom")
+		if _, err := os.Stat(filepath.Join(fixturesDir, name)); err != nil {
+			t.Errorf("golden file %s has no fixture: %v", golden, err)
+		}
+	}
+}
@@ -3,14 +3,31 @@ package metrics
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
+// temperatureStatuses are the temperature statuses of Gen1 devices
+var temperatureStatuses = []string{"Normal", "High", "Very High"}
+
+// Firmware release channels
+const (
+	firmwareStable = "stable"
+	firmwareBeta   = "beta"
+)
+
+// firmwareChannels lists the firmware release channels in the order they are reported
+var firmwareChannels = []string{firmwareStable, firmwareBeta}
+
 // Collector collects metrics from Shelly devices
 type Collector struct {
 	clients []*client.Client
@@ -19,6 +36,7 @@ type Collector struct {
 	// Device metrics
 	deviceInfo *prometheus.Desc
 	deviceUp   *prometheus.Desc
+	dataAge    *prometheus.Desc
 
 	// WiFi metrics
 	wifiConnected *prometheus.Desc
@@ -29,176 +47,318 @@ type Collector struct {
 	relayOverpower *prometheus.Desc
 
 	// Power meter metrics
-	powerWatts     *prometheus.Desc
-	powerOverpower *prometheus.Desc
-	energyTotal    *prometheus.Desc
+	powerWatts      *prometheus.Desc
+	powerOverpower  *prometheus.Desc
+	energyTotal     *prometheus.Desc
+	energyMonotonic *prometheus.Desc
 
 	// Temperature metrics
-	temperature     *prometheus.Desc
-	overtemperature *prometheus.Desc
+	temperature       *prometheus.Desc
+	overtemperature   *prometheus.Desc
+	temperatureStatus *prometheus.Desc
 
 	// System metrics
-	uptime  *prometheus.Desc
-	ramFree *prometheus.Desc
-	ramSize *prometheus.Desc
-	fsFree  *prometheus.Desc
-	fsSize  *prometheus.Desc
+	uptime          *prometheus.Desc
+	reboots         *prometheus.Desc
+	resetReason     *prometheus.Desc
+	restartRequired *prometheus.Desc
+	ramFree         *prometheus.Desc
+	ramSize         *prometheus.Desc
+	fsFree          *prometheus.Desc
+	fsSize          *prometheus.Desc
 
 	// Cloud and MQTT metrics
 	cloudConnected *prometheus.Desc
 	mqttConnected  *prometheus.Desc
 
 	// Update metrics
-	updateAvailable *prometheus.Desc
+	updateAvailable         *prometheus.Desc
+	firmwareInfo            *prometheus.Desc
+	firmwareUpdateAvailable *prometheus.Desc
+	firmwareUpdateDevices   *prometheus.Desc
+
+	// Scrape health metrics
+	scrapeDuration *prometheus.Desc
+	scrapeErrors   *prometheus.Desc
+	lastSuccess    *prometheus.Desc
+	scrapeAPI      *prometheus.Desc
+
+	// specs holds the definitions of the descriptors above
+	specs map[*prometheus.Desc]descSpec
+
+	// health holds the scrape history of every device by device ID
+	health map[string]*scrapeHealth
+
+	// energy holds the monotonic energy counters, nil if they are disabled
+	energy *energyCounters
+
+	// rebootCounts holds the reboots seen of every device
+	rebootCounts *rebootCounters
+
+	// maxStaleness is how long the last status of a device is served after its scrapes
+	// started failing, 0 disables serving stale values
+	maxStaleness time.Duration
 
 	mu sync.RWMutex
 }
 
+// scrapeHealth is the scrape history of a device
+type scrapeHealth struct {
+	errors      map[string]float64
+	lastSuccess time.Time
+	// status is the status of the last successful scrape
+	status *client.StatusResponse
+}
+
+// descSpec is the definition of a metric descriptor
+type descSpec struct {
+	name   string
+	help   string
+	labels []string
+}
+
 // NewCollector creates a new metrics collector
 func NewCollector(clients []*client.Client, logger *logrus.Logger) *Collector {
-	return &Collector{
+	c := &Collector{
 		clients: clients,
 		logger:  logger,
+		specs:   make(map[*prometheus.Desc]descSpec),
+		health:  make(map[string]*scrapeHealth),
+
+		rebootCounts: newRebootCounters(),
+	}
+
+	c.deviceInfo = c.newDesc(
+		"shelly_device_info",
+		"Information about the Shelly device",
+		"device", "mac", "serial", "firmware",
+	)
+
+	c.deviceUp = c.newDesc(
+		"shelly_device_up",
+		"Whether the Shelly device is responding",
+		"device",
+	)
+
+	c.dataAge = c.newDesc(
+		"shelly_device_data_age_seconds",
+		"Seconds since the values of the Shelly device were fetched",
+		"device",
+	)
+
+	c.wifiConnected = c.newDesc(
+		"shelly_wifi_connected",
+		"Whether the Shelly device is connected to WiFi",
+		"device", "ssid", "ip",
+	)
+
+	c.wifiRSSI = c.newDesc(
+		"shelly_wifi_rssi_dbm",
+		"WiFi signal strength in dBm",
+		"device",
+	)
+
+	c.relayState = c.newDesc(
+		"shelly_relay_state",
+		"State of the relay (1 = on, 0 = off)",
+		"device", "relay",
+	)
+
+	c.relayOverpower = c.newDesc(
+		"shelly_relay_overpower",
+		"Whether the relay is overpowered",
+		"device", "relay",
+	)
+
+	c.powerWatts = c.newDesc(
+		"shelly_power_watts",
+		"Current power consumption in watts",
+		"device", "meter",
+	)
+
+	c.powerOverpower = c.newDesc(
+		"shelly_power_overpower",
+		"Whether the power meter is overpowered",
+		"device", "meter",
+	)
+
+	c.energyTotal = c.newDesc(
+		"shelly_energy_total_watthours",
+		"Total energy consumption in watt-hours",
+		"device", "meter",
+	)
+
+	c.energyMonotonic = c.newDesc(
+		"shelly_energy_monotonic_total_watthours",
+		"Total energy consumption in watt-hours maintained by the exporter across resets of the device counter",
+		"device", "meter",
+	)
+
+	c.temperature = c.newDesc(
+		"shelly_temperature_celsius",
+		"Device temperature in Celsius",
+		"device",
+	)
+
+	c.overtemperature = c.newDesc(
+		"shelly_overtemperature",
+		"Whether the device is overtemperature",
+		"device",
+	)
+
+	c.temperatureStatus = c.newDesc(
+		"shelly_temperature_status",
+		"Temperature status reported by the Gen1 Shelly device, 1 for the current status",
+		"device", "status",
+	)
+
+	c.uptime = c.newDesc(
+		"shelly_uptime_seconds",
+		"Device uptime in seconds",
+		"device",
+	)
+
+	c.reboots = c.newDesc(
+		"shelly_device_reboots_total",
+		"Number of reboots of the Shelly device seen by the exporter as a decrease of its uptime",
+		"device",
+	)
+
+	c.resetReason = c.newDesc(
+		"shelly_device_last_reset_reason",
+		"Reason of the last reset of the Shelly device",
+		"device", "code", "reason",
+	)
+
+	c.restartRequired = c.newDesc(
+		"shelly_restart_required",
+		"Whether the Shelly device needs a restart to apply configuration changes",
+		"device",
+	)
+
+	c.ramFree = c.newDesc(
+		"shelly_ram_free_bytes",
+		"Free RAM in bytes",
+		"device",
+	)
+
+	c.ramSize = c.newDesc(
+		"shelly_ram_size_bytes",
+		"Total RAM size in bytes",
+		"device",
+	)
+
+	c.fsFree = c.newDesc(
+		"shelly_filesystem_free_bytes",
+		"Free filesystem space in bytes",
+		"device",
+	)
+
+	c.fsSize = c.newDesc(
+		"shelly_filesystem_size_bytes",
+		"Total filesystem size in bytes",
+		"device",
+	)
+
+	c.cloudConnected = c.newDesc(
+		"shelly_cloud_connected",
+		"Whether the device is connected to Shelly Cloud",
+		"device",
+	)
+
+	c.mqttConnected = c.newDesc(
+		"shelly_mqtt_connected",
+		"Whether the device is connected to MQTT",
+		"device",
+	)
+
+	c.updateAvailable = c.newDesc(
+		"shelly_update_available",
+		"Whether a firmware update is available",
+		"device",
+	)
+
+	c.firmwareInfo = c.newDesc(
+		"shelly_firmware_info",
+		"Installed firmware of the Shelly device and the newer firmware offered on each release channel",
+		"device", "current", "stable", "beta",
+	)
+
+	c.firmwareUpdateAvailable = c.newDesc(
+		"shelly_firmware_update_available",
+		"Whether the Shelly device offers a firmware update on the release channel",
+		"device", "channel",
+	)
+
+	c.firmwareUpdateDevices = c.newDesc(
+		"shelly_firmware_update_available_devices",
+		"Number of responding Shelly devices offering a firmware update on the release channel",
+		"channel",
+	)
+
+	c.scrapeDuration = c.newDesc(
+		"shelly_scrape_duration_seconds",
+		"Duration of the last scrape of the device in seconds",
+		"device",
+	)
+
+	c.scrapeErrors = c.newDesc(
+		"shelly_scrape_errors_total",
+		"Total number of failed scrapes of the device by reason",
+		"device", "reason",
+	)
+
+	c.lastSuccess = c.newDesc(
+		"shelly_last_successful_scrape_timestamp_seconds",
+		"Unix time of the last successful scrape of the device",
+		"device",
+	)
 
-		deviceInfo: prometheus.NewDesc(
-			"shelly_device_info",
-			"Information about the Shelly device",
-			[]string{"device", "mac", "serial", "firmware"},
-			nil,
-		),
-
-		deviceUp: prometheus.NewDesc(
-			"shelly_device_up",
-			"Whether the Shelly device is responding",
-			[]string{"device"},
-			nil,
-		),
-
-		wifiConnected: prometheus.NewDesc(
-			"shelly_wifi_connected",
-			"Whether the Shelly device is connected to WiFi",
-			[]string{"device", "ssid", "ip"},
-			nil,
-		),
-
-		wifiRSSI: prometheus.NewDesc(
-			"shelly_wifi_rssi_dbm",
-			"WiFi signal strength in dBm",
-			[]string{"device"},
-			nil,
-		),
-
-		relayState: prometheus.NewDesc(
-			"shelly_relay_state",
-			"State of the relay (1 = on, 0 = off)",
-			[]string{"device", "relay"},
-			nil,
-		),
-
-		relayOverpower: prometheus.NewDesc(
-			"shelly_relay_overpower",
-			"Whether the relay is overpowered",
-			[]string{"device", "relay"},
-			nil,
-		),
-
-		powerWatts: prometheus.NewDesc(
-			"shelly_power_watts",
-			"Current power consumption in watts",
-			[]string{"device", "meter"},
-			nil,
-		),
-
-		powerOverpower: prometheus.NewDesc(
-			"shelly_power_overpower",
-			"Whether the power meter is overpowered",
-			[]string{"device", "meter"},
-			nil,
-		),
-
-		energyTotal: prometheus.NewDesc(
-			"shelly_energy_total_watthours",
-			"Total energy consumption in watt-hours",
-			[]string{"device", "meter"},
-			nil,
-		),
-
-		temperature: prometheus.NewDesc(
-			"shelly_temperature_celsius",
-			"Device temperature in Celsius",
-			[]string{"device"},
-			nil,
-		),
-
-		overtemperature: prometheus.NewDesc(
-			"shelly_overtemperature",
-			"Whether the device is overtemperature",
-			[]string{"device"},
-			nil,
-		),
-
-		uptime: prometheus.NewDesc(
-			"shelly_uptime_seconds",
-			"Device uptime in seconds",
-			[]string{"device"},
-			nil,
-		),
-
-		ramFree: prometheus.NewDesc(
-			"shelly_ram_free_bytes",
-			"Free RAM in bytes",
-			[]string{"device"},
-			nil,
-		),
-
-		ramSize: prometheus.NewDesc(
-			"shelly_ram_size_bytes",
-			"Total RAM size in bytes",
-			[]string{"device"},
-			nil,
-		),
-
-		fsFree: prometheus.NewDesc(
-			"shelly_filesystem_free_bytes",
-			"Free filesystem space in bytes",
-			[]string{"device"},
-			nil,
-		),
-
-		fsSize: prometheus.NewDesc(
-			"shelly_filesystem_size_bytes",
-			"Total filesystem size in bytes",
-			[]string{"device"},
-			nil,
-		),
-
-		cloudConnected: prometheus.NewDesc(
-			"shelly_cloud_connected",
-			"Whether the device is connected to Shelly Cloud",
-			[]string{"device"},
-			nil,
-		),
-
-		mqttConnected: prometheus.NewDesc(
-			"shelly_mqtt_connected",
-			"Whether the device is connected to MQTT",
-			[]string{"device"},
-			nil,
-		),
-
-		updateAvailable: prometheus.NewDesc(
-			"shelly_update_available",
-			"Whether a firmware update is available",
-			[]string{"device"},
-			nil,
-		),
+	c.scrapeAPI = c.newDesc(
+		"shelly_scrape_api_info",
+		"API path used for the last successful scrape of the device",
+		"device", "api",
+	)
+
+	return c
+}
+
+// newDesc creates a metric descriptor and remembers its definition so that
+// descriptors carrying device labels can be derived from it
+func (c *Collector) newDesc(name, help string, labels ...string) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labels, nil)
+	c.specs[desc] = descSpec{name: name, help: help, labels: labels}
+	return desc
+}
+
+// constMetric creates a metric for a device. The device labels are attached as constant
+// labels, except those that clash with the variable labels of the metric.
+func (c *Collector) constMetric(desc *prometheus.Desc, deviceLabels map[string]string, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+	spec, ok := c.specs[desc]
+	if !ok || len(deviceLabels) == 0 {
+		return prometheus.MustNewConstMetric(desc, valueType, value, labelValues...)
+	}
+
+	constLabels := make(prometheus.Labels, len(deviceLabels))
+	for name, value := range deviceLabels {
+		if !slices.Contains(spec.labels, name) {
+			constLabels[name] = value
+		}
 	}
+
+	return prometheus.MustNewConstMetric(
+		prometheus.NewDesc(spec.name, spec.help, spec.labels, constLabels),
+		valueType,
+		value,
+		labelValues...,
+	)
 }
 
 // Describe implements prometheus.Collector
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.deviceInfo
 	ch <- c.deviceUp
+	ch <- c.dataAge
 	ch <- c.wifiConnected
 	ch <- c.wifiRSSI
 	ch <- c.relayState
@@ -206,9 +366,14 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.powerWatts
 	ch <- c.powerOverpower
 	ch <- c.energyTotal
+	ch <- c.energyMonotonic
 	ch <- c.temperature
 	ch <- c.overtemperature
+	ch <- c.temperatureStatus
 	ch <- c.uptime
+	ch <- c.reboots
+	ch <- c.resetReason
+	ch <- c.restartRequired
 	ch <- c.ramFree
 	ch <- c.ramSize
 	ch <- c.fsFree
@@ -216,56 +381,228 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.cloudConnected
 	ch <- c.mqttConnected
 	ch <- c.updateAvailable
+	ch <- c.firmwareInfo
+	ch <- c.firmwareUpdateAvailable
+	ch <- c.firmwareUpdateDevices
+	ch <- c.scrapeDuration
+	ch <- c.scrapeErrors
+	ch <- c.lastSuccess
+	ch <- c.scrapeAPI
 }
 
-// Collect implements prometheus.Collector
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+// AddClient adds a device to the collector.
+// It reports false if a client with the same base URL is already collected.
+func (c *Collector) AddClient(cl *client.Client) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.clients {
+		if existing.BaseURL() == cl.BaseURL() {
+			return false
+		}
+	}
+
+	c.clients = append(c.clients, cl)
+	return true
+}
+
+// RemoveClient removes the device with the given base URL from the collector.
+// It reports whether a client was removed.
+func (c *Collector) RemoveClient(baseURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, existing := range c.clients {
+		if existing.BaseURL() == baseURL {
+			c.clients = append(c.clients[:i:i], c.clients[i+1:]...)
+			delete(c.health, existing.ID())
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetClients replaces the collected devices at once. The state kept for devices whose ID is
+// still collected, such as their scrape health, is retained.
+func (c *Collector) SetClients(clients []*client.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clients = append([]*client.Client(nil), clients...)
+
+	ids := make(map[string]bool, len(clients))
+	for _, cl := range clients {
+		ids[cl.ID()] = true
+	}
+	for device := range c.health {
+		if !ids[device] {
+			delete(c.health, device)
+		}
+	}
+}
+
+// SetMaxStaleness sets how long the last values of a device are served while it cannot be
+// scraped. Once they are older, the device is reported down. 0 reports it down right away.
+func (c *Collector) SetMaxStaleness(maxStaleness time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxStaleness = maxStaleness
+}
+
+// EnableMonotonicEnergy exports energy counters that keep increasing when the device resets
+// its total. The counters are kept in the state store and survive restarts of the exporter
+// if the store is persisted. The counters are enabled even if the stored counters cannot be
+// read, counting starts over and the error is returned.
+func (c *Collector) EnableMonotonicEnergy(store state.Store) error {
+	energy, err := loadEnergyCounters(store)
+
+	c.mu.Lock()
+	c.energy = energy
+	c.mu.Unlock()
+
+	return err
+}
+
+// SetStateStore keeps the reboot counters of the devices in the state store, so that they
+// survive restarts of the exporter if the store is persisted. The store is used even if the
+// stored counters cannot be read, counting starts over and the error is returned.
+func (c *Collector) SetStateStore(store state.Store) error {
+	reboots, err := loadRebootCounters(store)
+
+	c.mu.Lock()
+	c.rebootCounts = reboots
+	c.mu.Unlock()
+
+	return err
+}
+
+// energyCounters returns the monotonic energy counters, nil if they are disabled
+func (c *Collector) energyCounters() *energyCounters {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	for _, client := range c.clients {
-		c.collectDeviceMetrics(client, ch)
+	return c.energy
+}
+
+// Clients returns the clients currently collected
+func (c *Collector) Clients() []*client.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]*client.Client(nil), c.clients...)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	updates := make(map[string]float64, len(firmwareChannels))
+	for _, channel := range firmwareChannels {
+		updates[channel] = 0
+	}
+
+	for _, cl := range c.Clients() {
+		status := c.collectDeviceMetrics(cl, ch)
+		if status == nil {
+			continue
+		}
+		for _, channel := range firmwareChannels {
+			if firmwareUpdate(status, channel) != "" {
+				updates[channel]++
+			}
+		}
+	}
+
+	for _, channel := range firmwareChannels {
+		ch <- prometheus.MustNewConstMetric(
+			c.firmwareUpdateDevices,
+			prometheus.GaugeValue,
+			updates[channel],
+			channel,
+		)
+	}
+
+	if energy := c.energyCounters(); energy != nil {
+		if err := energy.save(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save energy counters")
+		}
+	}
+	if err := c.rebootCounters().save(); err != nil {
+		c.logger.WithError(err).Warn("Failed to save reboot counters")
 	}
 }
 
-// collectDeviceMetrics collects metrics for a single device
-func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- prometheus.Metric) {
-	device := client.BaseURL()
+// collectDeviceMetrics collects metrics for a single device and returns the status they were
+// taken from, nil if the device is down
+func (c *Collector) collectDeviceMetrics(cl *client.Client, ch chan<- prometheus.Metric) *client.StatusResponse {
+	device := cl.ID()
+	deviceLabels := cl.Labels()
 
-	// Get device status
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	status, err := client.GetStatus(ctx)
+	// Get device status within the scrape timeout of the device
+	ctx := context.Background()
+	if timeout := cl.Timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	status, err := cl.GetStatus(ctx)
+	lastSuccess := c.collectScrapeHealth(cl, time.Since(start), status, err, ch)
 	if err != nil {
-		c.logger.WithError(err).WithField("device", device).Error("Failed to get device status")
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"device": config.RedactURL(cl.BaseURL()),
+			"reason": errorReason(err),
+		}).Error("Failed to get device status")
+
+		// Serve the last values while they are not too old
+		status = c.staleStatus(device)
+	}
+
+	if !lastSuccess.IsZero() {
+		dataAge := 0.0
+		if err != nil {
+			dataAge = time.Since(lastSuccess).Seconds()
+		}
+		ch <- c.constMetric(
+			c.dataAge,
+			deviceLabels,
+			prometheus.GaugeValue,
+			dataAge,
+			device,
+		)
+	}
 
+	if status == nil {
 		// Report device as down
-		ch <- prometheus.MustNewConstMetric(
+		ch <- c.constMetric(
 			c.deviceUp,
+			deviceLabels,
 			prometheus.GaugeValue,
 			0,
 			device,
 		)
-		return
+		return nil
 	}
 
 	// Report device as up
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.deviceUp,
+		deviceLabels,
 		prometheus.GaugeValue,
 		1,
 		device,
 	)
 
 	// Device info
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.deviceInfo,
+		deviceLabels,
 		prometheus.GaugeValue,
 		1,
 		device,
 		status.Sys.Mac,
 		status.Sys.Mac,
-		status.Sys.AvailableUpdates.Stable.Version,
+		status.FirmwareVersion,
 	)
 
 	// WiFi metrics
@@ -273,8 +610,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 	if status.Wifi.Status == "got ip" {
 		wifiConnected = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.wifiConnected,
+		deviceLabels,
 		prometheus.GaugeValue,
 		wifiConnected,
 		device,
@@ -282,8 +620,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 		status.Wifi.StaIP,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.wifiRSSI,
+		deviceLabels,
 		prometheus.GaugeValue,
 		float64(status.Wifi.RSSI),
 		device,
@@ -297,8 +636,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 		if relay.IsOn {
 			relayState = 1.0
 		}
-		ch <- prometheus.MustNewConstMetric(
+		ch <- c.constMetric(
 			c.relayState,
+			deviceLabels,
 			prometheus.GaugeValue,
 			relayState,
 			device,
@@ -309,8 +649,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 		if relay.Overpower {
 			overpower = 1.0
 		}
-		ch <- prometheus.MustNewConstMetric(
+		ch <- c.constMetric(
 			c.relayOverpower,
+			deviceLabels,
 			prometheus.GaugeValue,
 			overpower,
 			device,
@@ -318,98 +659,177 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 		)
 	}
 
-	// Power meter metrics - Phase A
-	ch <- prometheus.MustNewConstMetric(
-		c.powerWatts,
-		prometheus.GaugeValue,
-		status.EM.AActPower,
-		device,
-		"phase_a",
-	)
+	// Power meter metrics, three-phase meters report every phase and the total
+	if status.HasComponent(client.ComponentEM) {
+		for _, phase := range []struct {
+			meter string
+			power float64
+		}{
+			{"phase_a", status.EM.AActPower},
+			{"phase_b", status.EM.BActPower},
+			{"phase_c", status.EM.CActPower},
+		} {
+			ch <- c.constMetric(
+				c.powerWatts,
+				deviceLabels,
+				prometheus.GaugeValue,
+				phase.power,
+				device,
+				phase.meter,
+			)
+		}
+	}
 
-	// Power meter metrics - Phase B
-	ch <- prometheus.MustNewConstMetric(
-		c.powerWatts,
-		prometheus.GaugeValue,
-		status.EM.BActPower,
-		device,
-		"phase_b",
-	)
-
-	// Power meter metrics - Phase C
-	ch <- prometheus.MustNewConstMetric(
-		c.powerWatts,
-		prometheus.GaugeValue,
-		status.EM.CActPower,
-		device,
-		"phase_c",
-	)
+	// Gen1 power and energy meters and Gen2+ metered switches and power meters
+	meters := status.HasComponent(client.ComponentMeters) || status.HasComponent(client.ComponentEMeters) ||
+		status.HasComponent(client.ComponentPowerMeters)
 
 	// Total power
-	ch <- prometheus.MustNewConstMetric(
-		c.powerWatts,
-		prometheus.GaugeValue,
-		status.EM.TotalActPower,
-		device,
-		"total",
-	)
+	if status.HasComponent(client.ComponentEM) || meters {
+		ch <- c.constMetric(
+			c.powerWatts,
+			deviceLabels,
+			prometheus.GaugeValue,
+			status.EM.TotalActPower,
+			device,
+			"total",
+		)
+	}
 
 	// Energy totals
-	ch <- prometheus.MustNewConstMetric(
-		c.energyTotal,
-		prometheus.CounterValue,
-		status.EMData.TotalAct,
-		device,
-		"total",
-	)
+	if status.HasComponent(client.ComponentEMData) || meters {
+		c.collectEnergy(ch, device, deviceLabels, status, "total", status.EMData.TotalAct)
+	}
+
+	// Devices with several meters also report every channel
+	if len(status.EnergyMeters) > 1 {
+		for i, meter := range status.EnergyMeters {
+			meterName := fmt.Sprintf("meter_%d", i)
+			ch <- c.constMetric(
+				c.powerWatts,
+				deviceLabels,
+				prometheus.GaugeValue,
+				meter.Power,
+				device,
+				meterName,
+			)
+			c.collectEnergy(ch, device, deviceLabels, status, meterName, meter.Total)
+		}
+	}
 
 	// Temperature metrics
-	ch <- prometheus.MustNewConstMetric(
-		c.temperature,
-		prometheus.GaugeValue,
-		status.Temperature.TC,
-		device,
-	)
+	if status.HasComponent(client.ComponentTemperature) {
+		ch <- c.constMetric(
+			c.temperature,
+			deviceLabels,
+			prometheus.GaugeValue,
+			status.Temperature.TC,
+			device,
+		)
 
-	// No overtemperature flag in this API, set to 0
-	ch <- prometheus.MustNewConstMetric(
-		c.overtemperature,
-		prometheus.GaugeValue,
-		0,
-		device,
-	)
+		// All statuses are reported so that changes can be alerted on
+		if status.TemperatureStatus != "" {
+			for _, temperatureStatus := range temperatureStatuses {
+				current := 0.0
+				if strings.EqualFold(status.TemperatureStatus, temperatureStatus) {
+					current = 1.0
+				}
+				ch <- c.constMetric(
+					c.temperatureStatus,
+					deviceLabels,
+					prometheus.GaugeValue,
+					current,
+					device,
+					temperatureStatus,
+				)
+			}
+		}
+	}
+
+	// Only Gen1 devices and Gen2+ switches report the overtemperature protection
+	if status.HasComponent(client.ComponentOvertemperature) {
+		overtemperature := 0.0
+		if status.Overtemperature {
+			overtemperature = 1.0
+		}
+		ch <- c.constMetric(
+			c.overtemperature,
+			deviceLabels,
+			prometheus.GaugeValue,
+			overtemperature,
+			device,
+		)
+	}
 
 	// System metrics
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.uptime,
+		deviceLabels,
 		prometheus.CounterValue,
 		float64(status.Sys.Uptime),
 		device,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
+		c.reboots,
+		deviceLabels,
+		prometheus.CounterValue,
+		c.rebootCounters().count(device),
+		device,
+	)
+
+	// Only Gen2+ devices report the reset reason and pending restarts
+	if status.HasComponent(client.ComponentSys) {
+		ch <- c.constMetric(
+			c.resetReason,
+			deviceLabels,
+			prometheus.GaugeValue,
+			1,
+			device,
+			strconv.Itoa(status.Sys.ResetReason),
+			resetReason(status.Sys.ResetReason),
+		)
+
+		restartRequired := 0.0
+		if status.Sys.RestartRequired {
+			restartRequired = 1.0
+		}
+		ch <- c.constMetric(
+			c.restartRequired,
+			deviceLabels,
+			prometheus.GaugeValue,
+			restartRequired,
+			device,
+		)
+	}
+
+	ch <- c.constMetric(
 		c.ramFree,
+		deviceLabels,
 		prometheus.GaugeValue,
 		float64(status.Sys.RAMFree),
 		device,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.ramSize,
+		deviceLabels,
 		prometheus.GaugeValue,
 		float64(status.Sys.RAMSize),
 		device,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.fsFree,
+		deviceLabels,
 		prometheus.GaugeValue,
 		float64(status.Sys.FSFree),
 		device,
 	)
 
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.fsSize,
+		deviceLabels,
 		prometheus.GaugeValue,
 		float64(status.Sys.FSSize),
 		device,
@@ -420,8 +840,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 	if status.Cloud.Connected {
 		cloudConnected = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.cloudConnected,
+		deviceLabels,
 		prometheus.GaugeValue,
 		cloudConnected,
 		device,
@@ -431,8 +852,9 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 	if status.MQTT.Connected {
 		mqttConnected = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.mqttConnected,
+		deviceLabels,
 		prometheus.GaugeValue,
 		mqttConnected,
 		device,
@@ -443,10 +865,167 @@ func (c *Collector) collectDeviceMetrics(client *client.Client, ch chan<- promet
 	if status.Sys.AvailableUpdates.Stable.Version != "" {
 		updateAvailable = 1.0
 	}
-	ch <- prometheus.MustNewConstMetric(
+	ch <- c.constMetric(
 		c.updateAvailable,
+		deviceLabels,
 		prometheus.GaugeValue,
 		updateAvailable,
 		device,
 	)
+
+	// Firmware metrics
+	ch <- c.constMetric(
+		c.firmwareInfo,
+		deviceLabels,
+		prometheus.GaugeValue,
+		1,
+		device,
+		status.FirmwareVersion,
+		status.Sys.AvailableUpdates.Stable.Version,
+		status.Sys.AvailableUpdates.Beta.Version,
+	)
+	for _, channel := range firmwareChannels {
+		available := 0.0
+		if firmwareUpdate(status, channel) != "" {
+			available = 1.0
+		}
+		ch <- c.constMetric(
+			c.firmwareUpdateAvailable,
+			deviceLabels,
+			prometheus.GaugeValue,
+			available,
+			device,
+			channel,
+		)
+	}
+
+	return status
+}
+
+// firmwareUpdate returns the version of the firmware update offered on a release channel,
+// empty if there is none
+func firmwareUpdate(status *client.StatusResponse, channel string) string {
+	switch channel {
+	case firmwareStable:
+		return status.Sys.AvailableUpdates.Stable.Version
+	case firmwareBeta:
+		return status.Sys.AvailableUpdates.Beta.Version
+	default:
+		return ""
+	}
+}
+
+// rebootCounters returns the reboot counters of the devices
+func (c *Collector) rebootCounters() *rebootCounters {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.rebootCounts
+}
+
+// staleStatus returns the status of the last successful scrape of a device if it is
+// younger than the maximum staleness, or nil
+func (c *Collector) staleStatus(device string) *client.StatusResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	health, ok := c.health[device]
+	if !ok || health.status == nil || time.Since(health.lastSuccess) > c.maxStaleness {
+		return nil
+	}
+	return health.status
+}
+
+// collectEnergy collects the energy total of a meter in watt-hours and, if enabled, its monotonic counterpart
+func (c *Collector) collectEnergy(ch chan<- prometheus.Metric, device string, deviceLabels map[string]string, status *client.StatusResponse, meter string, total float64) {
+	ch <- c.constMetric(
+		c.energyTotal,
+		deviceLabels,
+		prometheus.CounterValue,
+		total,
+		device,
+		meter,
+	)
+
+	// Gen1 power meters count from 0 after every reboot
+	if energy := c.energyCounters(); energy != nil {
+		ch <- c.constMetric(
+			c.energyMonotonic,
+			deviceLabels,
+			prometheus.CounterValue,
+			energy.update(device, meter, total, status.Sys.Uptime, status.HasComponent(client.ComponentMeters)),
+			device,
+			meter,
+		)
+	}
+}
+
+// collectScrapeHealth records the outcome of a scrape and collects the scrape health metrics of a device.
+// It returns the time of the last successful scrape.
+func (c *Collector) collectScrapeHealth(cl *client.Client, duration time.Duration, status *client.StatusResponse, err error, ch chan<- prometheus.Metric) time.Time {
+	device := cl.ID()
+	deviceLabels := cl.Labels()
+
+	c.mu.Lock()
+	health, ok := c.health[device]
+	if !ok {
+		health = &scrapeHealth{errors: make(map[string]float64, len(errorReasons))}
+		c.health[device] = health
+	}
+	if err != nil {
+		health.errors[errorReason(err)]++
+	} else {
+		health.lastSuccess = time.Now()
+		health.status = status
+		c.rebootCounts.observe(device, status.Sys.Uptime)
+	}
+	errorCounts := make(map[string]float64, len(health.errors))
+	for reason, count := range health.errors {
+		errorCounts[reason] = count
+	}
+	lastSuccess := health.lastSuccess
+	c.mu.Unlock()
+
+	ch <- c.constMetric(
+		c.scrapeDuration,
+		deviceLabels,
+		prometheus.GaugeValue,
+		duration.Seconds(),
+		device,
+	)
+
+	// All reasons are reported so that rates start from zero
+	for _, reason := range errorReasons {
+		ch <- c.constMetric(
+			c.scrapeErrors,
+			deviceLabels,
+			prometheus.CounterValue,
+			errorCounts[reason],
+			device,
+			reason,
+		)
+	}
+
+	if !lastSuccess.IsZero() {
+		ch <- c.constMetric(
+			c.lastSuccess,
+			deviceLabels,
+			prometheus.GaugeValue,
+			float64(lastSuccess.UnixNano())/1e9,
+			device,
+		)
+	}
+
+	if api := cl.API(); api != "" {
+		ch <- c.constMetric(
+			c.scrapeAPI,
+			deviceLabels,
+			prometheus.GaugeValue,
+			1,
+			device,
+			api,
+		)
+	}
+
+	return lastSuccess
 }
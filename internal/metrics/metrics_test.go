@@ -2,13 +2,17 @@ package metrics
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aimar/shelly-prometheus-exporter/internal/client"
 	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
@@ -68,7 +72,7 @@ func TestCollector_Describe(t *testing.T) {
 	collector := NewCollector(clients, logger)
 
 	// Create a channel to collect descriptors
-	descChan := make(chan *prometheus.Desc, 20)
+	descChan := make(chan *prometheus.Desc, 40)
 
 	// Call Describe
 	collector.Describe(descChan)
@@ -81,7 +85,7 @@ func TestCollector_Describe(t *testing.T) {
 	}
 
 	// Check that we got the expected number of descriptors
-	expectedCount := 19 // Total number of metric descriptors
+	expectedCount := 32 // Total number of metric descriptors
 	if len(descriptors) != expectedCount {
 		t.Errorf("Describe() returned %d descriptors, want %d", len(descriptors), expectedCount)
 	}
@@ -97,28 +101,24 @@ func TestCollector_Collect_Success(t *testing.T) {
 	// Mock RPC API response
 	rpcResponse := client.StatusResponse{
 		Sys: struct {
-			Mac              string `json:"mac"`
-			RestartRequired  bool   `json:"restart_required"`
-			Time             string `json:"time"`
-			Unixtime         int64  `json:"unixtime"`
-			LastSyncTs       int64  `json:"last_sync_ts"`
-			Uptime           int    `json:"uptime"`
-			RAMSize          int    `json:"ram_size"`
-			RAMFree          int    `json:"ram_free"`
-			RAMMinFree       int    `json:"ram_min_free"`
-			FSSize           int    `json:"fs_size"`
-			FSFree           int    `json:"fs_free"`
-			CfgRev           int    `json:"cfg_rev"`
-			KvsRev           int    `json:"kvs_rev"`
-			ScheduleRev      int    `json:"schedule_rev"`
-			WebhookRev       int    `json:"webhook_rev"`
-			BtrelayRev       int    `json:"btrelay_rev"`
-			AvailableUpdates struct {
-				Stable struct {
-					Version string `json:"version"`
-				} `json:"stable"`
-			} `json:"available_updates"`
-			ResetReason int `json:"reset_reason"`
+			Mac              string                  `json:"mac"`
+			RestartRequired  bool                    `json:"restart_required"`
+			Time             string                  `json:"time"`
+			Unixtime         int64                   `json:"unixtime"`
+			LastSyncTs       int64                   `json:"last_sync_ts"`
+			Uptime           int                     `json:"uptime"`
+			RAMSize          int                     `json:"ram_size"`
+			RAMFree          int                     `json:"ram_free"`
+			RAMMinFree       int                     `json:"ram_min_free"`
+			FSSize           int                     `json:"fs_size"`
+			FSFree           int                     `json:"fs_free"`
+			CfgRev           int                     `json:"cfg_rev"`
+			KvsRev           int                     `json:"kvs_rev"`
+			ScheduleRev      int                     `json:"schedule_rev"`
+			WebhookRev       int                     `json:"webhook_rev"`
+			BtrelayRev       int                     `json:"btrelay_rev"`
+			AvailableUpdates client.AvailableUpdates `json:"available_updates"`
+			ResetReason      int                     `json:"reset_reason"`
 		}{
 			Mac:     "AA:BB:CC:DD:EE:FF",
 			Uptime:  12345,
@@ -126,16 +126,8 @@ func TestCollector_Collect_Success(t *testing.T) {
 			RAMFree: 40960,
 			FSSize:  65536,
 			FSFree:  32768,
-			AvailableUpdates: struct {
-				Stable struct {
-					Version string `json:"version"`
-				} `json:"stable"`
-			}{
-				Stable: struct {
-					Version string `json:"version"`
-				}{
-					Version: "1.0.0",
-				},
+			AvailableUpdates: client.AvailableUpdates{
+				Stable: client.FirmwareUpdate{Version: "1.0.0"},
 			},
 		},
 		Wifi: struct {
@@ -436,28 +428,24 @@ func TestCollector_Collect_MultipleDevices(t *testing.T) {
 	// Mock responses for multiple devices
 	response1 := client.StatusResponse{
 		Sys: struct {
-			Mac              string `json:"mac"`
-			RestartRequired  bool   `json:"restart_required"`
-			Time             string `json:"time"`
-			Unixtime         int64  `json:"unixtime"`
-			LastSyncTs       int64  `json:"last_sync_ts"`
-			Uptime           int    `json:"uptime"`
-			RAMSize          int    `json:"ram_size"`
-			RAMFree          int    `json:"ram_free"`
-			RAMMinFree       int    `json:"ram_min_free"`
-			FSSize           int    `json:"fs_size"`
-			FSFree           int    `json:"fs_free"`
-			CfgRev           int    `json:"cfg_rev"`
-			KvsRev           int    `json:"kvs_rev"`
-			ScheduleRev      int    `json:"schedule_rev"`
-			WebhookRev       int    `json:"webhook_rev"`
-			BtrelayRev       int    `json:"btrelay_rev"`
-			AvailableUpdates struct {
-				Stable struct {
-					Version string `json:"version"`
-				} `json:"stable"`
-			} `json:"available_updates"`
-			ResetReason int `json:"reset_reason"`
+			Mac              string                  `json:"mac"`
+			RestartRequired  bool                    `json:"restart_required"`
+			Time             string                  `json:"time"`
+			Unixtime         int64                   `json:"unixtime"`
+			LastSyncTs       int64                   `json:"last_sync_ts"`
+			Uptime           int                     `json:"uptime"`
+			RAMSize          int                     `json:"ram_size"`
+			RAMFree          int                     `json:"ram_free"`
+			RAMMinFree       int                     `json:"ram_min_free"`
+			FSSize           int                     `json:"fs_size"`
+			FSFree           int                     `json:"fs_free"`
+			CfgRev           int                     `json:"cfg_rev"`
+			KvsRev           int                     `json:"kvs_rev"`
+			ScheduleRev      int                     `json:"schedule_rev"`
+			WebhookRev       int                     `json:"webhook_rev"`
+			BtrelayRev       int                     `json:"btrelay_rev"`
+			AvailableUpdates client.AvailableUpdates `json:"available_updates"`
+			ResetReason      int                     `json:"reset_reason"`
 		}{
 			Mac:     "AA:BB:CC:DD:EE:FF",
 			Uptime:  1000,
@@ -517,28 +505,24 @@ func TestCollector_Collect_MultipleDevices(t *testing.T) {
 
 	response2 := client.StatusResponse{
 		Sys: struct {
-			Mac              string `json:"mac"`
-			RestartRequired  bool   `json:"restart_required"`
-			Time             string `json:"time"`
-			Unixtime         int64  `json:"unixtime"`
-			LastSyncTs       int64  `json:"last_sync_ts"`
-			Uptime           int    `json:"uptime"`
-			RAMSize          int    `json:"ram_size"`
-			RAMFree          int    `json:"ram_free"`
-			RAMMinFree       int    `json:"ram_min_free"`
-			FSSize           int    `json:"fs_size"`
-			FSFree           int    `json:"fs_free"`
-			CfgRev           int    `json:"cfg_rev"`
-			KvsRev           int    `json:"kvs_rev"`
-			ScheduleRev      int    `json:"schedule_rev"`
-			WebhookRev       int    `json:"webhook_rev"`
-			BtrelayRev       int    `json:"btrelay_rev"`
-			AvailableUpdates struct {
-				Stable struct {
-					Version string `json:"version"`
-				} `json:"stable"`
-			} `json:"available_updates"`
-			ResetReason int `json:"reset_reason"`
+			Mac              string                  `json:"mac"`
+			RestartRequired  bool                    `json:"restart_required"`
+			Time             string                  `json:"time"`
+			Unixtime         int64                   `json:"unixtime"`
+			LastSyncTs       int64                   `json:"last_sync_ts"`
+			Uptime           int                     `json:"uptime"`
+			RAMSize          int                     `json:"ram_size"`
+			RAMFree          int                     `json:"ram_free"`
+			RAMMinFree       int                     `json:"ram_min_free"`
+			FSSize           int                     `json:"fs_size"`
+			FSFree           int                     `json:"fs_free"`
+			CfgRev           int                     `json:"cfg_rev"`
+			KvsRev           int                     `json:"kvs_rev"`
+			ScheduleRev      int                     `json:"schedule_rev"`
+			WebhookRev       int                     `json:"webhook_rev"`
+			BtrelayRev       int                     `json:"btrelay_rev"`
+			AvailableUpdates client.AvailableUpdates `json:"available_updates"`
+			ResetReason      int                     `json:"reset_reason"`
 		}{
 			Mac:     "BB:CC:DD:EE:FF:AA",
 			Uptime:  2000,
@@ -706,3 +690,784 @@ func TestCollector_Collect_ContextTimeout(t *testing.T) {
 		t.Error("Missing shelly_device_up metric for timeout scenario")
 	}
 }
+
+func TestCollector_Collect_ScrapeTimeout(t *testing.T) {
+	// Every request answers within the timeout, the RPC and legacy requests together do not
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mac":"AABBCCDDEEFF","uptime":100}`))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	cl := client.New(server.URL, &config.Config{ScrapeTimeout: 100 * time.Millisecond}, logger)
+	collector := NewCollector([]*client.Client{cl}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "shelly_device_up" && family.GetMetric()[0].GetGauge().GetValue() != 0 {
+			t.Error("shelly_device_up = 1, want 0 when the scrape exceeds the scrape timeout")
+		}
+	}
+}
+
+func TestCollector_AddRemoveClient(t *testing.T) {
+	cfg := &config.Config{
+		ScrapeTimeout: 10 * time.Second,
+		TLS: config.TLSConfig{
+			Enabled: false,
+		},
+	}
+	logger := logrus.New()
+	collector := NewCollector([]*client.Client{client.New("http://192.168.1.100", cfg, logger)}, logger)
+
+	if !collector.AddClient(client.New("http://192.168.1.101", cfg, logger)) {
+		t.Error("AddClient() = false for new device, want true")
+	}
+	if collector.AddClient(client.New("http://192.168.1.100", cfg, logger)) {
+		t.Error("AddClient() = true for existing device, want false")
+	}
+	if len(collector.Clients()) != 2 {
+		t.Errorf("Clients() length = %v, want 2", len(collector.Clients()))
+	}
+
+	if !collector.RemoveClient("http://192.168.1.100") {
+		t.Error("RemoveClient() = false for existing device, want true")
+	}
+	if collector.RemoveClient("http://192.168.1.100") {
+		t.Error("RemoveClient() = true for removed device, want false")
+	}
+
+	clients := collector.Clients()
+	if len(clients) != 1 || clients[0].BaseURL() != "http://192.168.1.101" {
+		t.Errorf("Clients() = %v, want only http://192.168.1.101", clients)
+	}
+}
+
+func TestCollector_SetClients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	collector := NewCollector(nil, logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// scrapeErrors returns the failed scrapes of each device label
+	scrapeErrors := func() map[string]float64 {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		errors := make(map[string]float64)
+		for _, family := range families {
+			if family.GetName() != "shelly_scrape_errors_total" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "device" {
+						errors[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return errors
+	}
+
+	// The device is served under two addresses, as a device that changed its IP address
+	newClient := func(url string) *client.Client {
+		cl := client.New(url, cfg, logger)
+		cl.SetID("AA:BB:CC:DD:EE:FF")
+		return cl
+	}
+
+	collector.SetClients([]*client.Client{newClient(server.URL)})
+	if got := scrapeErrors(); len(got) != 1 || got["AA:BB:CC:DD:EE:FF"] != 1 {
+		t.Errorf("shelly_scrape_errors_total = %v, want 1 for AA:BB:CC:DD:EE:FF", got)
+	}
+
+	// The state of a device is kept when its client is replaced
+	collector.SetClients([]*client.Client{newClient(server.URL + "/")})
+	if got := scrapeErrors(); len(got) != 1 || got["AA:BB:CC:DD:EE:FF"] != 2 {
+		t.Errorf("shelly_scrape_errors_total = %v, want 2 for AA:BB:CC:DD:EE:FF", got)
+	}
+
+	// and dropped once the device is gone
+	collector.SetClients(nil)
+	collector.SetClients([]*client.Client{newClient(server.URL)})
+	if got := scrapeErrors(); got["AA:BB:CC:DD:EE:FF"] != 1 {
+		t.Errorf("shelly_scrape_errors_total = %v, want 1 for AA:BB:CC:DD:EE:FF", got)
+	}
+}
+
+func TestCollector_Collect_DeviceLabels(t *testing.T) {
+	cfg := &config.Config{
+		ScrapeTimeout: 50 * time.Millisecond,
+		TLS: config.TLSConfig{
+			Enabled: false,
+		},
+	}
+	logger := logrus.New()
+	c := client.New("http://127.0.0.1:1", cfg, logger)
+	// The device label clashes with the variable label and is not attached
+	c.SetLabels(map[string]string{"room": "kitchen", "device": "ignored"})
+	collector := NewCollector([]*client.Client{c}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	metrics, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	found := false
+	for _, family := range metrics {
+		if family.GetName() != "shelly_device_up" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["room"] != "kitchen" {
+				t.Errorf("shelly_device_up room label = %q, want kitchen", labels["room"])
+			}
+			if labels["device"] != "http://127.0.0.1:1" {
+				t.Errorf("shelly_device_up device label = %q, want http://127.0.0.1:1", labels["device"])
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Missing shelly_device_up metric")
+	}
+}
+
+func TestCollector_Collect_ScrapeHealth(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/rpc/Shelly.GetStatus" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(client.StatusResponse{}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// gather returns the value of every series by metric name and label values
+	gather := func() map[string]float64 {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		values := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				key := family.GetName()
+				for _, label := range metric.GetLabel() {
+					if label.GetName() != "device" {
+						key += " " + label.GetValue()
+					}
+				}
+				switch {
+				case metric.GetCounter() != nil:
+					values[key] = metric.GetCounter().GetValue()
+				default:
+					values[key] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+		return values
+	}
+
+	// Protected device without credentials
+	gather()
+	values := gather()
+	if got := values["shelly_scrape_errors_total auth"]; got != 2 {
+		t.Errorf("shelly_scrape_errors_total{reason=auth} = %v, want 2", got)
+	}
+	if got, ok := values["shelly_scrape_errors_total timeout"]; !ok || got != 0 {
+		t.Errorf("shelly_scrape_errors_total{reason=timeout} = %v (present %v), want 0", got, ok)
+	}
+	if _, ok := values["shelly_scrape_duration_seconds"]; !ok {
+		t.Error("Missing shelly_scrape_duration_seconds for failed scrape")
+	}
+	if _, ok := values["shelly_last_successful_scrape_timestamp_seconds"]; ok {
+		t.Error("shelly_last_successful_scrape_timestamp_seconds present before a successful scrape")
+	}
+
+	// Successful scrape keeps the error counts
+	fail.Store(false)
+	before := float64(time.Now().Unix())
+	values = gather()
+	if got := values["shelly_scrape_errors_total auth"]; got != 2 {
+		t.Errorf("shelly_scrape_errors_total{reason=auth} = %v after success, want 2", got)
+	}
+	if got := values["shelly_last_successful_scrape_timestamp_seconds"]; got < before {
+		t.Errorf("shelly_last_successful_scrape_timestamp_seconds = %v, want at least %v", got, before)
+	}
+	if got := values["shelly_scrape_api_info "+client.APIRPC]; got != 1 {
+		t.Errorf("shelly_scrape_api_info{api=%q} = %v, want 1", client.APIRPC, got)
+	}
+	if got := values["shelly_device_up"]; got != 1 {
+		t.Errorf("shelly_device_up = %v, want 1", got)
+	}
+}
+
+func TestCollector_Collect_Components(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		present []string
+		absent  []string
+	}{
+		{
+			name: "switch without meter",
+			path: "/rpc/Shelly.GetStatus",
+			body: `{"sys":{"mac":"AABBCCDDEEFF"},"switch:0":{"output":true}}`,
+			present: []string{
+				"shelly_overtemperature",
+			},
+			absent: []string{
+				"shelly_power_watts",
+				"shelly_energy_total_watthours",
+				"shelly_temperature_celsius",
+			},
+		},
+		{
+			name: "three-phase meter without switch",
+			path: "/rpc/Shelly.GetStatus",
+			body: `{"sys":{"mac":"AABBCCDDEEFF"},"em:0":{"total_act_power":100},"temperature:0":{"tC":40}}`,
+			present: []string{
+				"shelly_power_watts phase_a",
+				"shelly_temperature_celsius",
+			},
+			absent: []string{
+				"shelly_overtemperature",
+				"shelly_temperature_status",
+			},
+		},
+		{
+			name: "legacy plug",
+			path: "/status",
+			body: `{"mac":"AABBCCDDEEFF","relays":[{"ison":true}],"meters":[{"power":12.5,"total":600,"is_valid":true}]}`,
+			present: []string{
+				"shelly_power_watts total",
+				"shelly_energy_total_watthours",
+			},
+			absent: []string{
+				"shelly_power_watts phase_a",
+				"shelly_power_watts phase_b",
+				"shelly_power_watts phase_c",
+				"shelly_temperature_celsius",
+				"shelly_device_last_reset_reason",
+				"shelly_restart_required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.path {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{ScrapeTimeout: time.Second}
+			logger := logrus.New()
+			collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(collector)
+
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Failed to gather metrics: %v", err)
+			}
+
+			// Series are keyed by metric name, and by name and meter label
+			series := make(map[string]bool)
+			for _, family := range families {
+				series[family.GetName()] = true
+				for _, metric := range family.GetMetric() {
+					for _, label := range metric.GetLabel() {
+						if label.GetName() == "meter" {
+							series[family.GetName()+" "+label.GetValue()] = true
+						}
+					}
+				}
+			}
+
+			if !series["shelly_device_up"] {
+				t.Error("Missing shelly_device_up")
+			}
+			for _, name := range tt.present {
+				if !series[name] {
+					t.Errorf("Missing %s", name)
+				}
+			}
+			for _, name := range tt.absent {
+				if series[name] {
+					t.Errorf("Unexpected %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_MaxStaleness(t *testing.T) {
+	var fail atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() || r.URL.Path != "/rpc/Shelly.GetStatus" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sys":{"mac":"AABBCCDDEEFF","ram_free":100},"switch:0":{"output":true}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+	collector.SetMaxStaleness(time.Hour)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// gather returns the value of every gauge by metric name
+	gather := func() map[string]float64 {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		values := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				values[family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+
+	values := gather()
+	if got, ok := values["shelly_device_data_age_seconds"]; !ok || got != 0 {
+		t.Errorf("shelly_device_data_age_seconds = %v (present %v), want 0", got, ok)
+	}
+
+	// Failed scrapes serve the last values
+	fail.Store(true)
+	time.Sleep(10 * time.Millisecond)
+	values = gather()
+	if got := values["shelly_device_up"]; got != 1 {
+		t.Errorf("shelly_device_up = %v with stale values, want 1", got)
+	}
+	if got := values["shelly_ram_free_bytes"]; got != 100 {
+		t.Errorf("shelly_ram_free_bytes = %v with stale values, want 100", got)
+	}
+	if got := values["shelly_device_data_age_seconds"]; got <= 0 {
+		t.Errorf("shelly_device_data_age_seconds = %v with stale values, want more than 0", got)
+	}
+
+	// Expired values are no longer served
+	collector.SetMaxStaleness(time.Millisecond)
+	values = gather()
+	if got := values["shelly_device_up"]; got != 0 {
+		t.Errorf("shelly_device_up = %v with expired values, want 0", got)
+	}
+	if _, ok := values["shelly_ram_free_bytes"]; ok {
+		t.Error("shelly_ram_free_bytes present with expired values")
+	}
+	if _, ok := values["shelly_device_data_age_seconds"]; !ok {
+		t.Error("Missing shelly_device_data_age_seconds with expired values")
+	}
+}
+
+func TestCollector_Collect_MonotonicEnergy(t *testing.T) {
+	var total, uptime atomic.Int64
+	total.Store(6000)
+	uptime.Store(100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"mac":"AABBCCDDEEFF","uptime":%d,"meters":[{"power":10,"total":%d,"is_valid":true}]}`, uptime.Load(), total.Load())
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+	store, err := state.Open("", logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := collector.EnableMonotonicEnergy(store); err != nil {
+		t.Fatalf("EnableMonotonicEnergy() error = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// gather returns the raw and the monotonic energy total
+	gather := func() (float64, float64) {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		var raw, monotonic float64
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				switch family.GetName() {
+				case "shelly_energy_total_watthours":
+					raw = metric.GetCounter().GetValue()
+				case "shelly_energy_monotonic_total_watthours":
+					monotonic = metric.GetCounter().GetValue()
+				}
+			}
+		}
+		return raw, monotonic
+	}
+
+	if raw, monotonic := gather(); monotonic != raw {
+		t.Errorf("shelly_energy_monotonic_total_watthours = %v, want the device total %v", monotonic, raw)
+	}
+	_, before := gather()
+
+	// The device reboots and counts from 0
+	total.Store(60)
+	uptime.Store(5)
+	raw, monotonic := gather()
+	if monotonic <= before || monotonic != before+raw {
+		t.Errorf("shelly_energy_monotonic_total_watthours after reboot = %v, want %v", monotonic, before+raw)
+	}
+}
+
+// pushSource is a status source that pushes its status, if set, for every device
+type pushSource struct {
+	status *client.StatusResponse
+}
+
+func (s *pushSource) Status(host string) (*client.StatusResponse, bool) {
+	return s.status, s.status != nil
+}
+
+func TestCollector_Collect_MonotonicEnergyCoIoT(t *testing.T) {
+	var total, uptime atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"mac":"AABBCCDDEEFF","uptime":%d,"meters":[{"power":10,"total":%d,"is_valid":true}]}`, uptime.Load(), total.Load())
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	cl := client.New(server.URL, cfg, logger)
+	source := &pushSource{}
+	cl.SetStatusSource(source)
+
+	collector := NewCollector([]*client.Client{cl}, logger)
+	store, err := state.Open("", logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := collector.EnableMonotonicEnergy(store); err != nil {
+		t.Fatalf("EnableMonotonicEnergy() error = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// coiotStatus returns a CoIoT update, which carries the energy total but no uptime
+	coiotStatus := func(total float64) *client.StatusResponse {
+		status := &client.StatusResponse{}
+		status.EMData.TotalAct = total
+		status.SetComponent(client.ComponentMeters)
+		return status
+	}
+
+	// Polled statuses in watt-minutes alternate with CoIoT updates in watt-hours
+	steps := []struct {
+		total  int64
+		uptime int64
+		push   *client.StatusResponse
+		want   float64
+	}{
+		{total: 60000, uptime: 100, want: 1000},
+		{push: coiotStatus(1000.5), want: 1000.5},
+		{total: 60060, uptime: 160, want: 1001},
+		{push: coiotStatus(1001.5), want: 1001.5},
+		{push: coiotStatus(1002), want: 1002},
+		{total: 60150, uptime: 250, want: 1002.5},
+	}
+
+	var polledUptime float64
+	for i, step := range steps {
+		total.Store(step.total)
+		uptime.Store(step.uptime)
+		source.status = step.push
+
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		var monotonic, deviceUptime float64
+		for _, family := range families {
+			switch family.GetName() {
+			case "shelly_energy_monotonic_total_watthours":
+				monotonic = family.GetMetric()[0].GetCounter().GetValue()
+			case "shelly_uptime_seconds":
+				deviceUptime = family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		if monotonic != step.want {
+			t.Errorf("step %d: shelly_energy_monotonic_total_watthours = %v, want %v", i, monotonic, step.want)
+		}
+
+		// CoIoT updates keep the uptime of the last poll
+		if step.push == nil {
+			polledUptime = float64(step.uptime)
+		}
+		if deviceUptime != polledUptime {
+			t.Errorf("step %d: shelly_uptime_seconds = %v, want %v", i, deviceUptime, polledUptime)
+		}
+	}
+}
+
+func TestCollector_Collect_LegacyOvertemperature(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"mac":"AABBCCDDEEFF","temperature":94.2,"overtemperature":true,"temperature_status":"Very High"}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	// Series are keyed by metric name and status label
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "status" {
+					key += " " + label.GetValue()
+				}
+			}
+			values[key] = metric.GetGauge().GetValue()
+		}
+	}
+
+	want := map[string]float64{
+		"shelly_overtemperature":              1,
+		"shelly_temperature_status Normal":    0,
+		"shelly_temperature_status High":      0,
+		"shelly_temperature_status Very High": 1,
+		"shelly_temperature_celsius":          94.2,
+	}
+	for key, value := range want {
+		if got, ok := values[key]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", key, got, ok, value)
+		}
+	}
+}
+
+func TestCollector_Collect_FirmwareUpdates(t *testing.T) {
+	newDevice := func(update string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/status" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"mac":"AABBCCDDEEFF","update":` + update + `}`))
+		}))
+	}
+
+	// The first device offers a stable update, the second one a beta
+	stable := newDevice(`{"status":"pending","has_update":true,"new_version":"v1.14.1","old_version":"v1.14.0","beta_version":"v1.14.1"}`)
+	defer stable.Close()
+	beta := newDevice(`{"status":"idle","has_update":false,"new_version":"v1.14.1","old_version":"v1.14.1","beta_version":"v1.15.0-rc1"}`)
+	defer beta.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	collector := NewCollector([]*client.Client{
+		client.New(stable.URL, cfg, logger),
+		client.New(beta.URL, cfg, logger),
+	}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+
+	// Series are keyed by metric name, device and their channel and version labels
+	devices := map[string]string{stable.URL: "stable-device", beta.URL: "beta-device"}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case "device":
+					key += " " + devices[label.GetValue()]
+				case "channel", "current", "stable", "beta":
+					key += " " + label.GetName() + "=" + label.GetValue()
+				}
+			}
+			values[key] = metric.GetGauge().GetValue()
+		}
+	}
+
+	want := map[string]float64{
+		"shelly_firmware_info beta=v1.14.1 current=v1.14.0 stable-device stable=v1.14.1": 1,
+		"shelly_firmware_info beta=v1.15.0-rc1 current=v1.14.1 beta-device stable=":      1,
+		"shelly_firmware_update_available channel=stable stable-device":                  1,
+		"shelly_firmware_update_available channel=beta stable-device":                    1,
+		"shelly_firmware_update_available channel=stable beta-device":                    0,
+		"shelly_firmware_update_available channel=beta beta-device":                      1,
+		"shelly_firmware_update_available_devices channel=stable":                        1,
+		"shelly_firmware_update_available_devices channel=beta":                          2,
+	}
+	for key, value := range want {
+		if got, ok := values[key]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", key, got, ok, value)
+		}
+	}
+}
+
+func TestCollector_Collect_Reboots(t *testing.T) {
+	uptime := 3600
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != client.APIRPC {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"sys":{"mac":"AABBCCDDEEFF","uptime":%d,"reset_reason":9,"restart_required":true}}`, uptime)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{ScrapeTimeout: time.Second}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	collector := NewCollector([]*client.Client{client.New(server.URL, cfg, logger)}, logger)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	// gather returns the series by metric name and their code and reason labels
+	gather := func() map[string]float64 {
+		t.Helper()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+
+		values := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				key := family.GetName()
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "code" || label.GetName() == "reason" {
+						key += " " + label.GetName() + "=" + label.GetValue()
+					}
+				}
+				switch {
+				case metric.GetCounter() != nil:
+					values[key] = metric.GetCounter().GetValue()
+				case metric.GetGauge() != nil:
+					values[key] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+		return values
+	}
+
+	steps := []struct {
+		uptime  int
+		reboots float64
+	}{
+		{uptime: 3600, reboots: 0},
+		{uptime: 3660, reboots: 0},
+		// The device rebooted between the scrapes
+		{uptime: 20, reboots: 1},
+		{uptime: 80, reboots: 1},
+	}
+
+	for _, step := range steps {
+		uptime = step.uptime
+		values := gather()
+
+		if got := values["shelly_device_reboots_total"]; got != step.reboots {
+			t.Errorf("uptime %d: shelly_device_reboots_total = %v, want %v", step.uptime, got, step.reboots)
+		}
+		if got, ok := values["shelly_device_last_reset_reason code=9 reason=brownout"]; !ok || got != 1 {
+			t.Errorf("uptime %d: shelly_device_last_reset_reason{code=9,reason=brownout} = %v (present %v), want 1", step.uptime, got, ok)
+		}
+		if got := values["shelly_restart_required"]; got != 1 {
+			t.Errorf("uptime %d: shelly_restart_required = %v, want 1", step.uptime, got)
+		}
+	}
+}
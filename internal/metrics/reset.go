@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
+)
+
+// resetReasons names the reset reasons reported by Gen2+ devices in sys.reset_reason, which
+// are the reset reason codes of the ESP32
+var resetReasons = map[int]string{
+	0:  "unknown",
+	1:  "power_on",
+	2:  "external",
+	3:  "software",
+	4:  "panic",
+	5:  "interrupt_watchdog",
+	6:  "task_watchdog",
+	7:  "watchdog",
+	8:  "deep_sleep",
+	9:  "brownout",
+	10: "sdio",
+}
+
+// resetReason returns the name of a reset reason code, unknown for codes without a name
+func resetReason(code int) string {
+	if reason, ok := resetReasons[code]; ok {
+		return reason
+	}
+	return resetReasons[0]
+}
+
+// rebootStateKey is the key of the reboot counters in the state store
+const rebootStateKey = "reboots"
+
+// rebootCounter counts the reboots of a device, detected by a decreasing uptime
+type rebootCounter struct {
+	// Reboots is the number of reboots seen
+	Reboots float64 `json:"reboots"`
+	// Uptime is the last uptime reported by the device
+	Uptime int `json:"uptime"`
+}
+
+// observe records the uptime of a fresh status and counts a reboot when it decreased.
+// Statuses without an uptime, such as CoIoT updates, are ignored.
+func (r *rebootCounter) observe(uptime int) {
+	if uptime <= 0 {
+		return
+	}
+	if r.Uptime > 0 && uptime < r.Uptime {
+		r.Reboots++
+	}
+	r.Uptime = uptime
+}
+
+// rebootCounters holds the reboot counters of all devices by device
+type rebootCounters struct {
+	store    state.Store
+	counters map[string]*rebootCounter
+	dirty    bool
+
+	mu sync.Mutex
+}
+
+// newRebootCounters creates reboot counters that are only kept in memory
+func newRebootCounters() *rebootCounters {
+	return &rebootCounters{counters: make(map[string]*rebootCounter)}
+}
+
+// loadRebootCounters reads the counters from the state store. The returned counters are
+// usable even if an error is returned.
+func loadRebootCounters(store state.Store) (*rebootCounters, error) {
+	r := newRebootCounters()
+	r.store = store
+
+	if _, err := store.Load(rebootStateKey, &r.counters); err != nil {
+		r.counters = make(map[string]*rebootCounter)
+		return r, err
+	}
+	return r, nil
+}
+
+// observe records the uptime reported by a device and returns its number of reboots
+func (r *rebootCounters) observe(device string, uptime int) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counter, ok := r.counters[device]
+	if !ok {
+		counter = &rebootCounter{}
+		r.counters[device] = counter
+	}
+
+	if uptime > 0 && counter.Uptime != uptime {
+		r.dirty = true
+	}
+	counter.observe(uptime)
+	return counter.Reboots
+}
+
+// count returns the number of reboots seen of a device
+func (r *rebootCounters) count(device string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if counter, ok := r.counters[device]; ok {
+		return counter.Reboots
+	}
+	return 0
+}
+
+// save hands the counters to the state store if they changed since the last save
+func (r *rebootCounters) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.store == nil || !r.dirty {
+		return nil
+	}
+	if err := r.store.Save(rebootStateKey, r.counters); err != nil {
+		return err
+	}
+
+	r.dirty = false
+	return nil
+}
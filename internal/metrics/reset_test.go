@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+func TestResetReason(t *testing.T) {
+	tests := []struct {
+		code int
+		want string
+	}{
+		{code: 1, want: "power_on"},
+		{code: 3, want: "software"},
+		{code: 6, want: "task_watchdog"},
+		{code: 9, want: "brownout"},
+		{code: 0, want: "unknown"},
+		{code: 42, want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := resetReason(tt.code); got != tt.want {
+			t.Errorf("resetReason(%d) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRebootCounter_Observe(t *testing.T) {
+	steps := []struct {
+		uptime  int
+		reboots float64
+	}{
+		{uptime: 100, reboots: 0},
+		{uptime: 160, reboots: 0},
+		// A CoIoT update without uptime
+		{uptime: 0, reboots: 0},
+		{uptime: 220, reboots: 0},
+		{uptime: 15, reboots: 1},
+		{uptime: 75, reboots: 1},
+		{uptime: 3, reboots: 2},
+	}
+
+	var counter rebootCounter
+	for _, step := range steps {
+		counter.observe(step.uptime)
+		if counter.Reboots != step.reboots {
+			t.Errorf("after uptime %d: reboots = %v, want %v", step.uptime, counter.Reboots, step.reboots)
+		}
+	}
+}
+
+func TestRebootCounters_Persistence(t *testing.T) {
+	logger := logrus.New()
+	dir := t.TempDir()
+
+	store, err := state.Open(dir, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	reboots, err := loadRebootCounters(store)
+	if err != nil {
+		t.Fatalf("loadRebootCounters() error = %v", err)
+	}
+
+	reboots.observe("http://192.168.1.100", 3600)
+	if got := reboots.observe("http://192.168.1.100", 20); got != 1 {
+		t.Errorf("observe() = %v, want 1", got)
+	}
+	if err := reboots.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	// The reboot count and last uptime survive a restart, so a reboot while the exporter
+	// was not running is detected
+	store, err = state.Open(dir, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	reboots, err = loadRebootCounters(store)
+	if err != nil {
+		t.Fatalf("loadRebootCounters() error = %v", err)
+	}
+	if got := reboots.count("http://192.168.1.100"); got != 1 {
+		t.Errorf("count() after restart = %v, want 1", got)
+	}
+	if got := reboots.observe("http://192.168.1.100", 10); got != 2 {
+		t.Errorf("observe() after restart = %v, want 2", got)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultModule is used when a probe request does not name a module
+const defaultModule = "default"
+
+// probeHandler scrapes the device given by the target parameter in the style of the
+// blackbox_exporter. Every request uses its own client, collector and registry.
+func (s *Server) probeHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := probeTarget(r.URL.Query().Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := r.URL.Query().Get("module")
+	if moduleName == "" {
+		moduleName = defaultModule
+	}
+	cfg, ok := s.moduleConfig(moduleName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"target": config.RedactURL(target),
+		"module": moduleName,
+	})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics.NewCollector([]*client.Client{client.New(target, cfg, s.logger)}, s.logger))
+
+	start := time.Now()
+	families, err := registry.Gather()
+	duration := time.Since(start).Seconds()
+	if err != nil {
+		logger.WithError(err).Error("Failed to gather probe metrics")
+	}
+
+	success := deviceUp(families)
+	logger.WithFields(logrus.Fields{
+		"success":  success,
+		"duration": duration,
+	}).Debug("Probe finished")
+
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether the probe of the target succeeded",
+	})
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the probe of the target took in seconds",
+	})
+	if success {
+		probeSuccess.Set(1)
+	}
+	probeDuration.Set(duration)
+
+	probeRegistry := prometheus.NewRegistry()
+	probeRegistry.MustRegister(probeSuccess, probeDuration)
+
+	gatherers := prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil }),
+		probeRegistry,
+	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// moduleConfig returns the configuration used to probe a target with the named module.
+// The default module falls back to the global configuration if it is not configured.
+func (s *Server) moduleConfig(name string) (*config.Config, bool) {
+	cfg := *s.currentConfig()
+
+	module, ok := cfg.Modules[name]
+	if !ok {
+		return &cfg, name == defaultModule
+	}
+
+	if module.Timeout > 0 {
+		cfg.ScrapeTimeout = module.Timeout
+	}
+	if module.Auth.Username != "" || module.Auth.Password != "" {
+		cfg.Auth = module.Auth
+	}
+	if module.TLS.Enabled {
+		cfg.TLS = module.TLS
+	}
+
+	return &cfg, true
+}
+
+// probeTarget converts the target parameter into a device base URL.
+// A target without a scheme is probed over HTTP.
+func probeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	return strings.TrimSuffix(target, "/"), nil
+}
+
+// deviceUp reports whether the gathered metrics show the device as up
+func deviceUp(families []*dto.MetricFamily) bool {
+	for _, family := range families {
+		if family.GetName() != "shelly_device_up" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge().GetValue() == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func newProbeTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := &config.Config{
+		ListenAddress: ":8080",
+		MetricsPath:   "/metrics",
+		ScrapeTimeout: 10 * time.Second,
+		Modules: map[string]config.ModuleConfig{
+			"secured": {
+				Timeout: 2 * time.Second,
+				Auth:    config.AuthConfig{Username: "admin", Password: "secret"},
+			},
+		},
+	}
+
+	server, err := New(cfg, logrus.New())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return server
+}
+
+func probe(server *Server, query url.Values) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "/probe?"+query.Encode(), nil))
+	return rr
+}
+
+func TestServer_Probe(t *testing.T) {
+	// A Gen1 device with restricted login
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(client.LegacyStatusResponse{Mac: "AABBCCDDEEFF"}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer device.Close()
+
+	server := newProbeTestServer(t)
+
+	tests := []struct {
+		name        string
+		query       url.Values
+		wantStatus  int
+		wantContent []string
+	}{
+		{
+			name:        "missing target",
+			query:       url.Values{},
+			wantStatus:  http.StatusBadRequest,
+			wantContent: []string{"target parameter is missing"},
+		},
+		{
+			name:        "invalid target",
+			query:       url.Values{"target": {"ftp://192.168.1.100"}},
+			wantStatus:  http.StatusBadRequest,
+			wantContent: []string{"invalid target"},
+		},
+		{
+			name:        "unknown module",
+			query:       url.Values{"target": {device.URL}, "module": {"missing"}},
+			wantStatus:  http.StatusBadRequest,
+			wantContent: []string{`unknown module "missing"`},
+		},
+		{
+			name:       "successful probe",
+			query:      url.Values{"target": {strings.TrimPrefix(device.URL, "http://")}, "module": {"secured"}},
+			wantStatus: http.StatusOK,
+			wantContent: []string{
+				"probe_success 1",
+				"probe_duration_seconds",
+				`shelly_device_up{device="` + device.URL + `"} 1`,
+			},
+		},
+		{
+			name:       "failed probe without credentials",
+			query:      url.Values{"target": {device.URL}},
+			wantStatus: http.StatusOK,
+			wantContent: []string{
+				"probe_success 0",
+				`shelly_device_up{device="` + device.URL + `"} 0`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := probe(server, tt.query)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("/probe status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+			for _, want := range tt.wantContent {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("/probe body does not contain %q:\n%s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ModuleConfig(t *testing.T) {
+	server := newProbeTestServer(t)
+
+	cfg, ok := server.moduleConfig(defaultModule)
+	if !ok {
+		t.Fatal("moduleConfig() default module not found")
+	}
+	if cfg.ScrapeTimeout != 10*time.Second || cfg.Auth.Password != "" {
+		t.Errorf("moduleConfig() default = %+v, want global settings", cfg)
+	}
+
+	cfg, ok = server.moduleConfig("secured")
+	if !ok {
+		t.Fatal("moduleConfig() secured module not found")
+	}
+	if cfg.ScrapeTimeout != 2*time.Second || cfg.Auth.Password != "secret" {
+		t.Errorf("moduleConfig() secured = %+v, want module settings", cfg)
+	}
+	if server.config.ScrapeTimeout != 10*time.Second {
+		t.Error("moduleConfig() must not modify the server configuration")
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// configWatchDelay collects the events of an editor writing the file into a single reload
+const configWatchDelay = 500 * time.Millisecond
+
+// currentConfig returns the configuration that is currently applied
+func (s *Server) currentConfig() *config.Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// Reload reads the configuration file again and applies it. Devices whose settings
+// did not change keep their clients. On failure the running configuration is kept.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := s.loadConfig()
+	if err != nil {
+		s.lastReloadSuccessful.Set(0)
+		s.logger.WithError(err).Error("Failed to reload configuration")
+		return err
+	}
+
+	s.applyConfig(cfg)
+
+	s.lastReloadSuccessful.Set(1)
+	s.lastReloadTimestamp.SetToCurrentTime()
+	s.logger.WithField("file", cfg.ConfigFile).Info("Configuration reloaded")
+	return nil
+}
+
+// loadConfig reads and validates the configuration from the file used at startup
+func (s *Server) loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(s.currentConfig().ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload configuration: %w", err)
+	}
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("failed to reload configuration: invalid log level: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyConfig switches to a new configuration. Settings that are only used at startup
+// are kept and a restart is requested in the log.
+func (s *Server) applyConfig(cfg *config.Config) {
+	old := s.currentConfig()
+
+	for setting, changed := range map[string]bool{
+		"listen_address":       old.ListenAddress != cfg.ListenAddress,
+		"metrics_path":         old.MetricsPath != cfg.MetricsPath,
+		"runtime_metrics":      old.RuntimeMetrics != cfg.RuntimeMetrics,
+		"monotonic_energy":     old.MonotonicEnergy != cfg.MonotonicEnergy,
+		"state_dir":            old.StateDir != cfg.StateDir,
+		"state_flush_interval": old.StateFlushInterval != cfg.StateFlushInterval,
+		"coiot":                !reflect.DeepEqual(old.CoIoT, cfg.CoIoT),
+		"discovery":            !reflect.DeepEqual(old.Discovery, cfg.Discovery),
+		"watch_config":         old.WatchConfig != cfg.WatchConfig,
+	} {
+		if changed {
+			s.logger.WithField("setting", setting).Warn("Configuration change requires a restart to take effect")
+		}
+	}
+
+	if level, err := logrus.ParseLevel(cfg.LogLevel); err == nil {
+		s.logger.SetLevel(level)
+	}
+
+	s.configMu.Lock()
+	s.config = cfg
+	s.configMu.Unlock()
+
+	s.collector.SetMaxStaleness(cfg.MaxStaleness)
+
+	// Clients copy the connection settings, so all of them are recreated when those change
+	if old.ScrapeTimeout != cfg.ScrapeTimeout || old.Auth != cfg.Auth || old.TLS != cfg.TLS {
+		s.targets.recreate()
+	}
+
+	static := make(map[string]map[string]string, len(cfg.ShellyDevices))
+	for _, device := range cfg.ShellyDevices {
+		static[device.URL] = device.Labels
+	}
+	s.targets.set(sourceStatic, static)
+}
+
+// reloadHandler reloads the configuration on POST /-/reload
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// watchConfig reloads the configuration whenever the configuration file changes
+func (s *Server) watchConfig(ctx context.Context) error {
+	file := filepath.Clean(s.currentConfig().ConfigFile)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	// Watch the directory so that files replaced by a rename are picked up as well
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("failed to watch configuration file: %w", err)
+	}
+
+	timer := time.NewTimer(configWatchDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer.Reset(configWatchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			s.logger.WithError(err).Warn("Configuration watcher error")
+		case <-timer.C:
+			_ = s.Reload()
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+}
+
+func newReloadTestServer(t *testing.T, content string) (*Server, string) {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, configFile, content)
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	server, err := New(cfg, logrus.New())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return server, configFile
+}
+
+func TestServer_Reload(t *testing.T) {
+	server, configFile := newReloadTestServer(t, `
+shelly_devices:
+  - "http://192.168.1.100"
+  - "http://192.168.1.101"
+`)
+
+	before := clientsByURL(server.collector.Clients())
+
+	writeConfig(t, configFile, `
+log_level: debug
+shelly_devices:
+  - "http://192.168.1.100"
+  - url: "http://192.168.1.101"
+    labels:
+      room: kitchen
+  - "http://192.168.1.102"
+`)
+	if err := server.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	after := clientsByURL(server.collector.Clients())
+	if len(after) != 3 {
+		t.Fatalf("Clients() length = %v, want 3", len(after))
+	}
+	if after["http://192.168.1.100"] != before["http://192.168.1.100"] {
+		t.Error("Unchanged device should keep its client")
+	}
+	if after["http://192.168.1.101"].Labels()["room"] != "kitchen" {
+		t.Errorf("Reloaded device labels = %v, want room=kitchen", after["http://192.168.1.101"].Labels())
+	}
+	if server.logger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("Log level = %v, want debug", server.logger.GetLevel())
+	}
+	if got := testutil.ToFloat64(server.lastReloadSuccessful); got != 1 {
+		t.Errorf("shelly_exporter_config_last_reload_successful = %v, want 1", got)
+	}
+
+	// Changed connection settings recreate all clients
+	writeConfig(t, configFile, `
+scrape_timeout: 5s
+shelly_devices:
+  - "http://192.168.1.100"
+`)
+	if err := server.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	clients := server.collector.Clients()
+	if len(clients) != 1 || clients[0] == before["http://192.168.1.100"] {
+		t.Errorf("Clients() = %v, want a new client for http://192.168.1.100", clients)
+	}
+
+	// An invalid configuration keeps the running one
+	writeConfig(t, configFile, `
+shelly_devices: []
+`)
+	if err := server.Reload(); err == nil {
+		t.Error("Reload() expected error for invalid configuration, got nil")
+	}
+	if got := testutil.ToFloat64(server.lastReloadSuccessful); got != 0 {
+		t.Errorf("shelly_exporter_config_last_reload_successful = %v, want 0", got)
+	}
+	if len(server.collector.Clients()) != 1 || server.currentConfig().ScrapeTimeout != 5*time.Second {
+		t.Error("Failed reload should keep the running configuration")
+	}
+}
+
+func TestServer_ReloadEndpoint(t *testing.T) {
+	server, configFile := newReloadTestServer(t, `
+shelly_devices:
+  - "http://192.168.1.100"
+`)
+
+	tests := []struct {
+		name       string
+		method     string
+		content    string
+		wantStatus int
+	}{
+		{
+			name:       "get is not allowed",
+			method:     "GET",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "successful reload",
+			method:     "POST",
+			content:    "shelly_devices:\n  - \"http://192.168.1.101\"\n",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "failed reload",
+			method:     "POST",
+			content:    "log_level: invalid\nshelly_devices:\n  - \"http://192.168.1.101\"\n",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.content != "" {
+				writeConfig(t, configFile, tt.content)
+			}
+
+			rr := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(rr, httptest.NewRequest(tt.method, "/-/reload", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("/-/reload status = %v, want %v", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	clients := server.collector.Clients()
+	if len(clients) != 1 || clients[0].BaseURL() != "http://192.168.1.101" {
+		t.Errorf("Clients() = %v, want only http://192.168.1.101", clients)
+	}
+}
+
+func TestServer_WatchConfig(t *testing.T) {
+	server, configFile := newReloadTestServer(t, `
+watch_config: true
+shelly_devices:
+  - "http://192.168.1.100"
+`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.watchConfig(ctx) }()
+
+	// Give the watcher a moment to start
+	time.Sleep(50 * time.Millisecond)
+	writeConfig(t, configFile, `
+watch_config: true
+shelly_devices:
+  - "http://192.168.1.101"
+`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		clients := server.collector.Clients()
+		if len(clients) == 1 && clients[0].BaseURL() == "http://192.168.1.101" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Configuration not reloaded after file change, clients = %v", clients)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("watchConfig() error = %v", err)
+	}
+}
@@ -4,36 +4,134 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/coiot"
 	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
 	"github.com/aimar/shelly-prometheus-exporter/internal/metrics"
+	"github.com/aimar/shelly-prometheus-exporter/internal/state"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 // Server represents the HTTP server for the Shelly Prometheus Exporter
 type Server struct {
-	config  *config.Config
-	logger  *logrus.Logger
-	server  *http.Server
-	clients []*client.Client
+	config    *config.Config
+	logger    *logrus.Logger
+	server    *http.Server
+	collector *metrics.Collector
+	coiot     *coiot.Listener
+	discovery *discovery.Manager
+	fileSD    *discovery.FileSD
+	targets   *deviceTargets
+	state     *state.FileStore
+
+	// registry holds the metrics served by this server
+	registry  *prometheus.Registry
+	buildInfo *prometheus.GaugeVec
+
+	// Configuration reload state
+	lastReloadSuccessful prometheus.Gauge
+	lastReloadTimestamp  prometheus.Gauge
+	configMu             sync.RWMutex
+	reloadMu             sync.Mutex
 }
 
 // New creates a new server instance
 func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
-	// Create clients for each Shelly device
-	var clients []*client.Client
-	for _, deviceURL := range cfg.ShellyDevices {
-		client := client.New(deviceURL, cfg, logger)
-		clients = append(clients, client)
+	// Feed Gen1 CoIoT updates into the clients
+	var listener *coiot.Listener
+	if cfg.CoIoT.Enabled {
+		listener = coiot.NewListener(cfg, logger)
+	}
+
+	// State derived by the exporter, kept in memory without a state directory
+	store, err := state.Open(cfg.StateDir, logger)
+	if err != nil {
+		return nil, err
 	}
 
+	// Every server has its own registry so that several servers can run in one process
+	registry := prometheus.NewRegistry()
+
 	// Create metrics collector
-	collector := metrics.NewCollector(clients, logger)
-	prometheus.MustRegister(collector)
+	collector := metrics.NewCollector(nil, logger)
+	collector.SetMaxStaleness(cfg.MaxStaleness)
+	if err := collector.SetStateStore(store); err != nil {
+		logger.WithError(err).Warn("Reboot counters start over")
+	}
+	if cfg.MonotonicEnergy {
+		if err := collector.EnableMonotonicEnergy(store); err != nil {
+			logger.WithError(err).Warn("Monotonic energy counters start over")
+		}
+	}
+	registry.MustRegister(collector)
+
+	srv := &Server{
+		config:    cfg,
+		logger:    logger,
+		collector: collector,
+		coiot:     listener,
+		state:     store,
+		registry:  registry,
+		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "shelly_exporter_build_info",
+			Help: "Build information of the exporter, always 1",
+		}, []string{"version", "commit", "build_time"}),
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shelly_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		}),
+		lastReloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shelly_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		}),
+	}
+	srv.lastReloadSuccessful.Set(1)
+	srv.lastReloadTimestamp.SetToCurrentTime()
+	registry.MustRegister(srv.lastReloadSuccessful, srv.lastReloadTimestamp, srv.buildInfo)
+
+	// Runtime metrics of the exporter itself are opt-in
+	if cfg.RuntimeMetrics {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+
+	// Create clients for each Shelly device
+	srv.targets = newDeviceTargets(collector, srv.newClient)
+	static := make(map[string]map[string]string, len(cfg.ShellyDevices))
+	for _, device := range cfg.ShellyDevices {
+		static[device.URL] = device.Labels
+	}
+	srv.targets.set(sourceStatic, static)
+
+	// Create discovery manager for dynamically found devices
+	if cfg.Discovery.Enabled() {
+		var sources []discovery.Source
+		if cfg.Discovery.MDNS.Enabled {
+			sources = append(sources, discovery.NewMDNSSource(cfg.Discovery.MDNS, logger))
+		}
+		if len(cfg.Discovery.Subnets) > 0 {
+			subnets, err := discovery.NewSubnetSource(cfg.Discovery.Subnets)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create subnet discovery: %w", err)
+			}
+			sources = append(sources, subnets)
+		}
+		srv.discovery = discovery.NewManager(cfg, logger, srv, sources...)
+	}
+
+	// Watch file_sd target files
+	if len(cfg.Discovery.FileSD.Files) > 0 {
+		srv.fileSD = discovery.NewFileSD(cfg.Discovery.FileSD, logger, srv)
+	}
 
 	// Create HTTP server
 	mux := http.NewServeMux()
@@ -47,7 +145,17 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
 	})
 
 	// Metrics endpoint
-	mux.Handle(cfg.MetricsPath, promhttp.Handler())
+	var metricsHandler http.Handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if cfg.RuntimeMetrics {
+		metricsHandler = promhttp.InstrumentMetricHandler(registry, metricsHandler)
+	}
+	mux.Handle(cfg.MetricsPath, metricsHandler)
+
+	// Multi-target probe endpoint
+	mux.HandleFunc("/probe", srv.probeHandler)
+
+	// Configuration reload endpoint
+	mux.HandleFunc("/-/reload", srv.reloadHandler)
 
 	// Root endpoint with basic information
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -62,6 +170,7 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
     <h1>Shelly Prometheus Exporter</h1>
     <p>Prometheus metrics are available at <a href="%s">%s</a></p>
     <p>Health check is available at <a href="/health">/health</a></p>
+    <p>Single devices can be probed at <code>/probe?target=&lt;url&gt;&amp;module=&lt;name&gt;</code></p>
     <h2>Configured Devices</h2>
     <ul>
 `, cfg.MetricsPath, cfg.MetricsPath); err != nil {
@@ -69,8 +178,8 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
 			return
 		}
 
-		for _, device := range cfg.ShellyDevices {
-			if _, err := fmt.Fprintf(w, "        <li>%s</li>\n", device); err != nil {
+		for _, device := range collector.Clients() {
+			if _, err := fmt.Fprintf(w, "        <li>%s</li>\n", config.RedactURL(device.BaseURL())); err != nil {
 				logger.Errorf("Failed to write device list: %v", err)
 				return
 			}
@@ -84,7 +193,7 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
 		}
 	})
 
-	server := &http.Server{
+	srv.server = &http.Server{
 		Addr:         cfg.ListenAddress,
 		Handler:      mux,
 		ReadTimeout:  15 * time.Second,
@@ -92,25 +201,101 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Server, error) {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	return &Server{
-		config:  cfg,
-		logger:  logger,
-		server:  server,
-		clients: clients,
-	}, nil
+	return srv, nil
+}
+
+// SetBuildInfo sets the values of the shelly_exporter_build_info metric
+func (s *Server) SetBuildInfo(version, commit, buildTime string) {
+	s.buildInfo.Reset()
+	s.buildInfo.WithLabelValues(version, commit, buildTime).Set(1)
+}
+
+// Registry returns the registry holding the metrics served by the server
+func (s *Server) Registry() *prometheus.Registry {
+	return s.registry
+}
+
+// newClient creates a client for a device with the given identity and labels
+func (s *Server) newClient(url, id string, labels map[string]string) *client.Client {
+	c := client.New(url, s.currentConfig(), s.logger)
+	c.SetID(id)
+	c.SetLabels(labels)
+	if s.coiot != nil {
+		c.SetStatusSource(s.coiot)
+	}
+	return c
+}
+
+// DeviceAdded implements discovery.Handler by adding a client for the discovered device.
+// The device is identified by its MAC address, so that its series and state are kept when
+// it changes its address.
+func (s *Server) DeviceAdded(d *discovery.Device) {
+	s.targets.add(sourceDiscovery, d.URL, d.MAC, nil)
+}
+
+// DeviceRemoved implements discovery.Handler by removing the client of a vanished device.
+// Devices that are also provided by another source keep being scraped.
+func (s *Server) DeviceRemoved(d *discovery.Device) {
+	s.targets.remove(sourceDiscovery, d.URL)
+}
+
+// TargetsChanged implements discovery.TargetsHandler by replacing the file_sd devices
+func (s *Server) TargetsChanged(targets []discovery.Target) {
+	devices := make(map[string]map[string]string, len(targets))
+	for _, target := range targets {
+		devices[target.URL] = target.Labels
+	}
+	s.targets.set(sourceFileSD, devices)
 }
 
 // Start starts the HTTP server
 func (s *Server) Start(ctx context.Context) error {
+	// Start CoIoT listener in a goroutine
+	if s.coiot != nil {
+		go func() {
+			if err := s.coiot.Run(ctx); err != nil {
+				s.logger.WithError(err).Error("CoIoT listener error")
+			}
+		}()
+	}
+
 	// Start server in a goroutine
 	go func() {
-		s.logger.WithField("address", s.config.ListenAddress).Info("Starting HTTP server")
+		s.logger.WithField("address", s.currentConfig().ListenAddress).Info("Starting HTTP server")
 
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			s.logger.WithError(err).Error("HTTP server error")
 		}
 	}()
 
+	// Start device discovery in a goroutine
+	if s.discovery != nil {
+		go s.discovery.Run(ctx)
+	}
+
+	// Start configuration file watcher in a goroutine
+	if cfg := s.currentConfig(); cfg.WatchConfig && cfg.ConfigFile != "" {
+		go func() {
+			if err := s.watchConfig(ctx); err != nil {
+				s.logger.WithError(err).Error("Configuration watcher error")
+			}
+		}()
+	}
+
+	// Start file_sd watcher in a goroutine
+	if s.fileSD != nil {
+		go func() {
+			if err := s.fileSD.Run(ctx); err != nil {
+				s.logger.WithError(err).Error("file_sd watcher error")
+			}
+		}()
+	}
+
+	// Periodically persist the state
+	if s.state.Dir() != "" {
+		go s.state.Run(ctx, s.currentConfig().StateFlushInterval)
+	}
+
 	// Wait for context cancellation
 	<-ctx.Done()
 
@@ -125,6 +310,9 @@ func (s *Server) Start(ctx context.Context) error {
 		return err
 	}
 
+	// No scrapes are running anymore
+	s.flushState()
+
 	return nil
 }
 
@@ -133,5 +321,14 @@ func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	return s.server.Shutdown(ctx)
+	err := s.server.Shutdown(ctx)
+	s.flushState()
+	return err
+}
+
+// flushState writes the state to the state directory
+func (s *Server) flushState() {
+	if err := s.state.Flush(); err != nil {
+		s.logger.WithError(err).Error("Failed to flush state")
+	}
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/metrics"
+)
+
+// Sources of scrape targets, in increasing order of label precedence
+const (
+	sourceDiscovery = "discovery"
+	sourceFileSD    = "file_sd"
+	sourceStatic    = "static"
+)
+
+var targetSources = []string{sourceDiscovery, sourceFileSD, sourceStatic}
+
+// deviceTargets keeps the collector in sync with the devices provided by several sources.
+// A device is scraped while at least one source provides it. Its labels are merged from
+// all sources providing it, with statically configured labels taking precedence.
+type deviceTargets struct {
+	collector *metrics.Collector
+	newClient func(url, id string, labels map[string]string) *client.Client
+
+	// sources maps each source to its devices and their labels
+	sources map[string]map[string]map[string]string
+	// ids maps the URLs of devices with a stable identity, such as discovered devices, to it
+	ids map[string]string
+
+	mu sync.Mutex
+}
+
+// newDeviceTargets creates an empty target set for the collector
+func newDeviceTargets(collector *metrics.Collector, newClient func(url, id string, labels map[string]string) *client.Client) *deviceTargets {
+	return &deviceTargets{
+		collector: collector,
+		newClient: newClient,
+		sources:   make(map[string]map[string]map[string]string),
+		ids:       make(map[string]string),
+	}
+}
+
+// set replaces all devices of a source
+func (t *deviceTargets) set(source string, devices map[string]map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sources[source] = devices
+	t.sync(t.collector.Clients())
+}
+
+// add adds a single device to a source. A non-empty id identifies the device across
+// changes of its URL.
+func (t *deviceTargets) add(source, url, id string, labels map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sources[source] == nil {
+		t.sources[source] = make(map[string]map[string]string)
+	}
+	t.sources[source][url] = labels
+	if id != "" {
+		t.ids[url] = id
+	}
+	t.sync(t.collector.Clients())
+}
+
+// remove removes a single device from a source
+func (t *deviceTargets) remove(source, url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.sources[source], url)
+	delete(t.ids, url)
+	t.sync(t.collector.Clients())
+}
+
+// recreate replaces all clients, e.g. after their connection settings changed
+func (t *deviceTargets) recreate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sync(nil)
+}
+
+// id returns the identity of the device at url. Configured devices keep their URL, even
+// if they are discovered as well.
+func (t *deviceTargets) id(url string) string {
+	for _, source := range []string{sourceFileSD, sourceStatic} {
+		if _, ok := t.sources[source][url]; ok {
+			return url
+		}
+	}
+	if id, ok := t.ids[url]; ok {
+		return id
+	}
+	return url
+}
+
+// sync hands the merged devices to the collector, keeping the existing clients whose
+// labels and identity did not change. A device provided under several URLs, such as a
+// discovered device while it changes its address, is only scraped once.
+func (t *deviceTargets) sync(existing []*client.Client) {
+	wanted := make(map[string]map[string]string)
+	for _, source := range targetSources {
+		for url, labels := range t.sources[source] {
+			merged, ok := wanted[url]
+			if !ok {
+				merged = make(map[string]string)
+				wanted[url] = merged
+			}
+			maps.Copy(merged, labels)
+		}
+	}
+
+	clients := make([]*client.Client, 0, len(wanted))
+	scraped := make(map[string]bool, len(wanted))
+	for _, cl := range existing {
+		labels, ok := wanted[cl.BaseURL()]
+		if !ok || !maps.Equal(labels, cl.Labels()) || cl.ID() != t.id(cl.BaseURL()) {
+			continue
+		}
+		delete(wanted, cl.BaseURL())
+		clients = append(clients, cl)
+		scraped[cl.ID()] = true
+	}
+
+	for _, url := range slices.Sorted(maps.Keys(wanted)) {
+		id := t.id(url)
+		if scraped[id] {
+			continue
+		}
+		clients = append(clients, t.newClient(url, id, wanted[url]))
+		scraped[id] = true
+	}
+
+	t.collector.SetClients(clients)
+}
@@ -0,0 +1,132 @@
+package simulator
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config holds the devices served by the simulator
+type Config struct {
+	Devices []DeviceConfig `mapstructure:"devices"`
+}
+
+// DeviceConfig holds the configuration of a single simulated device
+type DeviceConfig struct {
+	// Address the device listens on, e.g. 127.0.0.1:9101
+	Listen string `mapstructure:"listen"`
+	// Model is one of the names returned by Models
+	Model string `mapstructure:"model"`
+	// Name reported by the device, optional
+	Name string `mapstructure:"name"`
+	// MAC address reported by the device, derived from the model and address if empty
+	MAC string `mapstructure:"mac"`
+
+	// Authentication, enabled when a password is set
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+
+	// Energy is the energy total in Wh the device starts with
+	Energy float64 `mapstructure:"energy"`
+
+	Profile ProfileConfig `mapstructure:"profile"`
+	Faults  FaultConfig   `mapstructure:"faults"`
+}
+
+// FaultConfig holds the faults injected into the responses of a device.
+// Rates are probabilities between 0 and 1 evaluated for every request.
+type FaultConfig struct {
+	// Delay added to every response
+	Delay time.Duration `mapstructure:"delay"`
+	// TimeoutRate is the share of requests that never get a response
+	TimeoutRate float64 `mapstructure:"timeout_rate"`
+	// MalformedRate is the share of requests answered with truncated JSON
+	MalformedRate float64 `mapstructure:"malformed_rate"`
+	// ErrorRate is the share of requests answered with HTTP 500
+	ErrorRate float64 `mapstructure:"error_rate"`
+}
+
+// LoadConfig reads a simulator configuration file
+func LoadConfig(cfgFile string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(cfgFile)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+// Validate validates the simulator configuration
+func (c *Config) Validate() error {
+	var errors []string
+
+	if len(c.Devices) == 0 {
+		errors = append(errors, "at least one device must be configured")
+	}
+
+	listeners := make(map[string]bool, len(c.Devices))
+	for i, device := range c.Devices {
+		key := fmt.Sprintf("devices[%d]", i)
+
+		if device.Listen == "" {
+			errors = append(errors, key+".listen cannot be empty")
+		} else if listeners[device.Listen] {
+			errors = append(errors, fmt.Sprintf("%s.listen %q is used by another device", key, device.Listen))
+		}
+		// Port 0 is assigned by the system and can be shared
+		if _, port, err := net.SplitHostPort(device.Listen); err != nil || port != "0" {
+			listeners[device.Listen] = true
+		}
+
+		errors = append(errors, device.validate(key)...)
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("validation failed: %s", strings.Join(errors, "; "))
+	}
+
+	return nil
+}
+
+// validate validates the device settings and returns the problems found
+func (d DeviceConfig) validate(key string) []string {
+	var errors []string
+
+	if _, ok := lookupModel(d.Model); !ok {
+		errors = append(errors, fmt.Sprintf("%s.model %q is unknown, use one of %s", key, d.Model, strings.Join(Models(), ", ")))
+	}
+
+	errors = append(errors, d.Profile.validate(key+".profile")...)
+
+	for name, rate := range map[string]float64{
+		"timeout_rate":   d.Faults.TimeoutRate,
+		"malformed_rate": d.Faults.MalformedRate,
+		"error_rate":     d.Faults.ErrorRate,
+	} {
+		if rate < 0 || rate > 1 {
+			errors = append(errors, fmt.Sprintf("%s.faults.%s must be between 0 and 1", key, name))
+		}
+	}
+	if d.Energy < 0 {
+		errors = append(errors, key+".energy must not be negative")
+	}
+	if d.Faults.Delay < 0 {
+		errors = append(errors, key+".faults.delay must not be negative")
+	}
+
+	return errors
+}
@@ -0,0 +1,135 @@
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "simulator.yaml")
+	content := `
+devices:
+  - listen: "127.0.0.1:9101"
+    model: pro3em
+    name: Main Meter
+    password: secret
+    energy: 125000
+    profile:
+      type: sine
+      watts: 1500
+      amplitude: 1000
+      period: 10m
+  - listen: "127.0.0.1:9102"
+    model: 1pm
+    profile:
+      type: steps
+      steps:
+        - watts: 5
+          duration: 30s
+        - watts: 800
+          duration: 1m
+    faults:
+      delay: 50ms
+      timeout_rate: 0.1
+      malformed_rate: 0.05
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if len(cfg.Devices) != 2 {
+		t.Fatalf("Devices length = %v, want 2", len(cfg.Devices))
+	}
+
+	meter := cfg.Devices[0]
+	if meter.Name != "Main Meter" || meter.Password != "secret" || meter.Energy != 125000 {
+		t.Errorf("Devices[0] = %+v", meter)
+	}
+	if meter.Profile.Type != ProfileSine || meter.Profile.Period != 10*time.Minute {
+		t.Errorf("Devices[0].Profile = %+v", meter.Profile)
+	}
+
+	relay := cfg.Devices[1]
+	if len(relay.Profile.Steps) != 2 || relay.Profile.Steps[1].Duration != time.Minute {
+		t.Errorf("Devices[1].Profile.Steps = %+v", relay.Profile.Steps)
+	}
+	if relay.Faults.Delay != 50*time.Millisecond || relay.Faults.TimeoutRate != 0.1 || relay.Faults.MalformedRate != 0.05 {
+		t.Errorf("Devices[1].Faults = %+v", relay.Faults)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: Config{Devices: []DeviceConfig{
+				{Listen: ":9101", Model: "pro3em"},
+				{Listen: ":9102", Model: "ht"},
+			}},
+		},
+		{
+			name: "shared system assigned port",
+			config: Config{Devices: []DeviceConfig{
+				{Listen: "127.0.0.1:0", Model: "pro3em"},
+				{Listen: "127.0.0.1:0", Model: "ht"},
+			}},
+		},
+		{
+			name:    "no devices",
+			config:  Config{},
+			wantErr: "at least one device must be configured",
+		},
+		{
+			name:    "missing listen address",
+			config:  Config{Devices: []DeviceConfig{{Model: "pro3em"}}},
+			wantErr: "devices[0].listen cannot be empty",
+		},
+		{
+			name: "duplicate listen address",
+			config: Config{Devices: []DeviceConfig{
+				{Listen: ":9101", Model: "pro3em"},
+				{Listen: ":9101", Model: "1pm"},
+			}},
+			wantErr: "is used by another device",
+		},
+		{
+			name:    "unknown model",
+			config:  Config{Devices: []DeviceConfig{{Listen: ":9101", Model: "pro4em"}}},
+			wantErr: `devices[0].model "pro4em" is unknown`,
+		},
+		{
+			name: "invalid faults",
+			config: Config{Devices: []DeviceConfig{
+				{Listen: ":9101", Model: "1pm", Faults: FaultConfig{ErrorRate: -0.5, Delay: -time.Second}},
+			}},
+			wantErr: "devices[0].faults.error_rate must be between 0 and 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+package simulator
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Model describes a simulated Shelly device model
+type Model struct {
+	// Name is the name used in the configuration, e.g. pro3em
+	Name string
+	// Type is the model identifier reported by the device, e.g. SPEM-003CEBEU
+	Type string
+	// App is the Gen2 application name, e.g. Pro3EM
+	App string
+	// Gen is the device generation
+	Gen int
+	// Firmware is the firmware id reported by the device
+	Firmware string
+	// Version is the firmware version reported by the device
+	Version string
+	// Phases is the number of phases of a Gen2 energy meter
+	Phases int
+	// Relays is the number of switched outputs
+	Relays int
+	// Sensor is set for battery powered temperature and humidity sensors
+	Sensor bool
+}
+
+// models are the simulated device models by configuration name
+var models = map[string]Model{
+	"pro3em": {
+		Name:     "pro3em",
+		Type:     "SPEM-003CEBEU",
+		App:      "Pro3EM",
+		Gen:      2,
+		Firmware: "20231107-164738/1.1.0-g34b5d4f",
+		Version:  "1.1.0",
+		Phases:   3,
+	},
+	"plus1pm": {
+		Name:     "plus1pm",
+		Type:     "SNSW-001P16EU",
+		App:      "Plus1PM",
+		Gen:      2,
+		Firmware: "20231106-152106/1.0.8-gd0fb8d5",
+		Version:  "1.0.8",
+		Relays:   1,
+	},
+	"1pm": {
+		Name:     "1pm",
+		Type:     "SHSW-PM",
+		Gen:      1,
+		Firmware: "20230913-112003/v1.14.0-gcb84623",
+		Version:  "v1.14.0",
+		Relays:   1,
+	},
+	"plugs": {
+		Name:     "plugs",
+		Type:     "SHPLG-S",
+		Gen:      1,
+		Firmware: "20230913-112316/v1.14.0-gcb84623",
+		Version:  "v1.14.0",
+		Relays:   1,
+	},
+	"ht": {
+		Name:     "ht",
+		Type:     "SHHT-1",
+		Gen:      1,
+		Firmware: "20230913-112234/v1.14.0-gcb84623",
+		Version:  "v1.14.0",
+		Sensor:   true,
+	},
+}
+
+// Models returns the names of the simulated device models
+func Models() []string {
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupModel returns the model with the given configuration name
+func lookupModel(name string) (Model, bool) {
+	model, ok := models[strings.ToLower(name)]
+	return model, ok
+}
+
+// Per phase share of the load and line voltage of a simulated three phase meter
+var (
+	phaseShares   = []float64{0.45, 0.35, 0.2}
+	phaseVoltages = []float64{230.1, 229.4, 231.2}
+)
+
+// Electrical characteristics of the simulated loads
+const (
+	powerFactor = 0.95
+	frequency   = 50.0
+	voltage     = 230.4
+)
+
+// Readings of the simulated H&T sensor
+const (
+	sensorTemperature = 21.5
+	sensorHumidity    = 45.0
+	sensorBattery     = 87
+)
+
+// snapshot is the state of a device at the time of a request
+type snapshot struct {
+	now      time.Time
+	uptime   int
+	power    float64
+	energyWh float64
+	ip       string
+}
+
+// hostname returns the hostname and Gen2 device id, e.g. shellypro3em-c8f09e1a2b3c
+func (d *Device) hostname() string {
+	prefix := "shelly" + d.model.Name
+	if d.model.Gen == 1 {
+		prefix = map[string]string{
+			"1pm":   "shelly1pm",
+			"plugs": "shellyplug-s",
+			"ht":    "shellyht",
+		}[d.model.Name]
+	}
+	return prefix + "-" + strings.ToLower(d.mac)
+}
+
+// shellyInfo returns the /shelly document
+func (d *Device) shellyInfo() map[string]interface{} {
+	if d.model.Gen == 1 {
+		return map[string]interface{}{
+			"type":         d.model.Type,
+			"mac":          d.mac,
+			"auth":         d.authEnabled(),
+			"fw":           d.model.Firmware,
+			"discoverable": true,
+			"longid":       1,
+			"num_outputs":  d.model.Relays,
+			"num_meters":   d.model.Relays,
+		}
+	}
+
+	var name interface{}
+	if d.config.Name != "" {
+		name = d.config.Name
+	}
+	var authDomain interface{}
+	if d.authEnabled() {
+		authDomain = d.hostname()
+	}
+
+	return map[string]interface{}{
+		"name":        name,
+		"id":          d.hostname(),
+		"mac":         d.mac,
+		"slot":        0,
+		"model":       d.model.Type,
+		"gen":         d.model.Gen,
+		"fw_id":       d.model.Firmware,
+		"ver":         d.model.Version,
+		"app":         d.model.App,
+		"auth_en":     d.authEnabled(),
+		"auth_domain": authDomain,
+	}
+}
+
+// gen2Status returns the Shelly.GetStatus document of a Gen2 device
+func (d *Device) gen2Status(s snapshot) map[string]interface{} {
+	status := map[string]interface{}{
+		"sys":   d.gen2SysStatus(s),
+		"wifi":  d.gen2WifiStatus(s),
+		"cloud": map[string]interface{}{"connected": true},
+		"mqtt":  map[string]interface{}{"connected": false},
+	}
+
+	if d.model.Phases > 0 {
+		status["em:0"] = d.emStatus(s)
+		status["emdata:0"] = d.emDataStatus(s)
+		status["temperature:0"] = temperature(deviceTemperature(s.power))
+	}
+
+	for id := 0; id < d.model.Relays; id++ {
+		status[componentKey("switch", id)] = d.switchStatus(id, s)
+	}
+
+	return status
+}
+
+// gen2SysStatus returns the Sys.GetStatus document
+func (d *Device) gen2SysStatus(s snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"mac":               d.mac,
+		"restart_required":  false,
+		"time":              s.now.Format("15:04"),
+		"unixtime":          s.now.Unix(),
+		"last_sync_ts":      s.now.Unix() - int64(s.uptime%3600),
+		"uptime":            s.uptime,
+		"ram_size":          247316,
+		"ram_free":          117876,
+		"ram_min_free":      98932,
+		"fs_size":           524288,
+		"fs_free":           176128,
+		"cfg_rev":           12,
+		"kvs_rev":           0,
+		"schedule_rev":      0,
+		"webhook_rev":       0,
+		"available_updates": map[string]interface{}{},
+		"reset_reason":      3,
+	}
+}
+
+// gen2WifiStatus returns the Wifi.GetStatus document
+func (d *Device) gen2WifiStatus(s snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"sta_ip": s.ip,
+		"status": "got ip",
+		"ssid":   "Simulated",
+		"rssi":   -58,
+	}
+}
+
+// emStatus returns the EM.GetStatus document of a three phase energy meter
+func (d *Device) emStatus(s snapshot) map[string]interface{} {
+	status := map[string]interface{}{"id": 0}
+
+	var totalCurrent, totalAprtPower float64
+	for i, phase := range []string{"a", "b", "c"} {
+		power := round(s.power * phaseShares[i])
+		current := round(power / (phaseVoltages[i] * powerFactor))
+		aprtPower := round(phaseVoltages[i] * current)
+		totalCurrent += current
+		totalAprtPower += aprtPower
+
+		status[phase+"_current"] = current
+		status[phase+"_voltage"] = phaseVoltages[i]
+		status[phase+"_act_power"] = power
+		status[phase+"_aprt_power"] = aprtPower
+		status[phase+"_pf"] = powerFactor
+		status[phase+"_freq"] = frequency
+	}
+
+	status["n_current"] = nil
+	status["total_current"] = round(totalCurrent)
+	status["total_act_power"] = round(s.power)
+	status["total_aprt_power"] = round(totalAprtPower)
+	status["user_calibrated_phase"] = []string{}
+
+	return status
+}
+
+// emDataStatus returns the EMData.GetStatus document of a three phase energy meter
+func (d *Device) emDataStatus(s snapshot) map[string]interface{} {
+	status := map[string]interface{}{"id": 0}
+	for i, phase := range []string{"a", "b", "c"} {
+		status[phase+"_total_act_energy"] = round(s.energyWh * phaseShares[i])
+		status[phase+"_total_act_ret_energy"] = 0.0
+	}
+	status["total_act"] = round(s.energyWh)
+	status["total_act_ret"] = 0.0
+	return status
+}
+
+// switchStatus returns the Switch.GetStatus document of an output with power metering
+func (d *Device) switchStatus(id int, s snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          id,
+		"source":      "init",
+		"output":      s.power > 0,
+		"apower":      round(s.power),
+		"voltage":     voltage,
+		"freq":        frequency,
+		"current":     round(s.power / (voltage * powerFactor)),
+		"pf":          powerFactor,
+		"aenergy":     map[string]interface{}{"total": round(s.energyWh), "by_minute": []float64{0, 0, 0}, "minute_ts": s.now.Unix()},
+		"temperature": temperature(deviceTemperature(s.power)),
+	}
+}
+
+// gen2Config returns the Shelly.GetConfig document of a Gen2 device
+func (d *Device) gen2Config() map[string]interface{} {
+	return map[string]interface{}{
+		"sys": d.gen2SysConfig(),
+		"wifi": map[string]interface{}{
+			"sta": map[string]interface{}{"ssid": "Simulated", "enable": true, "ipv4mode": "dhcp"},
+		},
+		"cloud": map[string]interface{}{"enable": true, "server": "shelly-103-eu.shelly.cloud:6022/jrpc"},
+		"mqtt":  map[string]interface{}{"enable": false},
+	}
+}
+
+// gen2SysConfig returns the Sys.GetConfig document of a Gen2 device
+func (d *Device) gen2SysConfig() map[string]interface{} {
+	var name interface{}
+	if d.config.Name != "" {
+		name = d.config.Name
+	}
+	return map[string]interface{}{
+		"device": map[string]interface{}{
+			"name":         name,
+			"mac":          d.mac,
+			"fw_id":        d.model.Firmware,
+			"eco_mode":     false,
+			"discoverable": true,
+		},
+		"location": map[string]interface{}{"tz": "Europe/Berlin", "lat": 52.52, "lon": 13.405},
+		"sntp":     map[string]interface{}{"server": "time.google.com"},
+		"cfg_rev":  12,
+	}
+}
+
+// gen2DeviceInfo returns the Shelly.GetDeviceInfo document of a Gen2 device
+func (d *Device) gen2DeviceInfo() map[string]interface{} {
+	info := d.shellyInfo()
+	info["profile"] = "default"
+	if d.model.Relays > 0 {
+		info["profile"] = "switch"
+	}
+	if d.model.Phases > 0 {
+		info["profile"] = "triphase"
+	}
+	return info
+}
+
+// gen1Status returns the /status document of a Gen1 device
+func (d *Device) gen1Status(s snapshot) map[string]interface{} {
+	status := map[string]interface{}{
+		"wifi_sta": map[string]interface{}{
+			"connected": true,
+			"ssid":      "Simulated",
+			"ip":        s.ip,
+			"rssi":      -61,
+		},
+		"cloud":           map[string]interface{}{"enabled": true, "connected": true},
+		"mqtt":            map[string]interface{}{"connected": false},
+		"time":            s.now.Format("15:04"),
+		"unixtime":        s.now.Unix(),
+		"serial":          s.uptime / 30,
+		"has_update":      false,
+		"mac":             d.mac,
+		"cfg_changed_cnt": 0,
+		"update": map[string]interface{}{
+			"status":      "idle",
+			"has_update":  false,
+			"new_version": d.model.Firmware,
+			"old_version": d.model.Firmware,
+		},
+		"ram_total": 50592,
+		"ram_free":  38852,
+		"fs_size":   233681,
+		"fs_free":   150851,
+		"uptime":    s.uptime,
+	}
+
+	if d.model.Sensor {
+		status["tmp"] = map[string]interface{}{
+			"value":    sensorTemperature,
+			"units":    "C",
+			"tC":       sensorTemperature,
+			"tF":       round(sensorTemperature*9/5 + 32),
+			"is_valid": true,
+		}
+		status["hum"] = map[string]interface{}{"value": sensorHumidity, "is_valid": true}
+		status["bat"] = map[string]interface{}{"value": sensorBattery, "voltage": 2.91}
+		status["act_reasons"] = []string{"sensor"}
+		status["sensor_error"] = 0
+		return status
+	}
+
+	relays := make([]map[string]interface{}, 0, d.model.Relays)
+	meters := make([]map[string]interface{}, 0, d.model.Relays)
+	for id := 0; id < d.model.Relays; id++ {
+		relays = append(relays, d.gen1Relay(s))
+		meters = append(meters, d.gen1Meter(s))
+	}
+	status["relays"] = relays
+	status["meters"] = meters
+
+	deviceTemp := deviceTemperature(s.power)
+	status["temperature"] = deviceTemp
+	status["overtemperature"] = false
+	status["tmp"] = map[string]interface{}{
+		"tC":       deviceTemp,
+		"tF":       round(deviceTemp*9/5 + 32),
+		"is_valid": true,
+	}
+	status["temperature_status"] = "Normal"
+
+	return status
+}
+
+// gen1Relay returns a relays entry of a Gen1 device
+func (d *Device) gen1Relay(s snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"ison":            s.power > 0,
+		"has_timer":       false,
+		"timer_started":   0,
+		"timer_duration":  0,
+		"timer_remaining": 0,
+		"overpower":       false,
+		"is_valid":        true,
+		"source":          "input",
+	}
+}
+
+// gen1Meter returns a meters entry of a Gen1 device. Gen1 devices report the
+// energy total in watt-minutes.
+func (d *Device) gen1Meter(s snapshot) map[string]interface{} {
+	power := round(s.power)
+	return map[string]interface{}{
+		"power":     power,
+		"overpower": 0.0,
+		"is_valid":  true,
+		"timestamp": s.now.Unix(),
+		"counters":  []float64{power, power, power},
+		"total":     int64(s.energyWh * 60),
+	}
+}
+
+// gen1Settings returns the /settings document of a Gen1 device
+func (d *Device) gen1Settings() map[string]interface{} {
+	var name interface{}
+	if d.config.Name != "" {
+		name = d.config.Name
+	}
+	return map[string]interface{}{
+		"device": map[string]interface{}{
+			"type":        d.model.Type,
+			"mac":         d.mac,
+			"hostname":    d.hostname(),
+			"num_outputs": d.model.Relays,
+			"num_meters":  d.model.Relays,
+		},
+		"wifi_sta": map[string]interface{}{"enabled": true, "ssid": "Simulated", "ipv4_method": "dhcp"},
+		"login":    map[string]interface{}{"enabled": d.authEnabled(), "unprotected": false, "username": d.username()},
+		"name":     name,
+		"fw":       d.model.Firmware,
+		"timezone": "Europe/Berlin",
+		"lat":      52.52,
+		"lng":      13.405,
+	}
+}
+
+// deviceTemperature returns an internal device temperature that rises with the load
+func deviceTemperature(power float64) float64 {
+	return round(35 + power/100)
+}
+
+// temperature returns a temperature component document
+func temperature(tC float64) map[string]interface{} {
+	return map[string]interface{}{
+		"id": 0,
+		"tC": tC,
+		"tF": round(tC*9/5 + 32),
+	}
+}
+
+// componentKey returns the status key of a Gen2 component instance, e.g. switch:0
+func componentKey(component string, id int) string {
+	return component + ":" + strconv.Itoa(id)
+}
+
+// round rounds a reading to the precision reported by Shelly devices
+func round(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
@@ -0,0 +1,111 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Load profile types
+const (
+	ProfileConstant = "constant"
+	ProfileSine     = "sine"
+	ProfileSteps    = "steps"
+	ProfileRandom   = "random"
+)
+
+// defaultProfileWatts is the load of a device without a configured profile
+const defaultProfileWatts = 100
+
+// ProfileConfig describes the active power drawn by a simulated device over time
+type ProfileConfig struct {
+	// Type is one of constant, sine, steps or random, constant if empty
+	Type string `mapstructure:"type"`
+	// Watts is the constant load, the sine midpoint or the random mean
+	Watts float64 `mapstructure:"watts"`
+	// Amplitude is the sine amplitude or the maximum random deviation
+	Amplitude float64 `mapstructure:"amplitude"`
+	// Period of a sine cycle
+	Period time.Duration `mapstructure:"period"`
+	// Steps are played in order and repeated
+	Steps []StepConfig `mapstructure:"steps"`
+}
+
+// StepConfig is a load held for a duration
+type StepConfig struct {
+	Watts    float64       `mapstructure:"watts"`
+	Duration time.Duration `mapstructure:"duration"`
+}
+
+// validate validates the profile and returns the problems found
+func (p ProfileConfig) validate(key string) []string {
+	var errors []string
+
+	switch p.Type {
+	case "", ProfileConstant, ProfileRandom:
+	case ProfileSine:
+		if p.Period <= 0 {
+			errors = append(errors, key+".period must be positive")
+		}
+	case ProfileSteps:
+		if len(p.Steps) == 0 {
+			errors = append(errors, key+".steps cannot be empty")
+		}
+		for i, step := range p.Steps {
+			if step.Duration <= 0 {
+				errors = append(errors, fmt.Sprintf("%s.steps[%d].duration must be positive", key, i))
+			}
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("%s.type %q is unknown, use constant, sine, steps or random", key, p.Type))
+	}
+
+	if p.Watts < 0 {
+		errors = append(errors, key+".watts must not be negative")
+	}
+
+	return errors
+}
+
+// Power returns the active power in watts after the device has been running for elapsed.
+// The result is never negative.
+func (p ProfileConfig) Power(elapsed time.Duration) float64 {
+	var watts float64
+
+	switch p.Type {
+	case ProfileSine:
+		phase := 2 * math.Pi * float64(elapsed%p.Period) / float64(p.Period)
+		watts = p.Watts + p.Amplitude*math.Sin(phase)
+	case ProfileSteps:
+		var cycle time.Duration
+		for _, step := range p.Steps {
+			cycle += step.Duration
+		}
+		offset := elapsed % cycle
+		for _, step := range p.Steps {
+			if offset < step.Duration {
+				watts = step.Watts
+				break
+			}
+			offset -= step.Duration
+		}
+	case ProfileRandom:
+		watts = p.Watts + p.Amplitude*(2*rand.Float64()-1)
+	default:
+		watts = p.Watts
+	}
+
+	return math.Max(watts, 0)
+}
+
+// withDefaults returns the profile with the default load applied when no type is configured
+func (p ProfileConfig) withDefaults() ProfileConfig {
+	if p.Type == "" {
+		p.Type = ProfileConstant
+		if p.Watts == 0 {
+			p.Watts = defaultProfileWatts
+		}
+	}
+	return p
+}
@@ -0,0 +1,107 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfileConfig_Power(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile ProfileConfig
+		elapsed time.Duration
+		want    float64
+	}{
+		{
+			name:    "default",
+			profile: ProfileConfig{}.withDefaults(),
+			want:    defaultProfileWatts,
+		},
+		{
+			name:    "explicit zero load",
+			profile: ProfileConfig{Type: ProfileConstant}.withDefaults(),
+			want:    0,
+		},
+		{
+			name:    "sine midpoint",
+			profile: ProfileConfig{Type: ProfileSine, Watts: 500, Amplitude: 200, Period: time.Hour},
+			elapsed: 0,
+			want:    500,
+		},
+		{
+			name:    "sine peak",
+			profile: ProfileConfig{Type: ProfileSine, Watts: 500, Amplitude: 200, Period: time.Hour},
+			elapsed: 15 * time.Minute,
+			want:    700,
+		},
+		{
+			name:    "sine is never negative",
+			profile: ProfileConfig{Type: ProfileSine, Watts: 100, Amplitude: 200, Period: time.Hour},
+			elapsed: 45 * time.Minute,
+			want:    0,
+		},
+		{
+			name: "second step",
+			profile: ProfileConfig{Type: ProfileSteps, Steps: []StepConfig{
+				{Watts: 10, Duration: time.Minute},
+				{Watts: 2000, Duration: 2 * time.Minute},
+			}},
+			elapsed: 90 * time.Second,
+			want:    2000,
+		},
+		{
+			name: "steps repeat",
+			profile: ProfileConfig{Type: ProfileSteps, Steps: []StepConfig{
+				{Watts: 10, Duration: time.Minute},
+				{Watts: 2000, Duration: 2 * time.Minute},
+			}},
+			elapsed: 3*time.Minute + 30*time.Second,
+			want:    10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := round(tt.profile.Power(tt.elapsed)); got != tt.want {
+				t.Errorf("Power() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileConfig_PowerRandom(t *testing.T) {
+	profile := ProfileConfig{Type: ProfileRandom, Watts: 300, Amplitude: 50}
+
+	for i := 0; i < 100; i++ {
+		if got := profile.Power(time.Duration(i) * time.Second); got < 250 || got > 350 {
+			t.Fatalf("Power() = %v, want between 250 and 350", got)
+		}
+	}
+}
+
+func TestProfileConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile ProfileConfig
+		wantErr bool
+	}{
+		{name: "empty", profile: ProfileConfig{}},
+		{name: "unknown type", profile: ProfileConfig{Type: "square"}, wantErr: true},
+		{name: "sine without period", profile: ProfileConfig{Type: ProfileSine}, wantErr: true},
+		{name: "steps without steps", profile: ProfileConfig{Type: ProfileSteps}, wantErr: true},
+		{
+			name:    "step without duration",
+			profile: ProfileConfig{Type: ProfileSteps, Steps: []StepConfig{{Watts: 10}}},
+			wantErr: true,
+		},
+		{name: "negative load", profile: ProfileConfig{Watts: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errors := tt.profile.validate("profile"); (len(errors) > 0) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", errors, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package simulator
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultUsername is the user name of Shelly devices with authentication enabled
+const defaultUsername = "admin"
+
+// macPrefix is the vendor prefix of derived MAC addresses
+const macPrefix = "C8F09E"
+
+// nonceValidity is how long a digest authentication nonce is accepted
+const nonceValidity = 10 * time.Minute
+
+// Shelly digest authentication constants
+const (
+	authAlgorithm = "SHA-256"
+	authQOP       = "auth"
+	authHA2Input  = "dummy_method:dummy_uri"
+)
+
+// Device is a simulated Shelly device serving the Gen1 or Gen2 HTTP API
+type Device struct {
+	config  DeviceConfig
+	model   Model
+	mac     string
+	profile ProfileConfig
+	start   time.Time
+	now     func() time.Time
+
+	mu         sync.Mutex
+	energyWh   float64
+	lastUpdate time.Time
+}
+
+// rpcRequest is a JSON-RPC 2.0 request frame
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Src    string          `json:"src"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Auth   *rpcAuth        `json:"auth"`
+}
+
+// rpcAuth is the authentication object of a request to a protected device
+type rpcAuth struct {
+	Realm     string      `json:"realm"`
+	Username  string      `json:"username"`
+	Nonce     json.Number `json:"nonce"`
+	CNonce    string      `json:"cnonce"`
+	Response  string      `json:"response"`
+	Algorithm string      `json:"algorithm"`
+}
+
+// rpcError is the error object of a JSON-RPC 2.0 response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// status returns the HTTP status code of a response carrying the error
+func (e *rpcError) status() int {
+	if e.Code >= 400 && e.Code < 600 {
+		return e.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// NewDevice creates a simulated device
+func NewDevice(cfg DeviceConfig) (*Device, error) {
+	if errors := cfg.validate("device"); len(errors) > 0 {
+		return nil, fmt.Errorf("invalid device: %s", strings.Join(errors, "; "))
+	}
+
+	model, _ := lookupModel(cfg.Model)
+
+	mac := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(cfg.MAC))
+	if mac == "" {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(model.Name + "/" + cfg.Listen + "/" + cfg.Name))
+		mac = fmt.Sprintf("%s%06X", macPrefix, hash.Sum32()&0xFFFFFF)
+	}
+
+	now := time.Now()
+	return &Device{
+		config:     cfg,
+		model:      model,
+		mac:        mac,
+		profile:    cfg.Profile.withDefaults(),
+		start:      now,
+		now:        time.Now,
+		lastUpdate: now,
+	}, nil
+}
+
+// Model returns the simulated model
+func (d *Device) Model() Model {
+	return d.model
+}
+
+// MAC returns the MAC address reported by the device
+func (d *Device) MAC() string {
+	return d.mac
+}
+
+// authEnabled reports whether requests must be authenticated
+func (d *Device) authEnabled() bool {
+	return d.config.Password != ""
+}
+
+// username returns the user name accepted by the device
+func (d *Device) username() string {
+	if d.config.Username != "" {
+		return d.config.Username
+	}
+	return defaultUsername
+}
+
+// snapshot advances the simulated energy counter and returns the device state
+func (d *Device) snapshot(r *http.Request) snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var power float64
+	if !d.model.Sensor {
+		power = d.profile.Power(now.Sub(d.start))
+	}
+	if elapsed := now.Sub(d.lastUpdate); elapsed > 0 {
+		d.energyWh += power * elapsed.Hours()
+	}
+	d.lastUpdate = now
+
+	s := snapshot{
+		now:      now,
+		uptime:   int(now.Sub(d.start).Seconds()),
+		power:    power,
+		energyWh: d.config.Energy + d.energyWh,
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			s.ip = host
+		}
+	}
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (d *Device) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.injectFault(w, r) {
+		return
+	}
+
+	if r.URL.Path == "/shelly" {
+		writeJSON(w, http.StatusOK, d.shellyInfo())
+		return
+	}
+
+	if d.model.Gen >= 2 {
+		d.serveGen2(w, r)
+		return
+	}
+	d.serveGen1(w, r)
+}
+
+// injectFault applies the configured faults and reports whether the request was answered
+func (d *Device) injectFault(w http.ResponseWriter, r *http.Request) bool {
+	faults := d.config.Faults
+
+	if faults.Delay > 0 {
+		select {
+		case <-time.After(faults.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	switch {
+	case roll(faults.TimeoutRate):
+		// Never answer, the client gives up on its own timeout
+		<-r.Context().Done()
+		return true
+	case roll(faults.MalformedRate):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"sys":{"mac":"` + d.mac + `","uptime":`))
+		return true
+	case roll(faults.ErrorRate):
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	}
+
+	return false
+}
+
+// serveGen1 serves the Gen1 HTTP API. Known endpoints are protected by basic authentication.
+func (d *Device) serveGen1(w http.ResponseWriter, r *http.Request) {
+	var handler func() interface{}
+
+	path := r.URL.Path
+	switch {
+	case path == "/status":
+		handler = func() interface{} { return d.gen1Status(d.snapshot(r)) }
+	case path == "/settings":
+		handler = func() interface{} { return d.gen1Settings() }
+	case strings.HasPrefix(path, "/meter/") && validIndex(strings.TrimPrefix(path, "/meter/"), d.model.Relays):
+		handler = func() interface{} { return d.gen1Meter(d.snapshot(r)) }
+	case strings.HasPrefix(path, "/relay/") && validIndex(strings.TrimPrefix(path, "/relay/"), d.model.Relays):
+		handler = func() interface{} { return d.gen1Relay(d.snapshot(r)) }
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if d.authEnabled() {
+		username, password, ok := r.BasicAuth()
+		if !ok || !equal(username, d.username()) || !equal(password, d.config.Password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+d.hostname()+`"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, handler())
+}
+
+// serveGen2 serves the Gen2 RPC API over GET /rpc/<method> and JSON-RPC POST /rpc.
+// Protected devices only accept requests authenticated through the JSON-RPC auth object.
+func (d *Device) serveGen2(w http.ResponseWriter, r *http.Request) {
+	if method, ok := strings.CutPrefix(r.URL.Path, "/rpc/"); ok && r.Method == http.MethodGet {
+		if d.authEnabled() {
+			d.writeChallenge(w, nil)
+			return
+		}
+		result, rpcErr := d.dispatch(r, method, queryParams(r))
+		if rpcErr != nil {
+			writeJSON(w, rpcErr.status(), rpcErr)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	if r.URL.Path != "/rpc" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var request rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"id":    nil,
+			"src":   d.hostname(),
+			"error": rpcError{Code: -32700, Message: "Parse error"},
+		})
+		return
+	}
+
+	if d.authEnabled() && !d.authenticated(request.Auth) {
+		d.writeChallenge(w, request.ID)
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":  request.ID,
+		"src": d.hostname(),
+	}
+	if request.Src != "" {
+		response["dst"] = request.Src
+	}
+
+	result, rpcErr := d.dispatch(r, request.Method, request.Params)
+	if rpcErr != nil {
+		response["error"] = rpcErr
+		writeJSON(w, rpcErr.status(), response)
+		return
+	}
+	response["result"] = result
+	writeJSON(w, http.StatusOK, response)
+}
+
+// dispatch runs an RPC method and returns its result
+func (d *Device) dispatch(r *http.Request, method string, params json.RawMessage) (interface{}, *rpcError) {
+	switch method {
+	case "Shelly.GetStatus":
+		return d.gen2Status(d.snapshot(r)), nil
+	case "Shelly.GetDeviceInfo":
+		return d.gen2DeviceInfo(), nil
+	case "Shelly.GetConfig":
+		return d.gen2Config(), nil
+	case "Sys.GetStatus":
+		return d.gen2SysStatus(d.snapshot(r)), nil
+	case "Sys.GetConfig":
+		return d.gen2SysConfig(), nil
+	case "Wifi.GetStatus":
+		return d.gen2WifiStatus(d.snapshot(r)), nil
+	}
+
+	switch {
+	case method == "EM.GetStatus" && d.model.Phases > 0:
+		if _, err := componentID(params, 1); err != nil {
+			return nil, err
+		}
+		return d.emStatus(d.snapshot(r)), nil
+	case method == "EMData.GetStatus" && d.model.Phases > 0:
+		if _, err := componentID(params, 1); err != nil {
+			return nil, err
+		}
+		return d.emDataStatus(d.snapshot(r)), nil
+	case method == "Switch.GetStatus" && d.model.Relays > 0:
+		id, err := componentID(params, d.model.Relays)
+		if err != nil {
+			return nil, err
+		}
+		return d.switchStatus(id, d.snapshot(r)), nil
+	}
+
+	return nil, &rpcError{Code: http.StatusNotFound, Message: "No handler for " + method}
+}
+
+// componentID returns the id parameter of a component method
+func componentID(params json.RawMessage, count int) (int, *rpcError) {
+	var p struct {
+		ID *int `json:"id"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return 0, &rpcError{Code: -103, Message: "Invalid argument 'id'"}
+		}
+	}
+	if p.ID == nil {
+		return 0, &rpcError{Code: -103, Message: "Missing required argument 'id'!"}
+	}
+	if *p.ID < 0 || *p.ID >= count {
+		return 0, &rpcError{Code: -105, Message: fmt.Sprintf("Argument 'id', value %d not found!", *p.ID)}
+	}
+	return *p.ID, nil
+}
+
+// queryParams returns the parameters of a GET RPC request, e.g. /rpc/EMData.GetStatus?id=0
+func queryParams(r *http.Request) json.RawMessage {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+
+	params := make(map[string]interface{}, len(query))
+	for key := range query {
+		value := query.Get(key)
+		if number, err := strconv.Atoi(value); err == nil {
+			params[key] = number
+		} else {
+			params[key] = value
+		}
+	}
+
+	raw, _ := json.Marshal(params)
+	return raw
+}
+
+// authenticated verifies the digest response of an RPC request
+func (d *Device) authenticated(auth *rpcAuth) bool {
+	if auth == nil || auth.Realm != d.hostname() || !equal(auth.Username, d.username()) {
+		return false
+	}
+
+	nonce, err := auth.Nonce.Int64()
+	if err != nil {
+		return false
+	}
+	issued := time.Unix(nonce, 0)
+	if age := d.now().Sub(issued); age < 0 || age > nonceValidity {
+		return false
+	}
+
+	ha1 := sha256Hex(auth.Username + ":" + auth.Realm + ":" + d.config.Password)
+	ha2 := sha256Hex(authHA2Input)
+	expected := sha256Hex(strings.Join([]string{
+		ha1, auth.Nonce.String(), "1", auth.CNonce, authQOP, ha2,
+	}, ":"))
+
+	return equal(auth.Response, expected)
+}
+
+// writeChallenge rejects a request with a digest authentication challenge, both as
+// WWW-Authenticate header and as JSON-RPC error like Shelly devices do
+func (d *Device) writeChallenge(w http.ResponseWriter, id json.RawMessage) {
+	nonce := d.now().Unix()
+	realm := d.hostname()
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+		`Digest qop="%s", realm="%s", nonce="%d", algorithm=%s`, authQOP, realm, nonce, authAlgorithm))
+
+	message, _ := json.Marshal(map[string]interface{}{
+		"auth_type": "digest",
+		"nonce":     nonce,
+		"nc":        1,
+		"realm":     realm,
+		"algorithm": authAlgorithm,
+	})
+
+	writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+		"id":    id,
+		"src":   realm,
+		"error": rpcError{Code: http.StatusUnauthorized, Message: string(message)},
+	})
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// roll reports whether an event with the given probability happens
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// validIndex reports whether s is a component index below count
+func validIndex(s string, count int) bool {
+	index, err := strconv.Atoi(s)
+	return err == nil && index >= 0 && index < count
+}
+
+// equal compares secrets in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// sha256Hex returns the hex encoded SHA-256 digest of s
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
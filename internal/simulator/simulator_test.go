@@ -0,0 +1,378 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aimar/shelly-prometheus-exporter/internal/client"
+	"github.com/aimar/shelly-prometheus-exporter/internal/config"
+	"github.com/aimar/shelly-prometheus-exporter/internal/discovery"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestDevice(t *testing.T, cfg DeviceConfig) (*Device, *httptest.Server) {
+	t.Helper()
+
+	device, err := NewDevice(cfg)
+	if err != nil {
+		t.Fatalf("NewDevice() error = %v", err)
+	}
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	return device, server
+}
+
+func newTestClient(url, password string) *client.Client {
+	cfg := &config.Config{
+		ScrapeTimeout: time.Second,
+		Auth: config.AuthConfig{
+			Username: "admin",
+			Password: config.Secret(password),
+		},
+	}
+	return client.New(url, cfg, logrus.New())
+}
+
+func TestNewDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DeviceConfig
+		wantMAC string
+		wantErr bool
+	}{
+		{
+			name:    "configured mac",
+			cfg:     DeviceConfig{Model: "pro3em", MAC: "c8:f0:9e:11:22:33"},
+			wantMAC: "C8F09E112233",
+		},
+		{
+			name: "model names are case insensitive",
+			cfg:  DeviceConfig{Model: "PlugS"},
+		},
+		{
+			name:    "unknown model",
+			cfg:     DeviceConfig{Model: "shelly-unknown"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid fault rate",
+			cfg:     DeviceConfig{Model: "1pm", Faults: FaultConfig{TimeoutRate: 2}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, err := NewDevice(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewDevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(device.MAC()) != 12 {
+				t.Errorf("MAC() = %v, want 12 hex digits", device.MAC())
+			}
+			if tt.wantMAC != "" && device.MAC() != tt.wantMAC {
+				t.Errorf("MAC() = %v, want %v", device.MAC(), tt.wantMAC)
+			}
+		})
+	}
+}
+
+func TestDevice_Shelly(t *testing.T) {
+	for _, name := range Models() {
+		t.Run(name, func(t *testing.T) {
+			simulated, server := newTestDevice(t, DeviceConfig{Model: name, Name: "Simulated " + name})
+
+			device, err := discovery.Probe(context.Background(), http.DefaultClient, discovery.Candidate{URL: server.URL})
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if device.Model != simulated.Model().Type {
+				t.Errorf("Probe() model = %v, want %v", device.Model, simulated.Model().Type)
+			}
+			if device.Gen != simulated.Model().Gen {
+				t.Errorf("Probe() gen = %v, want %v", device.Gen, simulated.Model().Gen)
+			}
+			if device.MAC != discovery.FormatMAC(simulated.MAC()) {
+				t.Errorf("Probe() mac = %v, want %v", device.MAC, simulated.MAC())
+			}
+		})
+	}
+}
+
+func TestDevice_Gen2Status(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{name: "open"},
+		{name: "digest authentication", password: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, server := newTestDevice(t, DeviceConfig{
+				Model:    "pro3em",
+				Password: tt.password,
+				Energy:   1000,
+				Profile:  ProfileConfig{Type: ProfileConstant, Watts: 2000},
+			})
+
+			start := device.start
+			device.now = func() time.Time { return start.Add(time.Hour) }
+
+			status, err := newTestClient(server.URL, tt.password).GetStatus(context.Background())
+			if err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+
+			if status.Sys.Mac != device.MAC() {
+				t.Errorf("Sys.Mac = %v, want %v", status.Sys.Mac, device.MAC())
+			}
+			if status.Sys.Uptime != 3600 {
+				t.Errorf("Sys.Uptime = %v, want 3600", status.Sys.Uptime)
+			}
+			if status.EM.TotalActPower != 2000 {
+				t.Errorf("EM.TotalActPower = %v, want 2000", status.EM.TotalActPower)
+			}
+			if sum := status.EM.AActPower + status.EM.BActPower + status.EM.CActPower; sum != 2000 {
+				t.Errorf("Phase power sum = %v, want 2000", sum)
+			}
+			if status.EMData.TotalAct != 3000 {
+				t.Errorf("EMData.TotalAct = %v, want 3000", status.EMData.TotalAct)
+			}
+			if status.Wifi.StaIP != "127.0.0.1" {
+				t.Errorf("Wifi.StaIP = %v, want 127.0.0.1", status.Wifi.StaIP)
+			}
+		})
+	}
+}
+
+func TestDevice_Gen2AuthRequired(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "plus1pm", Password: "secret"})
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{name: "no password"},
+		{name: "wrong password", password: "wrong"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestClient(server.URL, tt.password).GetDeviceInfo(context.Background())
+			if err == nil || !strings.Contains(err.Error(), client.ErrAuthRequired.Error()) {
+				t.Errorf("GetDeviceInfo() error = %v, want %v", err, client.ErrAuthRequired)
+			}
+		})
+	}
+}
+
+func TestDevice_Gen2Methods(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "pro3em", Name: "Main Meter"})
+	c := newTestClient(server.URL, "")
+
+	info, err := c.GetDeviceInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+	if info.Name != "Main Meter" || info.Model != "SPEM-003CEBEU" || info.Profile != "triphase" {
+		t.Errorf("GetDeviceInfo() = %+v", info)
+	}
+
+	sysConfig, err := c.GetSysConfig(context.Background())
+	if err != nil {
+		t.Fatalf("GetSysConfig() error = %v", err)
+	}
+	if sysConfig.Device.Name != "Main Meter" {
+		t.Errorf("GetSysConfig() name = %v, want Main Meter", sysConfig.Device.Name)
+	}
+
+	if _, err := c.GetEMDataStatus(context.Background(), 0); err != nil {
+		t.Errorf("GetEMDataStatus(0) error = %v", err)
+	}
+	if _, err := c.GetEMDataStatus(context.Background(), 1); err == nil {
+		t.Error("GetEMDataStatus(1) expected error, got nil")
+	}
+
+	var rpcErr *client.RPCError
+	err = c.Call(context.Background(), "Light.GetStatus", map[string]int{"id": 0}, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != http.StatusNotFound {
+		t.Errorf("Call(Light.GetStatus) error = %v, want rpc error 404", err)
+	}
+
+	// GET requests take parameters from the query string
+	resp, err := http.Get(server.URL + "/rpc/EMData.GetStatus?id=0")
+	if err != nil {
+		t.Fatalf("GET EMData.GetStatus error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET EMData.GetStatus status = %v, want 200", resp.StatusCode)
+	}
+}
+
+func TestDevice_Gen2Switch(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "plus1pm", Profile: ProfileConfig{Type: ProfileConstant, Watts: 60}})
+
+	var status struct {
+		Output bool    `json:"output"`
+		APower float64 `json:"apower"`
+	}
+	if err := newTestClient(server.URL, "").Call(context.Background(), "Switch.GetStatus", map[string]int{"id": 0}, &status); err != nil {
+		t.Fatalf("Call(Switch.GetStatus) error = %v", err)
+	}
+	if !status.Output || status.APower != 60 {
+		t.Errorf("Switch.GetStatus = %+v, want output on with 60 W", status)
+	}
+}
+
+func TestDevice_Gen1Status(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		password string
+	}{
+		{name: "1pm", model: "1pm"},
+		{name: "plug s with basic authentication", model: "plugs", password: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, server := newTestDevice(t, DeviceConfig{
+				Model:    tt.model,
+				Password: tt.password,
+				Profile:  ProfileConfig{Type: ProfileConstant, Watts: 120},
+			})
+
+			start := device.start
+			device.now = func() time.Time { return start.Add(30 * time.Minute) }
+
+			status, err := newTestClient(server.URL, tt.password).GetStatus(context.Background())
+			if err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+
+			if status.Mac != device.MAC() {
+				t.Errorf("Mac = %v, want %v", status.Mac, device.MAC())
+			}
+			if len(status.Relays) != 1 || !status.Relays[0].IsOn {
+				t.Errorf("Relays = %+v, want one relay switched on", status.Relays)
+			}
+			if status.EM.TotalActPower != 120 {
+				t.Errorf("EM.TotalActPower = %v, want 120", status.EM.TotalActPower)
+			}
+			// Gen1 devices report 3600 watt-minutes, converted to 60 Wh
+			if status.EMData.TotalAct != 60 {
+				t.Errorf("EMData.TotalAct = %v, want 60", status.EMData.TotalAct)
+			}
+		})
+	}
+}
+
+func TestDevice_Gen1AuthRequired(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "1pm", Password: "secret"})
+
+	for _, path := range []string{"/status", "/settings", "/meter/0", "/relay/0"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s status = %v, want 401", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestDevice_Sensor(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "ht"})
+
+	raw, err := newTestClient(server.URL, "").GetRawStatus(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetRawStatus() error = %v", err)
+	}
+
+	var status struct {
+		Tmp struct {
+			TC float64 `json:"tC"`
+		} `json:"tmp"`
+		Hum struct {
+			Value float64 `json:"value"`
+		} `json:"hum"`
+		Bat struct {
+			Value int `json:"value"`
+		} `json:"bat"`
+		Relays []interface{} `json:"relays"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Tmp.TC != sensorTemperature || status.Hum.Value != sensorHumidity || status.Bat.Value != sensorBattery {
+		t.Errorf("Sensor status = %+v", status)
+	}
+	if status.Relays != nil {
+		t.Errorf("Relays = %v, want none", status.Relays)
+	}
+}
+
+func TestDevice_Faults(t *testing.T) {
+	tests := []struct {
+		name    string
+		faults  FaultConfig
+		wantErr string
+	}{
+		{
+			name:    "timeout",
+			faults:  FaultConfig{TimeoutRate: 1},
+			wantErr: "Client.Timeout",
+		},
+		{
+			name:    "malformed json",
+			faults:  FaultConfig{MalformedRate: 1},
+			wantErr: "failed to decode JSON response",
+		},
+		{
+			name:    "server error",
+			faults:  FaultConfig{ErrorRate: 1},
+			wantErr: "unexpected status code: 500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := newTestDevice(t, DeviceConfig{Model: "1pm", Faults: tt.faults})
+
+			cfg := &config.Config{ScrapeTimeout: 200 * time.Millisecond}
+			_, err := client.New(server.URL, cfg, logrus.New()).GetStatus(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GetStatus() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDevice_Delay(t *testing.T) {
+	_, server := newTestDevice(t, DeviceConfig{Model: "1pm", Faults: FaultConfig{Delay: 100 * time.Millisecond}})
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/shelly")
+	if err != nil {
+		t.Fatalf("GET /shelly error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Response after %v, want at least 100ms", elapsed)
+	}
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store keeps values derived by the exporter, such as monotonic counters, across restarts
+type Store interface {
+	// Load decodes the value stored under key into v and reports whether the key was found
+	Load(key string, v interface{}) (bool, error)
+	// Save stores v under key. The value is encoded right away and persisted on the next flush.
+	Save(key string, v interface{}) error
+}
+
+// FileStore is a Store that writes every key to <key>.json in a directory. Files are
+// replaced atomically, so a crash leaves either the previous or the new value behind.
+// Without a directory the values are only kept in memory.
+type FileStore struct {
+	dir    string
+	logger *logrus.Logger
+
+	// values holds the encoded values loaded or saved so far
+	values map[string][]byte
+	// dirty holds the keys saved since the last flush
+	dirty map[string]bool
+
+	mu sync.Mutex
+}
+
+// Open opens the store in the given directory, creating it if needed. An empty directory
+// opens a store that is kept in memory.
+func Open(dir string, logger *logrus.Logger) (*FileStore, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create state directory: %w", err)
+		}
+	}
+
+	return &FileStore{
+		dir:    dir,
+		logger: logger,
+		values: make(map[string][]byte),
+		dirty:  make(map[string]bool),
+	}, nil
+}
+
+// Dir returns the directory of the store, empty if it is kept in memory
+func (s *FileStore) Dir() string {
+	return s.dir
+}
+
+// path returns the file holding a key
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || filepath.Base(key) != key || key[0] == '.' {
+		return "", fmt.Errorf("invalid state key %q", key)
+	}
+	return filepath.Join(s.dir, key+".json"), nil
+}
+
+// Load implements Store
+func (s *FileStore) Load(key string, v interface{}) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.values[key]
+	if !ok {
+		if s.dir == "" {
+			return false, nil
+		}
+
+		data, err = os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read state %s: %w", key, err)
+		}
+		s.values[key] = data
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode state %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Save implements Store
+func (s *FileStore) Save(key string, v interface{}) error {
+	if _, err := s.path(key); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = data
+	s.dirty[key] = true
+	return nil
+}
+
+// Flush writes the keys saved since the last flush to the directory
+func (s *FileStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		clear(s.dirty)
+		return nil
+	}
+
+	keys := make([]string, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		path, _ := s.path(key)
+		if err := writeFile(path, s.values[key]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write state %s: %w", key, err))
+			continue
+		}
+		delete(s.dirty, key)
+	}
+
+	if len(errs) == 0 && len(keys) > 0 {
+		// Persist the renames
+		if err := syncDir(s.dir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync state directory: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run flushes the store on every interval until the context is cancelled. The final
+// flush is left to the caller, after everything that saves values has stopped.
+func (s *FileStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.logger.WithError(err).Warn("Failed to flush state")
+			}
+		}
+	}
+}
+
+// writeFile replaces the file at path with data by writing a temporary file in the same
+// directory, syncing it to disk and renaming it over the original
+func writeFile(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// syncDir syncs a directory so that renames within it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	return d.Sync()
+}
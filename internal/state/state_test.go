@@ -0,0 +1,159 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testValue struct {
+	Count int `json:"count"`
+}
+
+func TestFileStore_SaveLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	store, err := Open(dir, logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	var value testValue
+	if found, err := store.Load("counts", &value); err != nil || found {
+		t.Errorf("Load() of missing key = %v, %v, want false, nil", found, err)
+	}
+
+	if err := store.Save("counts", testValue{Count: 3}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if found, err := store.Load("counts", &value); err != nil || !found || value.Count != 3 {
+		t.Errorf("Load() = %+v, %v, %v, want count 3", value, found, err)
+	}
+
+	// Saved values are only written on flush
+	if _, err := os.Stat(filepath.Join(dir, "counts.json")); !os.IsNotExist(err) {
+		t.Errorf("counts.json exists before Flush(): %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	reopened, err := Open(dir, logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	value = testValue{}
+	if found, err := reopened.Load("counts", &value); err != nil || !found || value.Count != 3 {
+		t.Errorf("Load() after reopening = %+v, %v, %v, want count 3", value, found, err)
+	}
+
+	// Only the state file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "counts.json" {
+		t.Errorf("state directory holds %v, want counts.json", entries)
+	}
+}
+
+func TestFileStore_Memory(t *testing.T) {
+	store, err := Open("", logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := store.Save("counts", testValue{Count: 1}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+
+	var value testValue
+	if found, err := store.Load("counts", &value); err != nil || !found || value.Count != 1 {
+		t.Errorf("Load() = %+v, %v, %v, want count 1", value, found, err)
+	}
+}
+
+func TestFileStore_InvalidKey(t *testing.T) {
+	store, err := Open(t.TempDir(), logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	for _, key := range []string{"", "../counts", "dir/counts", ".counts"} {
+		if err := store.Save(key, testValue{}); err == nil {
+			t.Errorf("Save(%q) expected error", key)
+		}
+		if _, err := store.Load(key, &testValue{}); err == nil {
+			t.Errorf("Load(%q) expected error", key)
+		}
+	}
+}
+
+func TestFileStore_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "counts.json"), []byte(`{"count":`), 0600); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+
+	store, err := Open(dir, logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := store.Load("counts", &testValue{}); err == nil {
+		t.Error("Load() expected error for corrupt state")
+	}
+
+	// Saving replaces the corrupt file
+	if err := store.Save("counts", testValue{Count: 2}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "counts.json"))
+	if err != nil || string(data) != `{"count":2}` {
+		t.Errorf("counts.json = %s, %v, want {\"count\":2}", data, err)
+	}
+}
+
+func TestFileStore_Run(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, logrus.New())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := store.Save("counts", testValue{Count: 5}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "counts.json")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run() did not flush the store")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return after the context was cancelled")
+	}
+}